@@ -3,6 +3,7 @@ package api
 import (
 	"encoding/json"
 	"net/http"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
@@ -10,20 +11,57 @@ import (
 	"github.com/thekb/chroma-takehome/store"
 )
 
-// routes
+// routes, every route needs 'Authorization: Bearer <token>' or the
+// X-API-Key header, an admin token for /admin and a user token for /store
 // POST /admin/addtable
+// POST /admin/droptable
 // POST /admin/adduser
+// POST /admin/renameuser
+// POST /admin/disableuser
+// POST /admin/enableuser
+// POST /admin/deleteuser
+// POST /admin/addapikey
+// POST /admin/listapikeys
+// POST /admin/rotateapikey
+// POST /admin/revokeapikey
 // POST /admin/addpermission
+// POST /admin/removepermission
+// POST /admin/expiringpermissions
+// POST /admin/setuserattribute
+// POST /admin/addrowpolicy
+// POST /admin/removerowpolicy
+// POST /admin/addrole
+// POST /admin/deleterole
+// POST /admin/addrolepermission
+// POST /admin/removerolepermission
+// POST /admin/addgroup
+// POST /admin/deletegroup
+// POST /admin/addgroupmember
+// POST /admin/removegroupmember
+// POST /admin/bindrole
+// POST /admin/unbindrole
+// POST /admin/adddenyrule
+// POST /admin/removedenyrule
+// POST /admin/addadmin
+// POST /admin/setadminroles
+// POST /admin/rotateadminkey
+// POST /admin/deleteadmin
+// POST /admin/searchaudit
+// POST /admin/verifyaudit
+// POST /admin/quotausage
 // POST /store/query
 // POST /store/exec
+// POST /store/sql
 
 type AdminAddTableRequest struct {
-	Token string `json:"token"`
 	store.CreateTableOptions
 }
 
+type AdminDropTableRequest struct {
+	TableName string `json:"tableName"`
+}
+
 type AdminAddUserRequest struct {
-	Token    string `json:"token"`
 	UserName string `json:"userName"`
 }
 
@@ -32,52 +70,349 @@ type AdminAddUserResponse struct {
 	UserToken string `json:"userToken"`
 }
 
+type AdminRenameUserRequest struct {
+	UserName    string `json:"userName"`
+	NewUserName string `json:"newUserName"`
+}
+
+// used by disableuser, enableuser and deleteuser
+type AdminUserRequest struct {
+	UserName string `json:"userName"`
+}
+
+type AdminAddAPIKeyRequest struct {
+	UserName string `json:"userName"`
+	Label    string `json:"label"`
+	// optional, the key stops working at this time
+	ExpiresAt time.Time `json:"expiresAt"`
+	// optional, limits the key to a subset of the permissions of the user
+	Scope []store.KeyScope `json:"scope"`
+	// optional, mint a key for signing requests instead of a bearer token
+	Signing bool `json:"signing"`
+}
+
+// returned when a key is minted, the token is not returned again
+type AdminAPIKeyResponse struct {
+	KeyID     string `json:"keyId"`
+	UserToken string `json:"userToken"`
+}
+
+type AdminListAPIKeysResponse struct {
+	Keys []store.APIKey `json:"keys"`
+}
+
+type AdminRotateAPIKeyRequest struct {
+	KeyID string `json:"keyId"`
+	// duration such as '24h' for which the old key keeps working,
+	// empty to revoke it right away
+	GracePeriod string `json:"gracePeriod"`
+}
+
+type AdminRevokeAPIKeyRequest struct {
+	KeyID string `json:"keyId"`
+}
+
 type AdminAddPermissionRequest struct {
-	Token       string   `json:"token"`
 	UserName    string   `json:"userName"`
 	TableName   string   `json:"tableName"`
 	Permissions []string `json:"permissions"`
+	// limit the permissions to these columns, empty for all columns
+	Columns []string `json:"columns"`
+	// optional window in which the permissions are in effect
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+// all fields are optional, empty matches every event
+type AdminSearchAuditRequest struct {
+	UserName  string    `json:"userName"`
+	TableName string    `json:"tableName"`
+	From      time.Time `json:"from"`
+	To        time.Time `json:"to"`
+	// newest events returned, all of them when 0
+	Limit int `json:"limit"`
+}
+
+type AdminSearchAuditResponse struct {
+	Events []store.AuditEvent `json:"events"`
+}
+
+type AdminVerifyAuditRequest struct {
+	store.AuditVerifyOptions
+}
+
+type AdminVerifyAuditResponse struct {
+	store.AuditVerification
+}
+
+type AdminQuotaUsageResponse struct {
+	Usage []QuotaUsage `json:"usage"`
+}
+
+type AdminExpiringPermissionsRequest struct {
+	// duration such as '24h', permissions ending within it are returned
+	Within string `json:"within"`
+}
+
+type ExpiringPermission struct {
+	UserName   string    `json:"userName"`
+	TableName  string    `json:"tableName"`
+	Permission string    `json:"permission"`
+	Columns    []string  `json:"columns"`
+	NotAfter   time.Time `json:"notAfter"`
+}
+
+type AdminExpiringPermissionsResponse struct {
+	Permissions []ExpiringPermission `json:"permissions"`
+}
+
+type AdminRemovePermissionRequest struct {
+	UserName    string   `json:"userName"`
+	TableName   string   `json:"tableName"`
+	Permissions []string `json:"permissions"`
+}
+
+type AdminSetUserAttributeRequest struct {
+	UserName string        `json:"userName"`
+	Name     string        `json:"name"`
+	Values   []interface{} `json:"values"`
+}
+
+type AdminAddRowPolicyRequest struct {
+	store.RowPolicy
+}
+
+type AdminRemoveRowPolicyRequest struct {
+	TableName string `json:"tableName"`
+	Name      string `json:"name"`
+}
+
+// used by addrole and deleterole
+type AdminRoleRequest struct {
+	RoleName string `json:"roleName"`
+}
+
+// used by addrolepermission and removerolepermission
+type AdminRolePermissionRequest struct {
+	RoleName    string   `json:"roleName"`
+	TableName   string   `json:"tableName"`
+	Permissions []string `json:"permissions"`
+	// limit the permissions to these columns, empty for all columns
+	Columns []string `json:"columns"`
+}
+
+// used by addgroup and deletegroup
+type AdminGroupRequest struct {
+	GroupName string `json:"groupName"`
+}
+
+// used by addgroupmember and removegroupmember
+type AdminGroupMemberRequest struct {
+	GroupName string `json:"groupName"`
+	UserName  string `json:"userName"`
+}
+
+// used by bindrole and unbindrole, exactly one of UserName or GroupName
+// has to be set
+type AdminRoleBindingRequest struct {
+	RoleName  string `json:"roleName"`
+	UserName  string `json:"userName"`
+	GroupName string `json:"groupName"`
+}
+
+// exactly one of UserName, GroupName or RoleName has to be set
+type AdminAddDenyRuleRequest struct {
+	Name         string `json:"name"`
+	UserName     string `json:"userName"`
+	GroupName    string `json:"groupName"`
+	RoleName     string `json:"roleName"`
+	TablePattern string `json:"tablePattern"`
+	// one of the permissions or '*'
+	Permission string `json:"permission"`
+}
+
+type AdminRemoveDenyRuleRequest struct {
+	Name string `json:"name"`
+}
+
+type AdminAddAdminRequest struct {
+	AdminName string `json:"adminName"`
+	// one or more of user-manager, schema-manager, grant-manager, auditor
+	// and superadmin
+	Roles []string `json:"roles"`
+}
+
+// returned when an admin key is minted, the token is not returned again
+type AdminAddAdminResponse struct {
+	AdminName  string `json:"adminName"`
+	AdminToken string `json:"adminToken"`
+}
+
+type AdminSetAdminRolesRequest struct {
+	AdminName string   `json:"adminName"`
+	Roles     []string `json:"roles"`
+}
+
+// used by rotateadminkey and deleteadmin
+type AdminAdminRequest struct {
+	AdminName string `json:"adminName"`
 }
 
 type StoreQueryRequest struct {
-	Token string `json:"token"`
 	store.QueryOptions
 }
 
 type StoreQueryResponse struct {
 	Results store.QueryResult `json:"results"`
+	// pass as cursor with otherwise the same request for the next page,
+	// empty on the last page
+	NextCursor string `json:"nextCursor,omitempty"`
 }
 
 type StoreExecRequest struct {
-	Token string `json:"token"`
 	store.ExecOptions
 }
 
-func NewStoreHandler(as store.AdminStore, ps store.DelegatedStore) http.Handler {
+type StoreSQLRequest struct {
+	SQL string `json:"sql"`
+	// values of the '?' placeholders in order
+	Args []interface{} `json:"args"`
+}
+
+type handlerOptions struct {
+	authenticator store.Authenticator
+	// clock skew allowed for signed requests, 0 when signing is disabled
+	maxSignatureSkew time.Duration
+	// body size of signed requests, which are read before they are
+	// authenticated
+	maxSignedBodySize int64
+	// maps verified client certificates to users, nil when disabled
+	certificateUserName CertificateUserName
+	// searched by /admin/searchaudit, nil when auditing is disabled
+	auditStore store.AuditStore
+	// rate limits and quotas of /store requests, nil when unlimited
+	limits *Limits
+}
+
+type HandlerOption func(*handlerOptions)
+
+// WithAuthenticator sets how users of /store are authenticated, by default
+// only api keys are accepted. Use store.NewChainAuthenticator to accept api
+// keys along with other tokens.
+func WithAuthenticator(a store.Authenticator) HandlerOption {
+	return func(o *handlerOptions) {
+		o.authenticator = a
+	}
+}
+
+// WithRequestSigning lets /store callers sign their requests with a signing
+// key instead of sending a bearer token, see SignRequest. Requests signed
+// more than maxSkew away from the time of the server are rejected.
+func WithRequestSigning(maxSkew time.Duration) HandlerOption {
+	return func(o *handlerOptions) {
+		o.maxSignatureSkew = maxSkew
+	}
+}
+
+// DefaultMaxSignedBodySize is the largest body of a signed request unless
+// set with WithMaxSignedBodySize.
+const DefaultMaxSignedBodySize = 1 << 20
+
+// WithMaxSignedBodySize sets the largest body of a signed request, larger
+// ones get 413 before their signature is checked.
+func WithMaxSignedBodySize(n int64) HandlerOption {
+	return func(o *handlerOptions) {
+		o.maxSignedBodySize = n
+	}
+}
+
+// WithAuditStore serves /admin/searchaudit from audit, which should be the
+// audit store of the delegated store.
+func WithAuditStore(audit store.AuditStore) HandlerOption {
+	return func(o *handlerOptions) {
+		o.auditStore = audit
+	}
+}
+
+func NewStoreHandler(as store.AdminStore, ps store.DelegatedStore, opts ...HandlerOption) http.Handler {
+	o := handlerOptions{
+		authenticator:     store.NewAPIKeyAuthenticator(as),
+		maxSignedBodySize: DefaultMaxSignedBodySize,
+	}
+	for _, opt := range opts {
+		opt(&o)
+	}
+
+	var lim *limiter
+	if o.limits != nil {
+		lim = newLimiter(*o.limits)
+	}
+
 	r := chi.NewRouter()
 	r.Use(middleware.RequestID)
+	r.Use(storeRequestID)
 	r.Use(middleware.RealIP)
 	r.Use(middleware.Logger)
 	r.Use(middleware.Recoverer)
 
 	r.Route("/admin", func(r chi.Router) {
+		r.Use(authenticateAdmin(as))
 		r.Post("/addtable", adminAddTable(as))
+		r.Post("/droptable", adminDropTable(as))
 		r.Post("/adduser", adminAddUser(as))
+		r.Post("/renameuser", adminRenameUser(as))
+		r.Post("/disableuser", adminSetUserDisabled(as, true))
+		r.Post("/enableuser", adminSetUserDisabled(as, false))
+		r.Post("/deleteuser", adminDeleteUser(as))
+		r.Post("/addapikey", adminAddAPIKey(as))
+		r.Post("/listapikeys", adminListAPIKeys(as))
+		r.Post("/rotateapikey", adminRotateAPIKey(as))
+		r.Post("/revokeapikey", adminRevokeAPIKey(as))
 		r.Post("/addpermission", adminAddPermission(as))
+		r.Post("/removepermission", adminRemovePermission(as))
+		r.Post("/expiringpermissions", adminExpiringPermissions(as))
+		r.Post("/setuserattribute", adminSetUserAttribute(as))
+		r.Post("/addrowpolicy", adminAddRowPolicy(as))
+		r.Post("/removerowpolicy", adminRemoveRowPolicy(as))
+		r.Post("/addrole", adminAddRole(as))
+		r.Post("/deleterole", adminDeleteRole(as))
+		r.Post("/addrolepermission", adminAddRolePermission(as))
+		r.Post("/removerolepermission", adminRemoveRolePermission(as))
+		r.Post("/addgroup", adminAddGroup(as))
+		r.Post("/deletegroup", adminDeleteGroup(as))
+		r.Post("/addgroupmember", adminSetGroupMember(as, true))
+		r.Post("/removegroupmember", adminSetGroupMember(as, false))
+		r.Post("/bindrole", adminSetRoleBinding(as, true))
+		r.Post("/unbindrole", adminSetRoleBinding(as, false))
+		r.Post("/adddenyrule", adminAddDenyRule(as))
+		r.Post("/removedenyrule", adminRemoveDenyRule(as))
+		r.Post("/addadmin", adminAddAdmin(as))
+		r.Post("/setadminroles", adminSetAdminRoles(as))
+		r.Post("/rotateadminkey", adminRotateAdminKey(as))
+		r.Post("/deleteadmin", adminDeleteAdmin(as))
+		r.Post("/searchaudit", adminSearchAudit(o.auditStore))
+		r.Post("/verifyaudit", adminVerifyAudit(o.auditStore))
+		r.Post("/quotausage", adminQuotaUsage(lim))
 	})
 
 	r.Route("/store", func(r chi.Router) {
-		r.Post("/query", storeQuery(ps))
-		r.Post("/exec", storeExec(ps))
+		r.Use(authenticateUser(as, o))
+		r.Post("/query", storeQuery(ps, lim))
+		r.Post("/exec", storeExec(ps, lim))
+		r.Post("/sql", storeSQL(ps, lim))
 	})
 
 	return r
 }
 
-const (
-	// harcoding token for now
-	adminToken = "12344567899"
-)
+// storeRequestID hands the request id set by middleware.RequestID to the
+// store, which records it in the audit log.
+func storeRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := store.ContextWithRequestID(r.Context(), middleware.GetReqID(r.Context()))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
 
 func adminAddTable(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
@@ -90,8 +425,7 @@ func adminAddTable(as store.AdminStore) func(w http.ResponseWriter, r *http.Requ
 			return
 		}
 
-		if req.Token != adminToken {
-			http.Error(w, "invalid admin token", http.StatusBadRequest)
+		if !authorizeAdmin(w, r, store.CapabilityManageSchema) {
 			return
 		}
 
@@ -106,6 +440,31 @@ func adminAddTable(as store.AdminStore) func(w http.ResponseWriter, r *http.Requ
 	}
 }
 
+func adminDropTable(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminDropTableRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageSchema) {
+			return
+		}
+
+		err = as.DropTable(r.Context(), req.TableName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
 func adminAddUser(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
@@ -117,8 +476,7 @@ func adminAddUser(as store.AdminStore) func(w http.ResponseWriter, r *http.Reque
 			return
 		}
 
-		if req.Token != adminToken {
-			http.Error(w, "invalid admin token", http.StatusBadRequest)
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
 			return
 		}
 
@@ -137,104 +495,1154 @@ func adminAddUser(as store.AdminStore) func(w http.ResponseWriter, r *http.Reque
 	}
 }
 
-func adminAddPermission(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+func adminAddAPIKey(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		var req AdminAddPermissionRequest
+		var req AdminAddAPIKeyRequest
 		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		if req.Token != adminToken {
-			http.Error(w, "invalid admin token", http.StatusBadRequest)
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
 			return
 		}
 
-		token, err := as.AddUser(r.Context(), req.UserName)
+		user, err := as.GetUserByName(r.Context(), req.UserName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		user, err := as.GetUser(r.Context(), token)
+		key, token, err := as.AddAPIKey(r.Context(), store.APIKeyOptions{
+			UserID:    user.ID,
+			Label:     req.Label,
+			ExpiresAt: req.ExpiresAt,
+			Scope:     req.Scope,
+			Signing:   req.Signing,
+		})
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
-		for _, perm := range req.Permissions {
-			err = as.AddPermission(r.Context(), user.ID, req.TableName, perm)
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(AdminAPIKeyResponse{
+			KeyID:     key.ID,
+			UserToken: token,
+		})
+	}
+}
+
+func adminListAPIKeys(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		keys, err := as.GetAPIKeys(r.Context(), user.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(AdminListAPIKeysResponse{
+			Keys: keys,
+		})
+	}
+}
+
+func adminRotateAPIKey(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRotateAPIKeyRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		var gracePeriod time.Duration
+		if req.GracePeriod != "" {
+			gracePeriod, err = time.ParseDuration(req.GracePeriod)
 			if err != nil {
 				http.Error(w, err.Error(), http.StatusBadRequest)
 				return
 			}
 		}
 
+		key, token, err := as.RotateAPIKey(r.Context(), req.KeyID, gracePeriod)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(AdminAPIKeyResponse{
+			KeyID:     key.ID,
+			UserToken: token,
+		})
 	}
 }
 
-func storeQuery(ds store.DelegatedStore) func(w http.ResponseWriter, r *http.Request) {
+func adminRevokeAPIKey(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		var opts StoreQueryRequest
-		err := json.NewDecoder(r.Body).Decode(&opts)
+		var req AdminRevokeAPIKeyRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		results, err := ds.AsUser(r.Context(), store.UserOptions{
-			Token: opts.Token,
-		}).Query(r.Context(), opts.QueryOptions)
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		err = as.RevokeAPIKey(r.Context(), req.KeyID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+	}
+}
 
-		err = json.NewEncoder(w).Encode(StoreQueryResponse{
-			Results: results,
-		})
+func adminRenameUser(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRenameUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.RenameUser(r.Context(), user.ID, req.NewUserName)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
+		w.WriteHeader(http.StatusOK)
 	}
 }
 
-func storeExec(ds store.DelegatedStore) func(w http.ResponseWriter, r *http.Request) {
+func adminSetUserDisabled(as store.AdminStore, disabled bool) func(w http.ResponseWriter, r *http.Request) {
 	return func(w http.ResponseWriter, r *http.Request) {
 		defer r.Body.Close()
 
-		var opts StoreExecRequest
-		err := json.NewDecoder(r.Body).Decode(&opts)
+		var req AdminUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		result, err := ds.AsUser(r.Context(), store.UserOptions{
-			Token: opts.Token,
-		}).Exec(r.Context(), opts.ExecOptions)
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.SetUserDisabled(r.Context(), user.ID, disabled)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminDeleteUser(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminUserRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.DeleteUser(r.Context(), user.ID)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
 		w.WriteHeader(http.StatusOK)
+	}
+}
 
-		err = json.NewEncoder(w).Encode(result)
+func adminAddPermission(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminAddPermissionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
 		if err != nil {
 			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, perm := range req.Permissions {
+			err = as.AddPermission(r.Context(), store.PermissionOptions{
+				UserID:     user.ID,
+				TableName:  req.TableName,
+				Permission: perm,
+				Columns:    req.Columns,
+				NotBefore:  req.NotBefore,
+				NotAfter:   req.NotAfter,
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminSearchAudit(audit store.AuditStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminSearchAuditRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityReadAudit) {
+			return
+		}
+
+		if audit == nil {
+			http.Error(w, "audit log is not enabled", http.StatusBadRequest)
+			return
+		}
+
+		events, err := audit.Search(r.Context(), store.AuditSearchOptions{
+			UserName:  req.UserName,
+			TableName: req.TableName,
+			From:      req.From,
+			To:        req.To,
+			Limit:     req.Limit,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(AdminSearchAuditResponse{Events: events})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+// adminVerifyAudit walks the hash chain of the audit log, a broken chain is
+// reported in the response rather than as an error.
+func adminVerifyAudit(audit store.AuditStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminVerifyAuditRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityReadAudit) {
+			return
+		}
+
+		if audit == nil {
+			http.Error(w, "audit log is not enabled", http.StatusBadRequest)
+			return
+		}
+
+		v, err := audit.Verify(r.Context(), req.AuditVerifyOptions)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(AdminVerifyAuditResponse{AuditVerification: *v})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+func adminQuotaUsage(lim *limiter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		if lim == nil {
+			http.Error(w, "limits are not enabled", http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err := json.NewEncoder(w).Encode(AdminQuotaUsageResponse{Usage: lim.currentUsage()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+func adminExpiringPermissions(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminExpiringPermissionsRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		within, err := time.ParseDuration(req.Within)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		perms, err := as.GetExpiringPermissions(r.Context(), time.Now().Add(within))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		resp := AdminExpiringPermissionsResponse{
+			Permissions: []ExpiringPermission{},
+		}
+		for _, perm := range perms {
+			resp.Permissions = append(resp.Permissions, ExpiringPermission{
+				UserName:   perm.UserName,
+				TableName:  perm.TableName,
+				Permission: perm.Permission,
+				Columns:    perm.Columns,
+				NotAfter:   perm.NotAfter,
+			})
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+
+		err = json.NewEncoder(w).Encode(resp)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+}
+
+func adminRemovePermission(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRemovePermissionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, perm := range req.Permissions {
+			err = as.RemovePermission(r.Context(), user.ID, req.TableName, perm)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminSetUserAttribute(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminSetUserAttributeRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageUsers) {
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.SetUserAttribute(r.Context(), user.ID, req.Name, req.Values)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminAddRowPolicy(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminAddRowPolicyRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageSchema) {
+			return
+		}
+
+		err = as.AddRowPolicy(r.Context(), req.RowPolicy)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminRemoveRowPolicy(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRemoveRowPolicyRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageSchema) {
+			return
+		}
+
+		err = as.RemoveRowPolicy(r.Context(), req.TableName, req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminAddRole(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRoleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		err = as.AddRole(r.Context(), req.RoleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminDeleteRole(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRoleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		role, err := as.GetRole(r.Context(), req.RoleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.DeleteRole(r.Context(), role.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminAddRolePermission(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRolePermissionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		role, err := as.GetRole(r.Context(), req.RoleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, perm := range req.Permissions {
+			err = as.AddRolePermission(r.Context(), store.RolePermissionOptions{
+				RoleID:     role.ID,
+				TableName:  req.TableName,
+				Permission: perm,
+				Columns:    req.Columns,
+			})
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminRemoveRolePermission(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRolePermissionRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		role, err := as.GetRole(r.Context(), req.RoleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		for _, perm := range req.Permissions {
+			err = as.RemoveRolePermission(r.Context(), role.ID, req.TableName, perm)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminAddGroup(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminGroupRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		err = as.AddGroup(r.Context(), req.GroupName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminDeleteGroup(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminGroupRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		group, err := as.GetGroup(r.Context(), req.GroupName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.DeleteGroup(r.Context(), group.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminSetGroupMember(as store.AdminStore, member bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminGroupMemberRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		group, err := as.GetGroup(r.Context(), req.GroupName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user, err := as.GetUserByName(r.Context(), req.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if member {
+			err = as.AddGroupMember(r.Context(), group.ID, user.ID)
+		} else {
+			err = as.RemoveGroupMember(r.Context(), group.ID, user.ID)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminSetRoleBinding(as store.AdminStore, bound bool) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRoleBindingRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		role, err := as.GetRole(r.Context(), req.RoleName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		binding := store.RoleBinding{RoleID: role.ID}
+		if req.UserName != "" {
+			user, err := as.GetUserByName(r.Context(), req.UserName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			binding.UserID = user.ID
+		}
+		if req.GroupName != "" {
+			group, err := as.GetGroup(r.Context(), req.GroupName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			binding.GroupID = group.ID
+		}
+
+		if bound {
+			err = as.BindRole(r.Context(), binding)
+		} else {
+			err = as.UnbindRole(r.Context(), binding)
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminAddDenyRule(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminAddDenyRuleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		rule := store.DenyRule{
+			Name:         req.Name,
+			TablePattern: req.TablePattern,
+			Permission:   req.Permission,
+		}
+		if req.UserName != "" {
+			user, err := as.GetUserByName(r.Context(), req.UserName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rule.UserID = user.ID
+		}
+		if req.GroupName != "" {
+			group, err := as.GetGroup(r.Context(), req.GroupName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rule.GroupID = group.ID
+		}
+		if req.RoleName != "" {
+			role, err := as.GetRole(r.Context(), req.RoleName)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			rule.RoleID = role.ID
+		}
+
+		err = as.AddDenyRule(r.Context(), rule)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminRemoveDenyRule(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminRemoveDenyRuleRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageGrants) {
+			return
+		}
+
+		err = as.RemoveDenyRule(r.Context(), req.Name)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminAddAdmin(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminAddAdminRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageAdmins) {
+			return
+		}
+
+		token, err := as.AddAdmin(r.Context(), store.AdminOptions{
+			Name:  req.AdminName,
+			Roles: req.Roles,
+		})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(AdminAddAdminResponse{
+			AdminName:  req.AdminName,
+			AdminToken: token,
+		})
+	}
+}
+
+func adminSetAdminRoles(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminSetAdminRolesRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageAdmins) {
+			return
+		}
+
+		admin, err := as.GetAdminByName(r.Context(), req.AdminName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.SetAdminRoles(r.Context(), admin.ID, req.Roles)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func adminRotateAdminKey(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminAdminRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageAdmins) {
+			return
+		}
+
+		admin, err := as.GetAdminByName(r.Context(), req.AdminName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		token, err := as.RotateAdminKey(r.Context(), admin.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		json.NewEncoder(w).Encode(AdminAddAdminResponse{
+			AdminName:  admin.Name,
+			AdminToken: token,
+		})
+	}
+}
+
+func adminDeleteAdmin(as store.AdminStore) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var req AdminAdminRequest
+		err := json.NewDecoder(r.Body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if !authorizeAdmin(w, r, store.CapabilityManageAdmins) {
+			return
+		}
+
+		admin, err := as.GetAdminByName(r.Context(), req.AdminName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		err = as.DeleteAdmin(r.Context(), admin.ID)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+func storeQuery(ds store.DelegatedStore, lim *limiter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		var opts StoreQueryRequest
+		err := json.NewDecoder(r.Body).Decode(&opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		runQuery(w, r, ds, lim, opts.QueryOptions)
+	}
+}
+
+func storeExec(ds store.DelegatedStore, lim *limiter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		body := &countingReader{r: r.Body}
+		var opts StoreExecRequest
+		err := json.NewDecoder(body).Decode(&opts)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		runExec(w, r, ds, lim, opts.ExecOptions, body.n)
+	}
+}
+
+// storeSQL compiles the statement into the options /store/query or
+// /store/exec take and answers like them.
+func storeSQL(ds store.DelegatedStore, lim *limiter) func(w http.ResponseWriter, r *http.Request) {
+	return func(w http.ResponseWriter, r *http.Request) {
+		defer r.Body.Close()
+
+		body := &countingReader{r: r.Body}
+		var req StoreSQLRequest
+		err := json.NewDecoder(body).Decode(&req)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		stmt, err := store.ParseSQL(req.SQL, req.Args...)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if stmt.Query != nil {
+			runQuery(w, r, ds, lim, *stmt.Query)
+		} else {
+			runExec(w, r, ds, lim, *stmt.Exec, body.n)
+		}
+	}
+}
+
+func runQuery(w http.ResponseWriter, r *http.Request, ds store.DelegatedStore, lim *limiter, opts store.QueryOptions) {
+	user, _ := store.UserFromContext(r.Context())
+	if err := lim.allow(user, opts.TableName); err != nil {
+		tooManyRequests(w, err)
+		return
+	}
+
+	us := ds.AsUser(r.Context(), store.UserOptions{})
+	results, err := us.Query(r.Context(), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var cursor string
+	if pager, ok := us.(store.PagedStore); ok {
+		cursor, err = pager.NextCursor(r.Context(), opts, results)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	// encoded up front so that the size counts against the quota
+	b, err := json.Marshal(StoreQueryResponse{
+		Results:    results,
+		NextCursor: cursor,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lim.record(user, opts.TableName, int64(len(results)), int64(len(b)))
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	w.Write(append(b, '\n'))
+}
+
+// runExec runs an exec whose request body was n bytes, which count against
+// the quota.
+func runExec(w http.ResponseWriter, r *http.Request, ds store.DelegatedStore, lim *limiter, opts store.ExecOptions, n int64) {
+	user, _ := store.UserFromContext(r.Context())
+	if err := lim.allow(user, opts.TableName); err != nil {
+		tooManyRequests(w, err)
+		return
+	}
+
+	result, err := ds.AsUser(r.Context(), store.UserOptions{}).Exec(r.Context(), opts)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	lim.record(user, opts.TableName, result.RowsAffected, n)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	err = json.NewEncoder(w).Encode(result)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
 	}
 }
@@ -2,19 +2,31 @@ package api_test
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"math/big"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/gavv/httpexpect/v2"
 	"github.com/thekb/chroma-takehome/api"
 	"github.com/thekb/chroma-takehome/store"
 )
 
-const (
-	adminToken = "12344567899"
-)
-
 func TestStoreAPISimple(t *testing.T) {
 	dataSource := ":memory:"
 	usf := store.NewUserStoreFactory()
@@ -24,6 +36,11 @@ func TestStoreAPISimple(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	ds := store.NewDelegatedStore(as, usf)
 
 	handler := api.NewStoreHandler(as, ds)
@@ -33,8 +50,7 @@ func TestStoreAPISimple(t *testing.T) {
 	e := httpexpect.Default(t, server.URL)
 
 	// add table
-	e.POST("/admin/addtable").WithJSON(api.AdminAddTableRequest{
-		Token: adminToken,
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
 		CreateTableOptions: store.CreateTableOptions{
 			TableName: "foo",
 			Definitions: [][]string{
@@ -45,23 +61,20 @@ func TestStoreAPISimple(t *testing.T) {
 	}).Expect().Status(http.StatusOK).NoContent()
 
 	// add user
-	obj := e.POST("/admin/adduser").WithJSON(api.AdminAddUserRequest{
-		Token:    adminToken,
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
 		UserName: "test-user",
 	}).Expect().Status(http.StatusOK).JSON().Object()
 
 	obj.Value("userName").IsString().IsEqual("test-user")
 	token := obj.Value("userToken").String().Raw()
 	// add permissions
-	e.POST("/admin/addpermission").WithJSON(api.AdminAddPermissionRequest{
-		Token:       adminToken,
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
 		UserName:    "test-user",
 		TableName:   "foo",
 		Permissions: []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION},
 	}).Expect().Status(http.StatusOK).NoContent()
 	//store exec
-	obj = e.POST("/store/exec").WithJSON(api.StoreExecRequest{
-		Token: token,
+	obj = e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
 		ExecOptions: store.ExecOptions{
 			Type:      store.ExecTypeInsert,
 			TableName: "foo",
@@ -75,8 +88,7 @@ func TestStoreAPISimple(t *testing.T) {
 	}).Expect().Status(http.StatusOK).JSON().Object()
 	obj.Value("lastInsertId").Number().Gt(0)
 	//store query
-	obj = e.POST("/store/query").WithJSON(api.StoreQueryRequest{
-		Token: token,
+	obj = e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
 		QueryOptions: store.QueryOptions{
 			TableName:      "foo",
 			IncludeColumns: []string{"id", "name"},
@@ -94,6 +106,11 @@ func TestStoreAPIRestricted(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	ds := store.NewDelegatedStore(as, usf)
 
 	handler := api.NewStoreHandler(as, ds)
@@ -103,8 +120,7 @@ func TestStoreAPIRestricted(t *testing.T) {
 	e := httpexpect.Default(t, server.URL)
 
 	// add table
-	e.POST("/admin/addtable").WithJSON(api.AdminAddTableRequest{
-		Token: adminToken,
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
 		CreateTableOptions: store.CreateTableOptions{
 			TableName: "foo",
 			Definitions: [][]string{
@@ -115,16 +131,14 @@ func TestStoreAPIRestricted(t *testing.T) {
 	}).Expect().Status(http.StatusOK).NoContent()
 
 	// add user 1
-	obj := e.POST("/admin/adduser").WithJSON(api.AdminAddUserRequest{
-		Token:    adminToken,
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
 		UserName: "test-user-1",
 	}).Expect().Status(http.StatusOK).JSON().Object()
 
 	obj.Value("userName").IsString().IsEqual("test-user-1")
 	token1 := obj.Value("userToken").String().Raw()
 	// add user 2
-	obj = e.POST("/admin/adduser").WithJSON(api.AdminAddUserRequest{
-		Token:    adminToken,
+	obj = e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
 		UserName: "test-user-2",
 	}).Expect().Status(http.StatusOK).JSON().Object()
 
@@ -132,24 +146,21 @@ func TestStoreAPIRestricted(t *testing.T) {
 	token2 := obj.Value("userToken").String().Raw()
 
 	// add permissions for user 1
-	e.POST("/admin/addpermission").WithJSON(api.AdminAddPermissionRequest{
-		Token:       adminToken,
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
 		UserName:    "test-user-1",
 		TableName:   "foo",
 		Permissions: []string{store.READ_RESTRICTED_PERMISSION, store.WRITE_RESTRICTED_PERMISSION},
 	}).Expect().Status(http.StatusOK).NoContent()
 
 	// add permissions for user 2
-	e.POST("/admin/addpermission").WithJSON(api.AdminAddPermissionRequest{
-		Token:       adminToken,
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
 		UserName:    "test-user-2",
 		TableName:   "foo",
 		Permissions: []string{store.READ_RESTRICTED_PERMISSION, store.WRITE_RESTRICTED_PERMISSION},
 	}).Expect().Status(http.StatusOK).NoContent()
 
 	//store exec for user 1
-	obj = e.POST("/store/exec").WithJSON(api.StoreExecRequest{
-		Token: token1,
+	obj = e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token1).WithJSON(api.StoreExecRequest{
 		ExecOptions: store.ExecOptions{
 			Type:      store.ExecTypeInsert,
 			TableName: "foo",
@@ -163,8 +174,7 @@ func TestStoreAPIRestricted(t *testing.T) {
 	}).Expect().Status(http.StatusOK).JSON().Object()
 	obj.Value("lastInsertId").Number().Gt(0)
 	//store exec for user 2
-	obj = e.POST("/store/exec").WithJSON(api.StoreExecRequest{
-		Token: token2,
+	obj = e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token2).WithJSON(api.StoreExecRequest{
 		ExecOptions: store.ExecOptions{
 			Type:      store.ExecTypeInsert,
 			TableName: "foo",
@@ -179,8 +189,7 @@ func TestStoreAPIRestricted(t *testing.T) {
 	obj.Value("lastInsertId").Number().Gt(0)
 
 	//store query for user 1
-	obj = e.POST("/store/query").WithJSON(api.StoreQueryRequest{
-		Token: token1,
+	obj = e.POST("/store/query").WithHeader("Authorization", "Bearer "+token1).WithJSON(api.StoreQueryRequest{
 		QueryOptions: store.QueryOptions{
 			TableName:      "foo",
 			IncludeColumns: []string{"id", "name"},
@@ -189,8 +198,7 @@ func TestStoreAPIRestricted(t *testing.T) {
 	obj.Value("results").Array().NotEmpty().Length().IsEqual(1)
 
 	//store query for user 2
-	obj = e.POST("/store/query").WithJSON(api.StoreQueryRequest{
-		Token: token2,
+	obj = e.POST("/store/query").WithHeader("Authorization", "Bearer "+token2).WithJSON(api.StoreQueryRequest{
 		QueryOptions: store.QueryOptions{
 			TableName:      "foo",
 			IncludeColumns: []string{"id", "name"},
@@ -209,6 +217,11 @@ func TestStoreAPIReadOnly(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	ds := store.NewDelegatedStore(as, usf)
 
 	handler := api.NewStoreHandler(as, ds)
@@ -218,8 +231,7 @@ func TestStoreAPIReadOnly(t *testing.T) {
 	e := httpexpect.Default(t, server.URL)
 
 	// add table
-	e.POST("/admin/addtable").WithJSON(api.AdminAddTableRequest{
-		Token: adminToken,
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
 		CreateTableOptions: store.CreateTableOptions{
 			TableName: "foo",
 			Definitions: [][]string{
@@ -230,23 +242,20 @@ func TestStoreAPIReadOnly(t *testing.T) {
 	}).Expect().Status(http.StatusOK).NoContent()
 
 	// add user
-	obj := e.POST("/admin/adduser").WithJSON(api.AdminAddUserRequest{
-		Token:    adminToken,
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
 		UserName: "test-user",
 	}).Expect().Status(http.StatusOK).JSON().Object()
 
 	obj.Value("userName").IsString().IsEqual("test-user")
 	token := obj.Value("userToken").String().Raw()
 	// add permissions
-	e.POST("/admin/addpermission").WithJSON(api.AdminAddPermissionRequest{
-		Token:       adminToken,
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
 		UserName:    "test-user",
 		TableName:   "foo",
 		Permissions: []string{store.READ_ALL_PERMISSION},
 	}).Expect().Status(http.StatusOK).NoContent()
 	//store exec
-	e.POST("/store/exec").WithJSON(api.StoreExecRequest{
-		Token: token,
+	e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
 		ExecOptions: store.ExecOptions{
 			Type:      store.ExecTypeInsert,
 			TableName: "foo",
@@ -259,8 +268,7 @@ func TestStoreAPIReadOnly(t *testing.T) {
 		},
 	}).Expect().Status(http.StatusBadRequest)
 	//store query
-	obj = e.POST("/store/query").WithJSON(api.StoreQueryRequest{
-		Token: token,
+	obj = e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
 		QueryOptions: store.QueryOptions{
 			TableName:      "foo",
 			IncludeColumns: []string{"id", "name"},
@@ -278,6 +286,11 @@ func TestStoreAPIWriteOnly(t *testing.T) {
 		t.Fatal(err)
 	}
 
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
 	ds := store.NewDelegatedStore(as, usf)
 
 	handler := api.NewStoreHandler(as, ds)
@@ -287,8 +300,7 @@ func TestStoreAPIWriteOnly(t *testing.T) {
 	e := httpexpect.Default(t, server.URL)
 
 	// add table
-	e.POST("/admin/addtable").WithJSON(api.AdminAddTableRequest{
-		Token: adminToken,
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
 		CreateTableOptions: store.CreateTableOptions{
 			TableName: "foo",
 			Definitions: [][]string{
@@ -299,23 +311,20 @@ func TestStoreAPIWriteOnly(t *testing.T) {
 	}).Expect().Status(http.StatusOK).NoContent()
 
 	// add user
-	obj := e.POST("/admin/adduser").WithJSON(api.AdminAddUserRequest{
-		Token:    adminToken,
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
 		UserName: "test-user",
 	}).Expect().Status(http.StatusOK).JSON().Object()
 
 	obj.Value("userName").IsString().IsEqual("test-user")
 	token := obj.Value("userToken").String().Raw()
 	// add permissions
-	e.POST("/admin/addpermission").WithJSON(api.AdminAddPermissionRequest{
-		Token:       adminToken,
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
 		UserName:    "test-user",
 		TableName:   "foo",
 		Permissions: []string{store.WRITE_ALL_PERMISSION},
 	}).Expect().Status(http.StatusOK).NoContent()
 	//store exec
-	obj = e.POST("/store/exec").WithJSON(api.StoreExecRequest{
-		Token: token,
+	obj = e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
 		ExecOptions: store.ExecOptions{
 			Type:      store.ExecTypeInsert,
 			TableName: "foo",
@@ -329,11 +338,1528 @@ func TestStoreAPIWriteOnly(t *testing.T) {
 	}).Expect().Status(http.StatusOK).JSON().Object()
 	obj.Value("lastInsertId").Number().Gt(0)
 	//store query
-	e.POST("/store/query").WithJSON(api.StoreQueryRequest{
-		Token: token,
+	e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+		QueryOptions: store.QueryOptions{
+			TableName:      "foo",
+			IncludeColumns: []string{"id", "name"},
+		},
+	}).Expect().Status(http.StatusBadRequest)
+}
+
+func TestStoreAPIAdminLifecycle(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	// add table
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	// add user
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	token := obj.Value("userToken").String().Raw()
+
+	// add permissions
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "test-user",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	query := func(status int) {
+		e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"id", "name"},
+			},
+		}).Expect().Status(status)
+	}
+	query(http.StatusOK)
+
+	// remove read permission
+	e.POST("/admin/removepermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRemovePermissionRequest{
+		UserName:    "test-user",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+	query(http.StatusBadRequest)
+
+	// rename and disable user
+	e.POST("/admin/renameuser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRenameUserRequest{
+		UserName:    "test-user",
+		NewUserName: "renamed-user",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/disableuser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusBadRequest)
+	e.POST("/admin/disableuser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminUserRequest{
+		UserName: "renamed-user",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
+		ExecOptions: store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		},
+	}).Expect().Status(http.StatusUnauthorized)
+	e.POST("/admin/enableuser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminUserRequest{
+		UserName: "renamed-user",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
+		ExecOptions: store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		},
+	}).Expect().Status(http.StatusOK)
+
+	// drop table
+	e.POST("/admin/droptable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminDropTableRequest{
+		TableName: "foo",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/droptable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminDropTableRequest{
+		TableName: "foo",
+	}).Expect().Status(http.StatusBadRequest)
+
+	// delete user
+	e.POST("/admin/deleteuser").WithHeader("Authorization", "Bearer not-the-admin-token").WithJSON(api.AdminUserRequest{
+		UserName: "renamed-user",
+	}).Expect().Status(http.StatusUnauthorized)
+	e.POST("/admin/deleteuser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminUserRequest{
+		UserName: "renamed-user",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/deleteuser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminUserRequest{
+		UserName: "renamed-user",
+	}).Expect().Status(http.StatusBadRequest)
+}
+
+func TestStoreAPIRowPolicy(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	// add table
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"region", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	// add user
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	token := obj.Value("userToken").String().Raw()
+
+	// add permissions
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "test-user",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	for _, region := range []string{"eu", "us"} {
+		e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
+			ExecOptions: store.ExecOptions{
+				Type:      store.ExecTypeInsert,
+				TableName: "foo",
+				Values:    []store.FieldValue{{Name: "region", Value: region}},
+			},
+		}).Expect().Status(http.StatusOK)
+	}
+
+	// restrict rows to the region of the user
+	e.POST("/admin/setuserattribute").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminSetUserAttributeRequest{
+		UserName: "test-user",
+		Name:     "region",
+		Values:   []interface{}{"eu"},
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/addrowpolicy").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddRowPolicyRequest{
+		RowPolicy: store.RowPolicy{
+			TableName: "foo",
+			Name:      "region",
+			Predicate: store.Eq("region", "$user.region"),
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	query := func() *httpexpect.Array {
+		return e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"id", "region"},
+			},
+		}).Expect().Status(http.StatusOK).JSON().Object().Value("results").Array()
+	}
+	query().Length().IsEqual(1)
+
+	e.POST("/admin/removerowpolicy").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRemoveRowPolicyRequest{
+		TableName: "foo",
+		Name:      "region",
+	}).Expect().Status(http.StatusOK).NoContent()
+	query().Length().IsEqual(2)
+}
+
+func TestStoreAPIRBAC(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	// add table
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	// add user
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	token := obj.Value("userToken").String().Raw()
+
+	// add role with grants and bind it to a group of the user
+	e.POST("/admin/addrole").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRoleRequest{
+		RoleName: "editor",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/addrolepermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRolePermissionRequest{
+		RoleName:    "editor",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/addgroup").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminGroupRequest{
+		GroupName: "editors",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/addgroupmember").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminGroupMemberRequest{
+		GroupName: "editors",
+		UserName:  "test-user",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/bindrole").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRoleBindingRequest{
+		RoleName:  "editor",
+		GroupName: "editors",
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	insert := store.ExecOptions{
+		Type:      store.ExecTypeInsert,
+		TableName: "foo",
+		Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+	}
+	e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
+		ExecOptions: insert,
+	}).Expect().Status(http.StatusOK)
+
+	e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
 		QueryOptions: store.QueryOptions{
 			TableName:      "foo",
 			IncludeColumns: []string{"id", "name"},
 		},
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("results").Array().Length().IsEqual(1)
+
+	// binding needs a known principal
+	e.POST("/admin/bindrole").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRoleBindingRequest{
+		RoleName: "editor",
+		UserName: "unknown",
+	}).Expect().Status(http.StatusBadRequest)
+
+	e.POST("/admin/unbindrole").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRoleBindingRequest{
+		RoleName:  "editor",
+		GroupName: "editors",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
+		ExecOptions: insert,
 	}).Expect().Status(http.StatusBadRequest)
+
+	e.POST("/admin/deletegroup").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminGroupRequest{
+		GroupName: "editors",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/deleterole").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRoleRequest{
+		RoleName: "editor",
+	}).Expect().Status(http.StatusOK).NoContent()
+}
+
+func TestStoreAPIDenyRule(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	// add table
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "payroll",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	// add user
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	token := obj.Value("userToken").String().Raw()
+
+	// add permissions
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "test-user",
+		TableName:   "payroll",
+		Permissions: []string{store.READ_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	query := func() *httpexpect.Response {
+		return e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      "payroll",
+				IncludeColumns: []string{"id", "name"},
+			},
+		}).Expect()
+	}
+	query().Status(http.StatusOK)
+
+	e.POST("/admin/adddenyrule").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddDenyRuleRequest{
+		Name:         "no-payroll",
+		UserName:     "test-user",
+		TablePattern: "payroll*",
+		Permission:   store.AllPermissions,
+	}).Expect().Status(http.StatusOK).NoContent()
+	query().Status(http.StatusBadRequest).Body().Contains("no-payroll")
+
+	e.POST("/admin/removedenyrule").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRemoveDenyRuleRequest{
+		Name: "no-payroll",
+	}).Expect().Status(http.StatusOK).NoContent()
+	query().Status(http.StatusOK)
+}
+
+func TestStoreAPIExpiringPermissions(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	// add table
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	// add user
+	obj := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	token := obj.Value("userToken").String().Raw()
+
+	// add permissions for the next hour
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "test-user",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION},
+		NotAfter:    time.Now().Add(time.Hour),
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+		QueryOptions: store.QueryOptions{
+			TableName:      "foo",
+			IncludeColumns: []string{"id", "name"},
+		},
+	}).Expect().Status(http.StatusOK)
+
+	expiring := func(within string) *httpexpect.Array {
+		return e.POST("/admin/expiringpermissions").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminExpiringPermissionsRequest{
+			Within: within,
+		}).Expect().Status(http.StatusOK).JSON().Object().Value("permissions").Array()
+	}
+	expiring("10m").Length().IsEqual(0)
+	perms := expiring("2h")
+	perms.Length().IsEqual(1)
+	perms.Value(0).Object().Value("userName").IsEqual("test-user")
+	perms.Value(0).Object().Value("permission").IsEqual(store.READ_ALL_PERMISSION)
+
+	e.POST("/admin/expiringpermissions").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminExpiringPermissionsRequest{
+		Within: "soon",
+	}).Expect().Status(http.StatusBadRequest)
+}
+
+func TestStoreAPIKeys(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	// add table
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	// add user
+	e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK)
+
+	// the token is only handed out once
+	e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusBadRequest)
+
+	// add permissions
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "test-user",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	obj := e.POST("/admin/addapikey").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddAPIKeyRequest{
+		UserName: "test-user",
+		Label:    "ci",
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	keyID := obj.Value("keyId").String().Raw()
+	token := obj.Value("userToken").String().Raw()
+
+	query := func(token string) *httpexpect.Response {
+		return e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"id", "name"},
+			},
+		}).Expect()
+	}
+	query(token).Status(http.StatusOK)
+
+	keys := e.POST("/admin/listapikeys").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("keys").Array()
+	keys.Length().IsEqual(2)
+	keys.Value(1).Object().Value("id").IsEqual(keyID)
+
+	obj = e.POST("/admin/rotateapikey").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRotateAPIKeyRequest{
+		KeyID: keyID,
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	rotatedID := obj.Value("keyId").String().Raw()
+	rotated := obj.Value("userToken").String().Raw()
+	query(token).Status(http.StatusUnauthorized)
+	query(rotated).Status(http.StatusOK)
+
+	e.POST("/admin/revokeapikey").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminRevokeAPIKeyRequest{
+		KeyID: rotatedID,
+	}).Expect().Status(http.StatusOK).NoContent()
+	query(rotated).Status(http.StatusUnauthorized)
+}
+
+func TestStoreAPIAdmins(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	addTable := func(token string) *httpexpect.Response {
+		return e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+token).WithJSON(api.AdminAddTableRequest{
+			CreateTableOptions: store.CreateTableOptions{
+				TableName: "foo",
+				Definitions: [][]string{
+					{"id", "integer", "not null", "primary key"},
+					{"name", "text"},
+				},
+				IfNotExists: true,
+			},
+		}).Expect()
+	}
+
+	// the old hardcoded token is gone
+	addTable("12344567899").Status(http.StatusUnauthorized)
+
+	obj := e.POST("/admin/addadmin").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddAdminRequest{
+		AdminName: "schema",
+		Roles:     []string{store.ADMIN_ROLE_SCHEMA_MANAGER},
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	obj.Value("adminName").IsEqual("schema")
+	schemaToken := obj.Value("adminToken").String().Raw()
+
+	// schema managers can create tables but not users or admins
+	addTable(schemaToken).Status(http.StatusOK)
+	e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+schemaToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusForbidden).Body().Contains(store.CapabilityManageUsers)
+	e.POST("/admin/addadmin").WithHeader("Authorization", "Bearer "+schemaToken).WithJSON(api.AdminAddAdminRequest{
+		AdminName: "other",
+		Roles:     []string{store.ADMIN_ROLE_SUPERADMIN},
+	}).Expect().Status(http.StatusForbidden)
+
+	// roles can be changed by a superadmin
+	e.POST("/admin/setadminroles").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminSetAdminRolesRequest{
+		AdminName: "schema",
+		Roles:     []string{store.ADMIN_ROLE_USER_MANAGER},
+	}).Expect().Status(http.StatusOK).NoContent()
+	addTable(schemaToken).Status(http.StatusForbidden)
+	e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+schemaToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK)
+
+	// rotation replaces the key right away
+	rotated := e.POST("/admin/rotateadminkey").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAdminRequest{
+		AdminName: "schema",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("adminToken").String().Raw()
+	e.POST("/admin/disableuser").WithHeader("Authorization", "Bearer "+schemaToken).WithJSON(api.AdminUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusUnauthorized)
+	e.POST("/admin/disableuser").WithHeader("Authorization", "Bearer "+rotated).WithJSON(api.AdminUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK)
+
+	// the last superadmin cannot be removed
+	e.POST("/admin/deleteadmin").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAdminRequest{
+		AdminName: "root",
+	}).Expect().Status(http.StatusBadRequest)
+	e.POST("/admin/deleteadmin").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAdminRequest{
+		AdminName: "schema",
+	}).Expect().Status(http.StatusOK).NoContent()
+	e.POST("/admin/disableuser").WithHeader("Authorization", "Bearer "+rotated).WithJSON(api.AdminUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusUnauthorized)
+}
+
+func TestStoreAPIAuthHeaders(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds)
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	// the api key header works the same as the bearer token
+	e.POST("/admin/addtable").WithHeader(api.APIKeyHeader, adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	token := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "test-user",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	query := func(name, value string) *httpexpect.Response {
+		req := e.POST("/store/query")
+		if name != "" {
+			req = req.WithHeader(name, value)
+		}
+		return req.WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"id", "name"},
+			},
+		}).Expect()
+	}
+
+	query("Authorization", "Bearer "+token).Status(http.StatusOK)
+	query(api.APIKeyHeader, token).Status(http.StatusOK)
+	query("", "").Status(http.StatusUnauthorized).Header("WWW-Authenticate").IsEqual("Bearer")
+	query("Authorization", "Basic "+token).Status(http.StatusUnauthorized)
+	// admin tokens are not user tokens and user tokens are not admin tokens
+	query("Authorization", "Bearer "+adminToken).Status(http.StatusUnauthorized)
+	e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+token).WithJSON(api.AdminAddUserRequest{
+		UserName: "other-user",
+	}).Expect().Status(http.StatusUnauthorized)
+
+	// tokens in the body are ignored
+	e.POST("/store/query").WithJSON(map[string]interface{}{
+		"token":          token,
+		"tableName":      "foo",
+		"includeColumns": []string{"id", "name"},
+	}).Expect().Status(http.StatusUnauthorized)
+}
+
+// staticAuthenticator authenticates a single token as a fixed user.
+type staticAuthenticator struct {
+	token string
+	user  *store.User
+}
+
+func (a staticAuthenticator) Authenticate(ctx context.Context, token string) (*store.User, error) {
+	if token != a.token {
+		return nil, store.ErrUnsupportedToken
+	}
+	return a.user, nil
+}
+
+func TestStoreAPIAuthenticator(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.EnsureUser(context.TODO(), "external-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "foo",
+		Permission: store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	apiKeyToken, err := as.AddUser(context.TODO(), "api-key-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds, api.WithAuthenticator(store.NewChainAuthenticator(
+		staticAuthenticator{token: "external-token", user: user},
+		store.NewAPIKeyAuthenticator(as),
+	)))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	query := func(token string) *httpexpect.Response {
+		return e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"id", "name"},
+			},
+		}).Expect()
+	}
+
+	query("external-token").Status(http.StatusOK)
+	// api keys still work, the user just has no grants
+	query(apiKeyToken).Status(http.StatusBadRequest)
+	query("unknown-token").Status(http.StatusUnauthorized)
+}
+
+func TestStoreAPISignedRequests(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf, store.WithSigningSecret([]byte("0123456789abcdef0123456789abcdef")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds, api.WithRequestSigning(time.Minute))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "service",
+	}).Expect().Status(http.StatusOK)
+
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "service",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	obj := e.POST("/admin/addapikey").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddAPIKeyRequest{
+		UserName: "service",
+		Label:    "signing",
+		Signing:  true,
+	}).Expect().Status(http.StatusOK).JSON().Object()
+	keyID := obj.Value("keyId").String().Raw()
+	token := obj.Value("userToken").String().Raw()
+
+	body := `{"tableName":"foo","includeColumns":["id","name"]}`
+	signed := func(nonce string, now time.Time, tamper func(r *http.Request)) *http.Request {
+		r, err := http.NewRequest(http.MethodPost, server.URL+"/store/query", strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Header.Set("Content-Type", "application/json")
+		if err := api.SignRequest(r, keyID, token, nonce, now); err != nil {
+			t.Fatal(err)
+		}
+		if tamper != nil {
+			tamper(r)
+		}
+		return r
+	}
+	status := func(r *http.Request) int {
+		resp, err := http.DefaultClient.Do(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		return resp.StatusCode
+	}
+
+	if got := status(signed("n1", time.Now(), nil)); got != http.StatusOK {
+		t.Fatalf("expected signed request to succeed, got %d", got)
+	}
+	for name, r := range map[string]*http.Request{
+		"replayed nonce": signed("n1", time.Now(), nil),
+		"old timestamp":  signed("n2", time.Now().Add(-2*time.Minute), nil),
+		"future":         signed("n3", time.Now().Add(2*time.Minute), nil),
+		"tampered body": signed("n4", time.Now(), func(r *http.Request) {
+			r.Body = io.NopCloser(strings.NewReader(`{"tableName":"bar"}`))
+			r.ContentLength = int64(len(`{"tableName":"bar"}`))
+		}),
+		"tampered path": signed("n5", time.Now(), func(r *http.Request) {
+			r.URL.Path = "/store/exec"
+		}),
+		"unknown key": signed("n6", time.Now(), func(r *http.Request) {
+			r.Header.Set(api.SignatureKeyHeader, "unknown")
+		}),
+		"missing nonce": signed("n7", time.Now(), func(r *http.Request) {
+			r.Header.Del(api.SignatureNonceHeader)
+		}),
+	} {
+		if got := status(r); got != http.StatusUnauthorized {
+			t.Fatalf("expected %s to be rejected, got %d", name, got)
+		}
+	}
+	// a rejected request does not use up its nonce
+	if got := status(signed("n4", time.Now(), nil)); got != http.StatusOK {
+		t.Fatalf("expected signed request to succeed, got %d", got)
+	}
+
+	// bodies are read before the signature is checked, so their size is
+	// capped
+	small := httptest.NewServer(api.NewStoreHandler(as, ds, api.WithRequestSigning(time.Minute), api.WithMaxSignedBodySize(16)))
+	defer small.Close()
+	r := signed("n9", time.Now(), nil)
+	r.URL, err = url.Parse(small.URL + "/store/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status(r); got != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected large signed request to be rejected, got %d", got)
+	}
+
+	// the signing token is no bearer token
+	e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+		QueryOptions: store.QueryOptions{
+			TableName:      "foo",
+			IncludeColumns: []string{"id", "name"},
+		},
+	}).Expect().Status(http.StatusUnauthorized)
+
+	// signatures are ignored unless signing is enabled
+	plain := httptest.NewServer(api.NewStoreHandler(as, ds))
+	defer plain.Close()
+	r = signed("n8", time.Now(), nil)
+	r.URL, err = url.Parse(plain.URL + "/store/query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := status(r); got != http.StatusUnauthorized {
+		t.Fatalf("expected signed request to be rejected, got %d", got)
+	}
+}
+
+// testCA issues certificates for TestStoreAPIClientCertificates.
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue returns a certificate for template signed by the CA.
+func (ca *testCA) issue(t *testing.T, template *x509.Certificate) tls.Certificate {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+	if err != nil {
+		t.Fatal(err)
+	}
+	template.SerialNumber = serial
+	template.NotBefore = time.Now().Add(-time.Hour)
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestStoreAPIClientCertificates(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"billing", "spiffe://example.com/reporting", "disabled"} {
+		user, err := as.EnsureUser(context.TODO(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = as.AddPermission(context.TODO(), store.PermissionOptions{
+			UserID:     user.ID,
+			TableName:  "foo",
+			Permission: store.READ_ALL_PERMISSION,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if name == "disabled" {
+			if err := as.SetUserDisabled(context.TODO(), user.ID, true); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	apiKeyToken, err := as.AddUser(context.TODO(), "api-key-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := newTestCA(t)
+	serverCert := ca.issue(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: "store"},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	serverKey, err := x509.MarshalPKCS8PrivateKey(serverCert.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientCert := func(ca *testCA, cn string, uris ...string) tls.Certificate {
+		template := &x509.Certificate{
+			Subject:     pkix.Name{CommonName: cn},
+			ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		}
+		for _, s := range uris {
+			u, err := url.Parse(s)
+			if err != nil {
+				t.Fatal(err)
+			}
+			template.URIs = append(template.URIs, u)
+		}
+		return ca.issue(t, template)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	serve := func(userName api.CertificateUserName) *httptest.Server {
+		handler := api.NewStoreHandler(as, ds, api.WithClientCertificates(userName))
+		srv, err := api.NewTLSServer("", handler, api.TLSOptions{
+			CertFile:     writePEM(t, "server.pem", "CERTIFICATE", serverCert.Certificate[0]),
+			KeyFile:      writePEM(t, "server.key", "PRIVATE KEY", serverKey),
+			ClientCAFile: writePEM(t, "ca.pem", "CERTIFICATE", ca.cert.Raw),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		server := httptest.NewUnstartedServer(handler)
+		server.TLS = srv.TLSConfig
+		server.StartTLS()
+		return server
+	}
+	query := func(server *httptest.Server, cert *tls.Certificate, token string) (int, error) {
+		config := &tls.Config{RootCAs: roots}
+		if cert != nil {
+			config.Certificates = []tls.Certificate{*cert}
+		}
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: config}}
+		defer client.CloseIdleConnections()
+
+		r, err := http.NewRequest(http.MethodPost, server.URL+"/store/query",
+			strings.NewReader(`{"tableName":"foo","includeColumns":["id","name"]}`))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			r.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := client.Do(r)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+		return resp.StatusCode, nil
+	}
+	expect := func(server *httptest.Server, cert *tls.Certificate, token string, status int) {
+		t.Helper()
+		got, err := query(server, cert, token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != status {
+			t.Fatalf("expected status %d, got %d", status, got)
+		}
+	}
+
+	byCN := serve(api.SubjectCommonName)
+	defer byCN.Close()
+
+	billing := clientCert(ca, "billing")
+	expect(byCN, &billing, "", http.StatusOK)
+	unknown := clientCert(ca, "unknown")
+	expect(byCN, &unknown, "", http.StatusUnauthorized)
+	disabled := clientCert(ca, "disabled")
+	expect(byCN, &disabled, "", http.StatusUnauthorized)
+	// tokens take precedence over certificates, the api key user has no grants
+	expect(byCN, &billing, apiKeyToken, http.StatusBadRequest)
+	// and still work without a certificate
+	expect(byCN, nil, apiKeyToken, http.StatusBadRequest)
+	expect(byCN, nil, "", http.StatusUnauthorized)
+
+	// certificates of other CAs are rejected during the handshake
+	forged := clientCert(newTestCA(t), "billing")
+	if _, err := query(byCN, &forged, ""); err == nil {
+		t.Fatal("expected certificate of an unknown ca to be rejected")
+	}
+
+	bySAN := serve(api.SubjectAltName)
+	defer bySAN.Close()
+
+	reporting := clientCert(ca, "billing", "spiffe://example.com/reporting")
+	expect(bySAN, &reporting, "", http.StatusOK)
+	expect(bySAN, &billing, "", http.StatusUnauthorized)
+}
+
+func TestStoreAPIAudit(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := store.NewAuditStore(context.TODO(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf, store.WithAuditStore(audit))
+
+	handler := api.NewStoreHandler(as, ds, api.WithAuditStore(audit))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	userToken := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+
+	e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+		UserName:    "test-user",
+		TableName:   "foo",
+		Permissions: []string{store.READ_ALL_PERMISSION},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	e.POST("/store/query").WithHeader("Authorization", "Bearer "+userToken).WithJSON(api.StoreQueryRequest{
+		QueryOptions: store.QueryOptions{
+			TableName:      "foo",
+			IncludeColumns: []string{"id", "name"},
+		},
+	}).Expect().Status(http.StatusOK)
+	e.POST("/store/exec").WithHeader("Authorization", "Bearer "+userToken).WithJSON(api.StoreExecRequest{
+		ExecOptions: store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		},
+	}).Expect().Status(http.StatusBadRequest)
+
+	auditorToken := e.POST("/admin/addadmin").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddAdminRequest{
+		AdminName: "auditor",
+		Roles:     []string{store.ADMIN_ROLE_AUDITOR},
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("adminToken").String().Raw()
+
+	events := e.POST("/admin/searchaudit").WithHeader("Authorization", "Bearer "+auditorToken).WithJSON(api.AdminSearchAuditRequest{
+		UserName:  "test-user",
+		TableName: "foo",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("events").Array()
+	events.Length().IsEqual(2)
+	query := events.Value(0).Object()
+	query.Value("operation").IsEqual("query")
+	query.Value("decision").IsEqual(store.AUDIT_ALLOW)
+	query.Value("rule").IsEqual("grant READ_ALL on 'foo'")
+	query.Value("requestId").String().NotEmpty()
+	insert := events.Value(1).Object()
+	insert.Value("operation").IsEqual(store.ExecTypeInsert)
+	insert.Value("decision").IsEqual(store.AUDIT_DENY)
+
+	e.POST("/admin/searchaudit").WithHeader("Authorization", "Bearer "+auditorToken).WithJSON(api.AdminSearchAuditRequest{
+		From: time.Now().Add(time.Hour),
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("events").Array().IsEmpty()
+
+	verified := e.POST("/admin/verifyaudit").WithHeader("Authorization", "Bearer "+auditorToken).
+		WithJSON(api.AdminVerifyAuditRequest{}).Expect().Status(http.StatusOK).JSON().Object()
+	verified.Value("ok").IsEqual(true)
+	verified.Value("events").IsEqual(2)
+
+	// auditors cannot manage anything and other admins cannot read the log
+	e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+auditorToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "other-user",
+	}).Expect().Status(http.StatusForbidden)
+	userManagerToken := e.POST("/admin/addadmin").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddAdminRequest{
+		AdminName: "users",
+		Roles:     []string{store.ADMIN_ROLE_USER_MANAGER},
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("adminToken").String().Raw()
+	e.POST("/admin/searchaudit").WithHeader("Authorization", "Bearer "+userManagerToken).WithJSON(api.AdminSearchAuditRequest{}).
+		Expect().Status(http.StatusForbidden)
+}
+
+func TestStoreAPILimits(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	handler := api.NewStoreHandler(as, ds, api.WithLimits(api.Limits{
+		// a burst of 3 requests, refilled far slower than the test runs
+		User: api.Limit{Rate: 0.001, Burst: 3},
+		Tables: map[string]api.Limit{
+			"bar": {DailyRows: 2},
+		},
+		Users: map[string]api.Limit{
+			"unlimited": {},
+		},
+	}))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	for _, table := range []string{"foo", "bar"} {
+		e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+			CreateTableOptions: store.CreateTableOptions{
+				TableName: table,
+				Definitions: [][]string{
+					{"id", "integer", "not null", "primary key"},
+					{"name", "text"},
+				},
+			},
+		}).Expect().Status(http.StatusOK).NoContent()
+	}
+
+	tokens := map[string]string{}
+	for _, name := range []string{"limited", "unlimited"} {
+		tokens[name] = e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+			UserName: name,
+		}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+
+		for _, table := range []string{"foo", "bar"} {
+			e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+				UserName:    name,
+				TableName:   table,
+				Permissions: []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION},
+			}).Expect().Status(http.StatusOK).NoContent()
+		}
+	}
+
+	insert := func(token, table string) *httpexpect.Response {
+		return e.POST("/store/exec").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreExecRequest{
+			ExecOptions: store.ExecOptions{
+				Type:      store.ExecTypeInsert,
+				TableName: table,
+				Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+			},
+		}).Expect()
+	}
+	query := func(token, table string) *httpexpect.Response {
+		return e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      table,
+				IncludeColumns: []string{"id", "name"},
+			},
+		}).Expect()
+	}
+
+	// the burst is used up, whatever the table
+	insert(tokens["limited"], "foo").Status(http.StatusOK)
+	insert(tokens["limited"], "foo").Status(http.StatusOK)
+	query(tokens["limited"], "foo").Status(http.StatusOK)
+	resp := query(tokens["limited"], "foo").Status(http.StatusTooManyRequests)
+	resp.Header("Retry-After").AsNumber().Gt(1)
+	resp.Body().Contains("rate limit of user 'limited'")
+
+	// the override lifts the rate limit, the table quota still applies
+	insert(tokens["unlimited"], "bar").Status(http.StatusOK)
+	insert(tokens["unlimited"], "bar").Status(http.StatusOK)
+	query(tokens["unlimited"], "bar").Status(http.StatusTooManyRequests).
+		Header("Retry-After").AsNumber().InRange(1, 24*60*60)
+	query(tokens["unlimited"], "foo").Status(http.StatusOK)
+
+	// a user without grants neither charges a table nor adds one to the
+	// usage by naming it
+	outsider := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "outsider",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+	insert(outsider, "foo").Status(http.StatusBadRequest)
+	query(outsider, "foo").Status(http.StatusBadRequest)
+	query(outsider, "nosuchtable").Status(http.StatusBadRequest)
+
+	usage := e.POST("/admin/quotausage").WithHeader("Authorization", "Bearer "+adminToken).
+		Expect().Status(http.StatusOK).JSON().Object().Value("usage").Array()
+	bar := usage.Filter(func(_ int, v *httpexpect.Value) bool {
+		obj := v.Object().Raw()
+		return obj["kind"] == "table" && obj["name"] == "bar"
+	})
+	bar.Length().IsEqual(1)
+	bar.Value(0).Object().Value("rows").IsEqual(2)
+	bar.Value(0).Object().Value("dailyRows").IsEqual(2)
+	bar.Value(0).Object().Value("requests").IsEqual(2)
+	foo := usage.Filter(func(_ int, v *httpexpect.Value) bool {
+		obj := v.Object().Raw()
+		return obj["kind"] == "table" && obj["name"] == "foo"
+	})
+	foo.Value(0).Object().Value("requests").IsEqual(4)
+	usage.Filter(func(_ int, v *httpexpect.Value) bool {
+		return v.Object().Raw()["name"] == "nosuchtable"
+	}).IsEmpty()
+	limited := usage.Filter(func(_ int, v *httpexpect.Value) bool {
+		obj := v.Object().Raw()
+		return obj["kind"] == "user" && obj["name"] == "limited"
+	})
+	limited.Value(0).Object().Value("requests").IsEqual(3)
+	limited.Value(0).Object().Value("rows").IsEqual(4)
+}
+
+func TestStoreAPIPaging(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := api.NewStoreHandler(as, store.NewDelegatedStore(as, usf))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	userToken := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+	otherToken := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "other-user",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+
+	for _, name := range []string{"test-user", "other-user"} {
+		e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+			UserName:    name,
+			TableName:   "foo",
+			Permissions: []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION},
+		}).Expect().Status(http.StatusOK).NoContent()
+	}
+
+	for i := 0; i < 5; i++ {
+		e.POST("/store/exec").WithHeader("Authorization", "Bearer "+userToken).WithJSON(api.StoreExecRequest{
+			ExecOptions: store.ExecOptions{
+				Type:      store.ExecTypeInsert,
+				TableName: "foo",
+				Values:    []store.FieldValue{{Name: "name", Value: fmt.Sprintf("name%d", i)}},
+			},
+		}).Expect().Status(http.StatusOK)
+	}
+
+	query := func(token, cursor string) *httpexpect.Response {
+		return e.POST("/store/query").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreQueryRequest{
+			QueryOptions: store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"id", "name"},
+				OrderBy:        []store.SortKey{{Column: "id", Desc: true}},
+				Limit:          2,
+				Cursor:         cursor,
+			},
+		}).Expect()
+	}
+
+	var names []string
+	cursor := ""
+	for {
+		page := query(userToken, cursor).Status(http.StatusOK).JSON().Object()
+		for _, rec := range page.Value("results").Array().Iter() {
+			names = append(names, rec.Object().Value("name").String().Raw())
+		}
+		next, ok := page.Raw()["nextCursor"]
+		if !ok {
+			break
+		}
+		cursor = next.(string)
+		// the cursor of one user is no good to another
+		query(otherToken, cursor).Status(http.StatusBadRequest)
+	}
+	if got := strings.Join(names, ","); got != "name4,name3,name2,name1,name0" {
+		t.Fatalf("unexpected names %s", got)
+	}
+}
+
+func TestStoreAPISQL(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	adminToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	handler := api.NewStoreHandler(as, store.NewDelegatedStore(as, usf))
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	e := httpexpect.Default(t, server.URL)
+
+	e.POST("/admin/addtable").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddTableRequest{
+		CreateTableOptions: store.CreateTableOptions{
+			TableName: "foo",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		},
+	}).Expect().Status(http.StatusOK).NoContent()
+
+	userToken := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "test-user",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+	otherToken := e.POST("/admin/adduser").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddUserRequest{
+		UserName: "other-user",
+	}).Expect().Status(http.StatusOK).JSON().Object().Value("userToken").String().Raw()
+
+	for _, name := range []string{"test-user", "other-user"} {
+		e.POST("/admin/addpermission").WithHeader("Authorization", "Bearer "+adminToken).WithJSON(api.AdminAddPermissionRequest{
+			UserName:    name,
+			TableName:   "foo",
+			Permissions: []string{store.READ_RESTRICTED_PERMISSION, store.WRITE_RESTRICTED_PERMISSION, store.DELETE_RESTRICTED_PERMISSION},
+		}).Expect().Status(http.StatusOK).NoContent()
+	}
+
+	sql := func(token, sql string, args ...interface{}) *httpexpect.Response {
+		return e.POST("/store/sql").WithHeader("Authorization", "Bearer "+token).WithJSON(api.StoreSQLRequest{
+			SQL:  sql,
+			Args: args,
+		}).Expect()
+	}
+
+	for _, name := range []string{"a", "b"} {
+		sql(userToken, "INSERT INTO foo (name) VALUES (?)", name).Status(http.StatusOK).
+			JSON().Object().Value("lastInsertId").Number().Gt(0)
+	}
+	sql(otherToken, "INSERT INTO foo (name) VALUES ('c')").Status(http.StatusOK)
+
+	// the filter of the restricted permission is added to whatever the
+	// statement asks for
+	results := sql(userToken, "SELECT id, name FROM foo WHERE name = 'c' OR id > ? ORDER BY name", 0).
+		Status(http.StatusOK).JSON().Object().Value("results").Array()
+	results.Length().IsEqual(2)
+	results.Value(0).Object().Value("name").IsEqual("a")
+	results.Value(1).Object().Value("name").IsEqual("b")
+
+	sql(otherToken, "SELECT COUNT(*) AS n FROM foo").Status(http.StatusOK).
+		JSON().Object().Value("results").Array().Value(0).Object().Value("n").IsEqual(1)
+
+	// rows of other users are neither updated nor deleted
+	sql(otherToken, "UPDATE foo SET name = 'x' WHERE name IN ('a', 'b')").Status(http.StatusOK).
+		JSON().Object().Value("rowsAffected").IsEqual(0)
+	sql(userToken, "DELETE FROM foo WHERE name = 'c'").Status(http.StatusOK).
+		JSON().Object().Value("rowsAffected").IsEqual(0)
+	sql(userToken, "DELETE FROM foo WHERE name = 'a'").Status(http.StatusOK).
+		JSON().Object().Value("rowsAffected").IsEqual(1)
+
+	for _, stmt := range []string{
+		"DROP TABLE foo",
+		"SELECT name FROM foo WHERE 1 = 1",
+		"SELECT name FROM sqlite_master",
+		"SELECT name FROM foo; DELETE FROM foo WHERE id > 0",
+		"SELECT load_extension('x') FROM foo",
+		"UPDATE foo SET created_by = 1 WHERE id > 0",
+	} {
+		sql(userToken, stmt).Status(http.StatusBadRequest)
+	}
 }
@@ -0,0 +1,123 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+// APIKeyHeader can be used instead of 'Authorization: Bearer <token>' by
+// clients which cannot set the Authorization header.
+const APIKeyHeader = "X-API-Key"
+
+// requestToken returns the token of the request, tokens are only accepted in
+// headers so that they do not end up in request body logs.
+func requestToken(r *http.Request) (string, error) {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		scheme, token, ok := strings.Cut(auth, " ")
+		if !ok || !strings.EqualFold(scheme, "Bearer") || token == "" {
+			return "", fmt.Errorf("authorization header must be 'Bearer <token>'")
+		}
+		return token, nil
+	}
+	if token := r.Header.Get(APIKeyHeader); token != "" {
+		return token, nil
+	}
+	return "", fmt.Errorf("missing token")
+}
+
+func hasToken(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get(APIKeyHeader) != ""
+}
+
+// authenticateUser resolves the user behind the token, the signature or the
+// client certificate of the request once and puts it on the request context, where the delegated
+// store picks it up.
+func authenticateUser(as store.AdminStore, o handlerOptions) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			user, err := authenticateUserRequest(w, r, as, o)
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+				return
+			}
+			if err != nil {
+				unauthorized(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(store.ContextWithUser(r.Context(), user)))
+		})
+	}
+}
+
+func authenticateUserRequest(w http.ResponseWriter, r *http.Request, as store.AdminStore, o handlerOptions) (*store.User, error) {
+	if o.maxSignatureSkew > 0 && r.Header.Get(SignatureHeader) != "" {
+		// the body is read to check the signature before anything is
+		// known about the caller
+		r.Body = http.MaxBytesReader(w, r.Body, o.maxSignedBodySize)
+		return verifySignedRequest(r, as, o.maxSignatureSkew)
+	}
+
+	if o.certificateUserName != nil && hasClientCertificate(r) && !hasToken(r) {
+		return certificateUser(r, as, o.certificateUserName)
+	}
+
+	token, err := requestToken(r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := o.authenticator.Authenticate(r.Context(), token)
+	if errors.Is(err, store.ErrUnsupportedToken) {
+		return nil, fmt.Errorf("invalid token")
+	}
+	return user, err
+}
+
+// authenticateAdmin resolves the admin behind the token of the request and
+// puts it on the request context, capabilities are checked by each handler
+// with authorizeAdmin.
+func authenticateAdmin(as store.AdminStore) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			token, err := requestToken(r)
+			if err != nil {
+				unauthorized(w, err)
+				return
+			}
+
+			admin, err := as.GetAdmin(r.Context(), token)
+			if err != nil {
+				unauthorized(w, err)
+				return
+			}
+
+			next.ServeHTTP(w, r.WithContext(store.ContextWithAdmin(r.Context(), admin)))
+		})
+	}
+}
+
+func unauthorized(w http.ResponseWriter, err error) {
+	w.Header().Set("WWW-Authenticate", "Bearer")
+	http.Error(w, err.Error(), http.StatusUnauthorized)
+}
+
+// authorizeAdmin checks that one of the roles of the admin on the request
+// context grants capability, the error is written to w otherwise.
+func authorizeAdmin(w http.ResponseWriter, r *http.Request, capability string) bool {
+	admin, ok := store.AdminFromContext(r.Context())
+	if !ok {
+		unauthorized(w, fmt.Errorf("missing token"))
+		return false
+	}
+	if !admin.Can(capability) {
+		http.Error(w, fmt.Sprintf("admin '%s' does not have capability '%s'", admin.Name, capability), http.StatusForbidden)
+		return false
+	}
+	return true
+}
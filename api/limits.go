@@ -0,0 +1,321 @@
+package api
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+// Limit caps the requests and the daily volume of a user, a key or a table,
+// zero values leave that dimension unlimited.
+type Limit struct {
+	// token bucket refilled with Rate requests per second up to Burst
+	Rate  float64 `json:"rate"`
+	Burst int     `json:"burst"`
+	// rows returned by queries or affected by execs per UTC day
+	DailyRows int64 `json:"dailyRows"`
+	// bytes of query responses and exec requests per UTC day
+	DailyBytes int64 `json:"dailyBytes"`
+}
+
+// Limits applies to /store requests, a request counts against its user, the
+// key it was authenticated with and its table, and is rejected when any of
+// them is over its limit.
+type Limits struct {
+	// defaults for every user, key and table
+	User  Limit
+	Key   Limit
+	Table Limit
+	// overrides by user name, key id and table name
+	Users  map[string]Limit
+	Keys   map[string]Limit
+	Tables map[string]Limit
+}
+
+// WithLimits rate limits /store requests and enforces daily quotas, requests
+// over a limit get 429 with a Retry-After header.
+func WithLimits(limits Limits) HandlerOption {
+	return func(o *handlerOptions) {
+		o.limits = &limits
+	}
+}
+
+// QuotaUsage is the usage of a user, key or table on the current day.
+type QuotaUsage struct {
+	// user, key or table
+	Kind string `json:"kind"`
+	Name string `json:"name"`
+	// UTC day, e.g. 2006-01-02
+	Day        string `json:"day"`
+	Requests   int64  `json:"requests"`
+	Rows       int64  `json:"rows"`
+	Bytes      int64  `json:"bytes"`
+	DailyRows  int64  `json:"dailyRows"`
+	DailyBytes int64  `json:"dailyBytes"`
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+	// when the bucket is full again and can be dropped
+	full time.Time
+}
+
+// limiter keeps buckets and usage in memory, so they are per process and
+// start over on a restart. Full buckets and usage of earlier days are
+// dropped every evictInterval.
+type limiter struct {
+	limits Limits
+	now    func() time.Time
+
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	usage   map[string]*QuotaUsage
+	evicted time.Time
+}
+
+const evictInterval = time.Minute
+
+func newLimiter(limits Limits) *limiter {
+	return &limiter{
+		limits:  limits,
+		now:     time.Now,
+		buckets: map[string]*bucket{},
+		usage:   map[string]*QuotaUsage{},
+	}
+}
+
+// limitError is returned for requests over a limit.
+type limitError struct {
+	msg        string
+	retryAfter time.Duration
+}
+
+func (e *limitError) Error() string {
+	return e.msg
+}
+
+// limitSubject is a user, key or table a request counts against.
+type limitSubject struct {
+	kind  string
+	name  string
+	limit Limit
+}
+
+func (s limitSubject) id() string {
+	return s.kind + ":" + s.name
+}
+
+func (l *limiter) subjects(user *store.User, tableName string) []limitSubject {
+	pick := func(overrides map[string]Limit, name string, def Limit) Limit {
+		if limit, ok := overrides[name]; ok {
+			return limit
+		}
+		return def
+	}
+
+	subjects := []limitSubject{
+		{kind: "user", name: user.UserName, limit: pick(l.limits.Users, user.UserName, l.limits.User)},
+		{kind: "table", name: tableName, limit: pick(l.limits.Tables, tableName, l.limits.Table)},
+	}
+	// users authenticated without an api key, e.g. by jwt, have no key
+	if user.KeyID != "" {
+		subjects = append(subjects, limitSubject{kind: "key", name: user.KeyID, limit: pick(l.limits.Keys, user.KeyID, l.limits.Key)})
+	}
+	return subjects
+}
+
+// allow checks the buckets and daily quotas of the user, the key and the
+// table of a request and takes a token from those of the user and the key,
+// either from both or, when one of them is empty or over its daily quota,
+// from none. The table is named by the caller and not yet authorized, so
+// it is only charged by record once the request went through, otherwise
+// anyone could drain the bucket of a table they cannot read.
+func (l *limiter) allow(user *store.User, tableName string) *limitError {
+	if l == nil {
+		return nil
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	l.evict(now)
+
+	subjects := l.subjects(user, tableName)
+	for _, s := range subjects {
+		if usage, ok := l.usage[s.id()]; ok && usage.Day == day(now) &&
+			((s.limit.DailyRows > 0 && usage.Rows >= s.limit.DailyRows) ||
+				(s.limit.DailyBytes > 0 && usage.Bytes >= s.limit.DailyBytes)) {
+			return &limitError{
+				msg:        fmt.Sprintf("daily quota of %s '%s' exhausted", s.kind, s.name),
+				retryAfter: nextDay(now).Sub(now),
+			}
+		}
+
+		if tokens, ok := l.tokens(s, now); ok && tokens < 1 {
+			return &limitError{
+				msg:        fmt.Sprintf("rate limit of %s '%s' exceeded", s.kind, s.name),
+				retryAfter: time.Duration((1 - tokens) / s.limit.Rate * float64(time.Second)),
+			}
+		}
+	}
+
+	for _, s := range subjects {
+		if s.kind != "table" {
+			l.charge(s, now)
+		}
+	}
+	return nil
+}
+
+// record charges the table of an authorized request and adds what it
+// returned or changed to the daily usage, a request is never cut short so
+// usage can end up over the quota.
+func (l *limiter) record(user *store.User, tableName string, rows, bytes int64) {
+	if l == nil {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	for _, s := range l.subjects(user, tableName) {
+		if s.kind == "table" {
+			l.charge(s, now)
+		}
+		usage := l.usageOf(s, now)
+		usage.Rows += rows
+		usage.Bytes += bytes
+	}
+}
+
+// charge takes a token from the bucket of s and counts the request.
+func (l *limiter) charge(s limitSubject, now time.Time) {
+	if b := l.bucketOf(s, now); b != nil {
+		b.tokens--
+		b.full = now.Add(time.Duration((math.Max(float64(s.limit.Burst), 1) - b.tokens) / s.limit.Rate * float64(time.Second)))
+	}
+	l.usageOf(s, now).Requests++
+}
+
+// tokens returns the tokens the bucket of s would have at now without
+// creating it, false if s is not rate limited.
+func (l *limiter) tokens(s limitSubject, now time.Time) (float64, bool) {
+	if s.limit.Rate <= 0 {
+		return 0, false
+	}
+	burst := math.Max(float64(s.limit.Burst), 1)
+	b, ok := l.buckets[s.id()]
+	if !ok {
+		return burst, true
+	}
+	return math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*s.limit.Rate), true
+}
+
+// evict drops buckets which are full again, which is what a missing bucket
+// stands for, and usage of earlier days.
+func (l *limiter) evict(now time.Time) {
+	if now.Sub(l.evicted) < evictInterval {
+		return
+	}
+	l.evicted = now
+	for id, b := range l.buckets {
+		if !now.Before(b.full) {
+			delete(l.buckets, id)
+		}
+	}
+	for id, usage := range l.usage {
+		if usage.Day != day(now) {
+			delete(l.usage, id)
+		}
+	}
+}
+
+// bucketOf returns the refilled bucket of s, nil if s is not rate limited.
+func (l *limiter) bucketOf(s limitSubject, now time.Time) *bucket {
+	if s.limit.Rate <= 0 {
+		return nil
+	}
+	burst := math.Max(float64(s.limit.Burst), 1)
+
+	b, ok := l.buckets[s.id()]
+	if !ok {
+		b = &bucket{tokens: burst, last: now}
+		l.buckets[s.id()] = b
+	}
+	b.tokens, _ = l.tokens(s, now)
+	b.last = now
+	return b
+}
+
+// usageOf returns the usage of s on the day of now, usage of earlier days
+// is dropped.
+func (l *limiter) usageOf(s limitSubject, now time.Time) *QuotaUsage {
+	usage, ok := l.usage[s.id()]
+	if !ok || usage.Day != day(now) {
+		usage = &QuotaUsage{Kind: s.kind, Name: s.name, Day: day(now)}
+		l.usage[s.id()] = usage
+	}
+	usage.DailyRows = s.limit.DailyRows
+	usage.DailyBytes = s.limit.DailyBytes
+	return usage
+}
+
+// currentUsage returns the usage of the current day, sorted by kind and name.
+func (l *limiter) currentUsage() []QuotaUsage {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	today := day(l.now())
+	ret := []QuotaUsage{}
+	for _, usage := range l.usage {
+		if usage.Day == today {
+			ret = append(ret, *usage)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Kind != ret[j].Kind {
+			return ret[i].Kind < ret[j].Kind
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret
+}
+
+// day returns the UTC day of now, e.g. 2006-01-02.
+func day(now time.Time) string {
+	return now.UTC().Format("2006-01-02")
+}
+
+func nextDay(now time.Time) time.Time {
+	y, m, d := now.UTC().Date()
+	return time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+}
+
+// tooManyRequests writes a 429 for a limitError, with Retry-After in whole
+// seconds rounded up.
+func tooManyRequests(w http.ResponseWriter, err *limitError) {
+	w.Header().Set("Retry-After", strconv.FormatInt(int64(math.Ceil(err.retryAfter.Seconds())), 10))
+	http.Error(w, err.Error(), http.StatusTooManyRequests)
+}
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
@@ -0,0 +1,118 @@
+package api
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+// CertificateUserName maps a verified client certificate to the name of a
+// user in global_users.
+type CertificateUserName func(cert *x509.Certificate) (string, error)
+
+// SubjectCommonName maps a client certificate to the common name of its
+// subject.
+func SubjectCommonName(cert *x509.Certificate) (string, error) {
+	if cert.Subject.CommonName == "" {
+		return "", fmt.Errorf("client certificate has no common name")
+	}
+	return cert.Subject.CommonName, nil
+}
+
+// SubjectAltName maps a client certificate to its first URI, DNS or email
+// subject alternative name, in that order, e.g. the SPIFFE id of a service.
+func SubjectAltName(cert *x509.Certificate) (string, error) {
+	switch {
+	case len(cert.URIs) > 0:
+		return cert.URIs[0].String(), nil
+	case len(cert.DNSNames) > 0:
+		return cert.DNSNames[0], nil
+	case len(cert.EmailAddresses) > 0:
+		return cert.EmailAddresses[0], nil
+	}
+	return "", fmt.Errorf("client certificate has no subject alternative name")
+}
+
+// WithClientCertificates authenticates /store callers presenting a client
+// certificate verified by the TLS server, see NewTLSServer, as the user
+// named by userName. The certificate is only used for requests without a
+// token or signature.
+func WithClientCertificates(userName CertificateUserName) HandlerOption {
+	return func(o *handlerOptions) {
+		o.certificateUserName = userName
+	}
+}
+
+type TLSOptions struct {
+	// certificate and key of the server in PEM
+	CertFile string
+	KeyFile  string
+	// PEM bundle of the CAs client certificates have to be issued by
+	ClientCAFile string
+	// reject connections without a client certificate, otherwise clients
+	// can still authenticate with tokens
+	RequireClientCert bool
+}
+
+// NewTLSServer returns a server terminating TLS for handler which verifies
+// client certificates against the configured CA bundle, start it with
+// ListenAndServeTLS("", "").
+func NewTLSServer(addr string, handler http.Handler, opts TLSOptions) (*http.Server, error) {
+	cert, err := tls.LoadX509KeyPair(opts.CertFile, opts.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	pem, err := os.ReadFile(opts.ClientCAFile)
+	if err != nil {
+		return nil, err
+	}
+	cas := x509.NewCertPool()
+	if !cas.AppendCertsFromPEM(pem) {
+		return nil, fmt.Errorf("no certificates in client ca bundle '%s'", opts.ClientCAFile)
+	}
+
+	clientAuth := tls.VerifyClientCertIfGiven
+	if opts.RequireClientCert {
+		clientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return &http.Server{
+		Addr:    addr,
+		Handler: handler,
+		TLSConfig: &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			ClientCAs:    cas,
+			ClientAuth:   clientAuth,
+			MinVersion:   tls.VersionTLS12,
+		},
+	}, nil
+}
+
+// hasClientCertificate is true when the TLS server verified a client
+// certificate for r.
+func hasClientCertificate(r *http.Request) bool {
+	return r.TLS != nil && len(r.TLS.VerifiedChains) > 0 && len(r.TLS.VerifiedChains[0]) > 0
+}
+
+// certificateUser returns the user named by the verified client certificate
+// of r.
+func certificateUser(r *http.Request, as store.AdminStore, userName CertificateUserName) (*store.User, error) {
+	name, err := userName(r.TLS.VerifiedChains[0][0])
+	if err != nil {
+		return nil, err
+	}
+
+	user, err := as.GetUserByName(r.Context(), name)
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, fmt.Errorf("user '%s' is disabled", user.UserName)
+	}
+	return user, nil
+}
@@ -0,0 +1,114 @@
+package api
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+// headers of signed requests
+const (
+	SignatureKeyHeader       = "X-Signature-Key"
+	SignatureTimestampHeader = "X-Signature-Timestamp"
+	SignatureNonceHeader     = "X-Signature-Nonce"
+	SignatureHeader          = "X-Signature"
+)
+
+// SignRequest signs r with a signing key, the signature is a HMAC-SHA256
+// keyed with the token of the key over the method, the path and query, the
+// unix timestamp, the nonce and the SHA-256 of the body. nonce has to be
+// unique for the key, e.g. random bytes.
+func SignRequest(r *http.Request, keyID, token, nonce string, now time.Time) error {
+	body, err := readBody(r)
+	if err != nil {
+		return err
+	}
+
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	r.Header.Set(SignatureKeyHeader, keyID)
+	r.Header.Set(SignatureTimestampHeader, timestamp)
+	r.Header.Set(SignatureNonceHeader, nonce)
+	r.Header.Set(SignatureHeader, hex.EncodeToString(requestSignature(r, token, timestamp, nonce, body)))
+	return nil
+}
+
+// verifySignedRequest returns the owner of the signing key of a signed
+// request. Requests outside of maxSkew are rejected and so are nonces seen
+// before, nonces are remembered for as long as the request would be
+// accepted.
+func verifySignedRequest(r *http.Request, as store.AdminStore, maxSkew time.Duration) (*store.User, error) {
+	keyID := r.Header.Get(SignatureKeyHeader)
+	timestamp := r.Header.Get(SignatureTimestampHeader)
+	nonce := r.Header.Get(SignatureNonceHeader)
+	sig, err := hex.DecodeString(r.Header.Get(SignatureHeader))
+	if keyID == "" || timestamp == "" || nonce == "" || err != nil || len(sig) == 0 {
+		return nil, fmt.Errorf("signed requests need the %s, %s, %s and %s headers",
+			SignatureKeyHeader, SignatureTimestampHeader, SignatureNonceHeader, SignatureHeader)
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid request timestamp")
+	}
+	signedAt := time.Unix(ts, 0)
+	if skew := time.Since(signedAt); skew > maxSkew || skew < -maxSkew {
+		return nil, fmt.Errorf("request timestamp is outside of the allowed clock skew")
+	}
+
+	body, err := readBody(r)
+	if err != nil {
+		return nil, err
+	}
+
+	user, token, err := as.GetUserForSigningKey(r.Context(), keyID)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(sig, requestSignature(r, token, timestamp, nonce, body)) {
+		return nil, fmt.Errorf("invalid request signature")
+	}
+
+	// only recorded for valid signatures so that nobody else can use up
+	// the nonces of a key
+	if err := as.UseNonce(r.Context(), keyID, nonce, signedAt.Add(maxSkew)); err != nil {
+		return nil, err
+	}
+
+	return user, nil
+}
+
+func requestSignature(r *http.Request, token, timestamp, nonce string, body []byte) []byte {
+	digest := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, []byte(token))
+	mac.Write([]byte(strings.Join([]string{
+		r.Method,
+		r.URL.RequestURI(),
+		timestamp,
+		nonce,
+		hex.EncodeToString(digest[:]),
+	}, "\n")))
+	return mac.Sum(nil)
+}
+
+// readBody returns the body of r and puts it back so it can be read again.
+func readBody(r *http.Request) ([]byte, error) {
+	if r.Body == nil {
+		return nil, nil
+	}
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return nil, err
+	}
+	r.Body.Close()
+	r.Body = io.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
@@ -0,0 +1,48 @@
+// Command bootstrapadmin adds the first superadmin to an admin database and
+// prints their admin token. It fails once the database has an admin, every
+// other admin is added by a superadmin through /admin/addadmin.
+//
+//	go run ./cmd/bootstrapadmin -admin-db admin.db -name root
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func main() {
+	if err := run(context.Background(), os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	flags := flag.NewFlagSet("bootstrapadmin", flag.ContinueOnError)
+	adminDB := flags.String("admin-db", "", "data source of the admin store the server uses")
+	name := flags.String("name", "root", "name of the superadmin")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *adminDB == "" {
+		return fmt.Errorf("-admin-db is required")
+	}
+
+	// the user store data source is only used when tables are created
+	as, err := store.NewAdminStore(ctx, *adminDB, "", store.NewUserStoreFactory())
+	if err != nil {
+		return err
+	}
+	token, err := as.BootstrapAdmin(ctx, *name)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(out, "admin token of '%s': %s\n", *name, token)
+	return err
+}
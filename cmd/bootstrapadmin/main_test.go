@@ -0,0 +1,46 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestBootstrapAdmin(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "admin.db")
+
+	if err := run(context.TODO(), nil, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected missing admin db to fail")
+	}
+
+	var out bytes.Buffer
+	if err := run(context.TODO(), []string{"-admin-db", path, "-name", "root"}, &out); err != nil {
+		t.Fatal(err)
+	}
+	_, token, ok := strings.Cut(strings.TrimSpace(out.String()), "admin token of 'root': ")
+	if !ok {
+		t.Fatalf("unexpected output %q", out.String())
+	}
+
+	// the server opening the same database accepts the token
+	as, err := store.NewAdminStore(context.TODO(), path, "", store.NewUserStoreFactory())
+	if err != nil {
+		t.Fatal(err)
+	}
+	admin, err := as.GetAdmin(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin.Name != "root" || !admin.Can(store.CapabilityManageAdmins) {
+		t.Fatalf("unexpected admin %+v", admin)
+	}
+
+	// and only the first admin can be bootstrapped
+	if err := run(context.TODO(), []string{"-admin-db", path, "-name", "root2"}, &bytes.Buffer{}); err == nil {
+		t.Fatal("expected second bootstrap to fail")
+	}
+}
@@ -2,11 +2,11 @@ package store
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"strings"
 	"time"
 
-	"github.com/rs/xid"
 	"golang.org/x/exp/slices"
 )
 
@@ -14,11 +14,25 @@ type adminStore struct {
 	userStoreDataSource string
 	store               CompoundStore
 	usf                 UserStoreFactory
+	// tokens of signing keys are derived from it, nil when signing keys
+	// are disabled
+	signingSecret []byte
 }
 
 var _ AdminStore = (*adminStore)(nil)
 
-func NewAdminStore(ctx context.Context, adminDataSource, userStoreDataSource string, usf UserStoreFactory) (*adminStore, error) {
+type AdminStoreOption func(*adminStore)
+
+// WithSigningSecret enables signing keys, their tokens are derived from
+// secret rather than stored so that a leaked admin database does not leak
+// them. Changing the secret invalidates every signing key.
+func WithSigningSecret(secret []byte) AdminStoreOption {
+	return func(s *adminStore) {
+		s.signingSecret = secret
+	}
+}
+
+func NewAdminStore(ctx context.Context, adminDataSource, userStoreDataSource string, usf UserStoreFactory, opts ...AdminStoreOption) (*adminStore, error) {
 	store, err := NewSQLite3Store(adminDataSource, time.Now().UnixNano())
 	if err != nil {
 		return nil, err
@@ -28,6 +42,12 @@ func NewAdminStore(ctx context.Context, adminDataSource, userStoreDataSource str
 		usf:                 usf,
 		store:               store,
 	}
+	for _, opt := range opts {
+		opt(as)
+	}
+	if as.signingSecret != nil && len(as.signingSecret) < 32 {
+		return nil, fmt.Errorf("signing secret has to be at least 32 bytes")
+	}
 
 	err = as.init(ctx)
 	if err != nil {
@@ -42,13 +62,17 @@ const (
 	global_permissions           = "global_permissions"
 	global_users                 = "global_users"
 	global_user_table_permission = "global_user_table_permission"
+	global_user_attributes       = "global_user_attributes"
+	global_row_policies          = "global_row_policies"
 )
 
 const (
-	READ_ALL_PERMISSION         = "READ_ALL"
-	WRITE_ALL_PERMISSION        = "WRITE_ALL"
-	READ_RESTRICTED_PERMISSION  = "READ_RESTRICTED"
-	WRITE_RESTRICTED_PERMISSION = "WRITE_RESTRICTED"
+	READ_ALL_PERMISSION          = "READ_ALL"
+	WRITE_ALL_PERMISSION         = "WRITE_ALL"
+	READ_RESTRICTED_PERMISSION   = "READ_RESTRICTED"
+	WRITE_RESTRICTED_PERMISSION  = "WRITE_RESTRICTED"
+	DELETE_ALL_PERMISSION        = "DELETE_ALL"
+	DELETE_RESTRICTED_PERMISSION = "DELETE_RESTRICTED"
 )
 
 var defaultPermissions = func() []string {
@@ -61,6 +85,10 @@ var defaultPermissions = func() []string {
 		READ_RESTRICTED_PERMISSION,
 		// can create new entries and update entries create by the user
 		WRITE_RESTRICTED_PERMISSION,
+		// can delete any record in a table
+		DELETE_ALL_PERMISSION,
+		// can delete only entries created by the user
+		DELETE_RESTRICTED_PERMISSION,
 	}
 }()
 
@@ -96,7 +124,7 @@ func (s *adminStore) init(ctx context.Context) error {
 		res, err := s.store.Query(ctx, QueryOptions{
 			TableName:      global_permissions,
 			IncludeColumns: []string{"name"},
-			Where:          []string{fmt.Sprintf("name = '%s'", perm)},
+			Where:          []Predicate{Eq("name", perm)},
 			Limit:          1,
 		})
 		if err != nil {
@@ -128,7 +156,7 @@ func (s *adminStore) init(ctx context.Context) error {
 		Definitions: [][]string{
 			{"id", "integer", "not null", "primary key"},
 			{"name", "text"},
-			{"token", "text", "not null"},
+			{"disabled", "integer", "not null", "default 0"},
 		},
 		IfNotExists: true,
 	}); err != nil {
@@ -143,6 +171,11 @@ func (s *adminStore) init(ctx context.Context) error {
 			{"user_id", "integer", "not null"},
 			{"table_name", "text", "not null"},
 			{"permission", "text", "not null"},
+			// comma separated list of columns, empty for all columns
+			{"columns", "text", "not null", "default ''"},
+			// unix seconds bounding the grant, 0 for unbounded
+			{"not_before", "integer", "not null", "default 0"},
+			{"not_after", "integer", "not null", "default 0"},
 		},
 		IfNotExists: true,
 	}); err != nil {
@@ -151,16 +184,61 @@ func (s *adminStore) init(ctx context.Context) error {
 
 	fmt.Println("created: ", global_user_table_permission)
 
-	return nil
+	if err := s.store.CreateTable(ctx, CreateTableOptions{
+		TableName: global_user_attributes,
+		Definitions: [][]string{
+			{"user_id", "integer", "not null"},
+			{"name", "text", "not null"},
+			// json encoded, one row per value of multi valued attributes
+			{"value", "text", "not null"},
+		},
+		IfNotExists: true,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("created: ", global_user_attributes)
+
+	if err := s.store.CreateTable(ctx, CreateTableOptions{
+		TableName: global_row_policies,
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"table_name", "text", "not null"},
+			{"name", "text", "not null"},
+			// json encoded predicate
+			{"predicate", "text", "not null"},
+		},
+		IfNotExists: true,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("created: ", global_row_policies)
+
+	if err := s.initRBAC(ctx); err != nil {
+		return err
+	}
+
+	if err := s.initAPIKeys(ctx); err != nil {
+		return err
+	}
+
+	return s.initAdmins(ctx)
 }
 
 func (s *adminStore) CreateTable(ctx context.Context, opts CreateTableOptions) error {
 	//TODO: handle placement of tables on upstream stores
-	us, err := s.usf.New(ctx, UserStoreOptions{
-		// set id for picking specific upstream stores
-		//ID:
+	storeOpts := UserStoreOptions{
 		DataSource: s.userStoreDataSource,
-	})
+	}
+	if opts.ColocateWith != "" {
+		other, err := s.GetTable(ctx, opts.ColocateWith)
+		if err != nil {
+			return err
+		}
+		storeOpts.ID = other.StoreID
+	}
+	us, err := s.usf.New(ctx, storeOpts)
 	if err != nil {
 		return err
 	}
@@ -201,23 +279,116 @@ func (s *adminStore) CreateTable(ctx context.Context, opts CreateTableOptions) e
 	return nil
 }
 
+func (s *adminStore) DropTable(ctx context.Context, tableName string) error {
+	table, err := s.GetTable(ctx, tableName)
+	if err != nil {
+		return err
+	}
+
+	us, err := s.usf.New(ctx, UserStoreOptions{
+		ID: table.StoreID,
+	})
+	if err != nil {
+		return err
+	}
+
+	ustd, ok := us.(UserTableDropperStore)
+	if !ok {
+		return fmt.Errorf("unable to drop table in user store")
+	}
+
+	err = ustd.DropTable(ctx, DropTableOptions{
+		TableName: tableName,
+		IfExists:  true,
+	})
+	if err != nil {
+		return err
+	}
+
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_user_table_permission,
+		Where:     []Predicate{Eq("table_name", tableName)},
+	}); err != nil {
+		return err
+	}
+
+	for _, policyTable := range []string{global_role_permissions, global_row_policies} {
+		if _, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeDelete,
+			TableName: policyTable,
+			Where:     []Predicate{Eq("table_name", tableName)},
+		}); err != nil {
+			return err
+		}
+	}
+
+	_, err = s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_tables_table,
+		Where:     []Predicate{Eq("id", table.ID)},
+	})
+
+	return err
+}
+
 func (s *adminStore) AddUser(ctx context.Context, userName string) (string, error) {
+	if userName == "" {
+		return "", fmt.Errorf("user name is empty")
+	}
 
 	res, err := s.store.Query(ctx, QueryOptions{
 		TableName:      global_users,
-		IncludeColumns: []string{"name", "token"},
-		Where:          []string{fmt.Sprintf("name = '%s'", userName)},
+		IncludeColumns: []string{"id"},
+		Where:          []Predicate{Eq("name", userName)},
 		Limit:          1,
 	})
 	if err != nil {
 		return "", err
 	}
+	// only a hash of the token is stored, it cannot be returned again,
+	// further keys are added with AddAPIKey
 	if len(res) == 1 {
-		return res[0]["token"].(string), nil
+		return "", fmt.Errorf("user '%s' already exists", userName)
 	}
-	token := xid.New().String()
 
-	if _, err := s.store.Exec(ctx, ExecOptions{
+	userID, err := s.insertUser(ctx, userName)
+	if err != nil {
+		return "", err
+	}
+
+	_, token, err := s.AddAPIKey(ctx, APIKeyOptions{
+		UserID: userID,
+		Label:  "default",
+	})
+	if err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *adminStore) EnsureUser(ctx context.Context, userName string) (*User, error) {
+	if userName == "" {
+		return nil, fmt.Errorf("user name is empty")
+	}
+
+	user, err := s.GetUserByName(ctx, userName)
+	if err == nil {
+		return user, nil
+	}
+
+	// users authenticated elsewhere, e.g. with a jwt, do not get an api key
+	userID, err := s.insertUser(ctx, userName)
+	if err != nil {
+		return nil, err
+	}
+
+	return &User{ID: userID, UserName: userName}, nil
+}
+
+func (s *adminStore) insertUser(ctx context.Context, userName string) (int64, error) {
+	res, err := s.store.Exec(ctx, ExecOptions{
 		Type:      ExecTypeInsert,
 		TableName: global_users,
 		Values: []FieldValue{
@@ -225,41 +396,71 @@ func (s *adminStore) AddUser(ctx context.Context, userName string) (string, erro
 				Name:  "name",
 				Value: userName,
 			},
-			{
-				Name:  "token",
-				Value: token,
-			},
 		},
-	}); err != nil {
-		return "", err
+	})
+	if err != nil {
+		return 0, err
 	}
-
-	return token, nil
+	return res.LastInsertId, nil
 }
 
-func (s *adminStore) AddPermission(ctx context.Context, userID int64, tableName, permission string) error {
+func (s *adminStore) AddPermission(ctx context.Context, opts PermissionOptions) error {
 
-	if !slices.Contains(defaultPermissions, permission) {
-		return fmt.Errorf("invalid permission %s. should be one of '%s'", permission, strings.Join(defaultPermissions, ","))
+	if err := opts.Validate(); err != nil {
+		return err
 	}
 
 	//TODO: validate token and table exists
 
 	res, err := s.store.Query(ctx, QueryOptions{
 		TableName:      global_user_table_permission,
-		IncludeColumns: []string{"user_id", "table_name", "permission"},
-		Where: []string{
-			fmt.Sprintf("user_id = %d", userID),
-			fmt.Sprintf("table_name = '%s'", tableName),
-			fmt.Sprintf("permission = '%s'", permission),
+		IncludeColumns: []string{"user_id", "table_name", "permission", "columns", "not_before", "not_after"},
+		Where: []Predicate{
+			Eq("user_id", opts.UserID),
+			Eq("table_name", opts.TableName),
+			Eq("permission", opts.Permission),
 		},
 		Limit: 1,
 	})
 	if err != nil {
 		return err
 	}
+
+	columns := strings.Join(opts.Columns, ",")
+	notBefore, notAfter := unixOrZero(opts.NotBefore), unixOrZero(opts.NotAfter)
+
+	// a grant is identified by (user,table,permission), adding it again
+	// replaces the columns it covers and its window
 	if len(res) == 1 {
-		return nil
+		if res[0]["columns"].(string) == columns &&
+			res[0]["not_before"].(int64) == notBefore &&
+			res[0]["not_after"].(int64) == notAfter {
+			return nil
+		}
+		_, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeUpdate,
+			TableName: global_user_table_permission,
+			Values: []FieldValue{
+				{
+					Name:  "columns",
+					Value: columns,
+				},
+				{
+					Name:  "not_before",
+					Value: notBefore,
+				},
+				{
+					Name:  "not_after",
+					Value: notAfter,
+				},
+			},
+			Where: []Predicate{
+				Eq("user_id", opts.UserID),
+				Eq("table_name", opts.TableName),
+				Eq("permission", opts.Permission),
+			},
+		})
+		return err
 	}
 
 	if _, err := s.store.Exec(ctx, ExecOptions{
@@ -268,15 +469,27 @@ func (s *adminStore) AddPermission(ctx context.Context, userID int64, tableName,
 		Values: []FieldValue{
 			{
 				Name:  "user_id",
-				Value: userID,
+				Value: opts.UserID,
 			},
 			{
 				Name:  "table_name",
-				Value: tableName,
+				Value: opts.TableName,
 			},
 			{
 				Name:  "permission",
-				Value: permission,
+				Value: opts.Permission,
+			},
+			{
+				Name:  "columns",
+				Value: columns,
+			},
+			{
+				Name:  "not_before",
+				Value: notBefore,
+			},
+			{
+				Name:  "not_after",
+				Value: notAfter,
 			},
 		},
 	}); err != nil {
@@ -286,38 +499,160 @@ func (s *adminStore) AddPermission(ctx context.Context, userID int64, tableName,
 	return nil
 }
 
-func (s *adminStore) GetPermissionsForToken(ctx context.Context, token string) ([]TablePermission, error) {
+func (s *adminStore) RemovePermission(ctx context.Context, userID int64, tableName, permission string) error {
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_user_table_permission,
+		Where: []Predicate{
+			Eq("user_id", userID),
+			Eq("table_name", tableName),
+			Eq("permission", permission),
+		},
+	})
+	return err
+}
 
+func (s *adminStore) GetPermissionsForToken(ctx context.Context, token string) ([]TablePermission, error) {
 	user, err := s.GetUser(ctx, token)
 	if err != nil {
 		return nil, err
 	}
 
+	return s.GetPermissionsForUser(ctx, user.ID)
+}
+
+func (s *adminStore) GetPermissionsForUser(ctx context.Context, userID int64) ([]TablePermission, error) {
 	res, err := s.store.Query(ctx, QueryOptions{
 		TableName:      global_user_table_permission,
-		IncludeColumns: []string{"user_id", "table_name", "permission"},
-		Where:          []string{fmt.Sprintf("user_id = %d", user.ID)},
+		IncludeColumns: []string{"user_id", "table_name", "permission", "columns", "not_before", "not_after"},
+		Where:          []Predicate{Eq("user_id", userID)},
 	})
 	if err != nil {
 		return nil, err
 	}
 
+	ret := tablePermissionsFromRecords(res)
+
+	rolePerms, err := s.getRolePermissionsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	// union of direct and role grants, identical grants are returned once
+	for _, perm := range rolePerms {
+		if !slices.ContainsFunc(ret, func(p TablePermission) bool {
+			return p.TableName == perm.TableName && p.Permission == perm.Permission && slices.Equal(p.Columns, perm.Columns) &&
+				p.NotBefore.Equal(perm.NotBefore) && p.NotAfter.Equal(perm.NotAfter)
+		}) {
+			ret = append(ret, perm)
+		}
+	}
+
+	return ret, nil
+}
+
+func tablePermissionsFromRecords(res QueryResult) []TablePermission {
 	var ret []TablePermission
 	for _, rec := range res {
-		ret = append(ret, TablePermission{
+		var columns []string
+		if cols := rec["columns"].(string); cols != "" {
+			columns = strings.Split(cols, ",")
+		}
+		perm := TablePermission{
 			TableName:  rec["table_name"].(string),
 			Permission: rec["permission"].(string),
-		})
+			Columns:    columns,
+		}
+		// role grants are not time bounded
+		if v, ok := rec["not_before"]; ok {
+			perm.NotBefore = timeFromUnix(v.(int64))
+		}
+		if v, ok := rec["not_after"]; ok {
+			perm.NotAfter = timeFromUnix(v.(int64))
+		}
+		ret = append(ret, perm)
+	}
+	return ret
+}
+
+// GetExpiringPermissions returns the grants which end between now and before.
+func (s *adminStore) GetExpiringPermissions(ctx context.Context, before time.Time) ([]UserPermission, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_user_table_permission,
+		IncludeColumns: []string{"user_id", "table_name", "permission", "columns", "not_before", "not_after"},
+		Where: []Predicate{
+			NewPredicate("not_after", OpGreaterThan, time.Now().Unix()),
+			NewPredicate("not_after", OpLessEqual, before.Unix()),
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, nil
+	}
+
+	var userIDs []int64
+	for _, rec := range res {
+		if id := rec["user_id"].(int64); !slices.Contains(userIDs, id) {
+			userIDs = append(userIDs, id)
+		}
+	}
+	users, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_users,
+		IncludeColumns: []string{"id", "name"},
+		Where:          []Predicate{NewPredicate("id", OpIn, userIDs)},
+	})
+	if err != nil {
+		return nil, err
+	}
+	names := map[int64]string{}
+	for _, rec := range users {
+		names[rec["id"].(int64)] = rec["name"].(string)
 	}
 
+	var ret []UserPermission
+	for i, perm := range tablePermissionsFromRecords(res) {
+		userID := res[i]["user_id"].(int64)
+		ret = append(ret, UserPermission{
+			UserID:          userID,
+			UserName:        names[userID],
+			TablePermission: perm,
+		})
+	}
 	return ret, nil
 }
 
+func unixOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func timeFromUnix(v int64) time.Time {
+	if v == 0 {
+		return time.Time{}
+	}
+	return time.Unix(v, 0).UTC()
+}
+
 func (s *adminStore) GetUser(ctx context.Context, token string) (*User, error) {
+	key, err := s.getAPIKeyForToken(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.getUserForKey(ctx, key)
+}
+
+// getUserForKey returns the owner of an api key, the user carries the key id
+// and its scope.
+func (s *adminStore) getUserForKey(ctx context.Context, key *APIKey) (*User, error) {
 	res, err := s.store.Query(ctx, QueryOptions{
 		TableName:      global_users,
-		IncludeColumns: []string{"token", "id", "name"},
-		Where:          []string{fmt.Sprintf("token = '%s'", token)},
+		IncludeColumns: []string{"id", "name", "disabled"},
+		Where:          []Predicate{Eq("id", key.UserID)},
 		Limit:          1,
 	})
 	if err != nil {
@@ -325,20 +660,292 @@ func (s *adminStore) GetUser(ctx context.Context, token string) (*User, error) {
 	}
 
 	if len(res) == 0 {
-		return nil, fmt.Errorf("user with token '%s' not found", token)
+		return nil, fmt.Errorf("user of api key '%s' not found", key.ID)
 	}
 
+	user := userFromRecord(res[0])
+	if user.Disabled {
+		return nil, fmt.Errorf("user '%s' is disabled", user.UserName)
+	}
+	user.KeyID = key.ID
+	user.Scope = key.Scope
+
+	return user, nil
+}
+
+func (s *adminStore) GetUserByName(ctx context.Context, userName string) (*User, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_users,
+		IncludeColumns: []string{"id", "name", "disabled"},
+		Where:          []Predicate{Eq("name", userName)},
+		Limit:          1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("user with name '%s' not found", userName)
+	}
+
+	return userFromRecord(res[0]), nil
+}
+
+func userFromRecord(rec map[string]interface{}) *User {
 	return &User{
-		ID:       res[0]["id"].(int64),
-		UserName: res[0]["name"].(string),
-	}, nil
+		ID:       rec["id"].(int64),
+		UserName: rec["name"].(string),
+		Disabled: rec["disabled"].(int64) != 0,
+	}
+}
+
+func (s *adminStore) RenameUser(ctx context.Context, userID int64, userName string) error {
+	if userName == "" {
+		return fmt.Errorf("user name is empty")
+	}
+
+	existing, err := s.GetUserByName(ctx, userName)
+	if err == nil && existing.ID != userID {
+		return fmt.Errorf("user with name '%s' already exists", userName)
+	}
+
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeUpdate,
+		TableName: global_users,
+		Values: []FieldValue{
+			{
+				Name:  "name",
+				Value: userName,
+			},
+		},
+		Where: []Predicate{Eq("id", userID)},
+	})
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("user with id %d not found", userID)
+	}
+
+	return nil
+}
+
+func (s *adminStore) SetUserDisabled(ctx context.Context, userID int64, disabled bool) error {
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeUpdate,
+		TableName: global_users,
+		Values: []FieldValue{
+			{
+				Name:  "disabled",
+				Value: disabled,
+			},
+		},
+		Where: []Predicate{Eq("id", userID)},
+	})
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("user with id %d not found", userID)
+	}
+
+	return nil
+}
+
+func (s *adminStore) DeleteUser(ctx context.Context, userID int64) error {
+	// remove grants, attributes, memberships and keys first so a failure never
+	// leaves them without a user
+	for _, tableName := range []string{global_user_table_permission, global_user_attributes, global_group_members, global_api_keys} {
+		if _, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeDelete,
+			TableName: tableName,
+			Where:     []Predicate{Eq("user_id", userID)},
+		}); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteRoleBindingsForPrincipal(ctx, principalTypeUser, userID); err != nil {
+		return err
+	}
+	if err := s.deleteDenyRulesForPrincipal(ctx, principalTypeUser, userID); err != nil {
+		return err
+	}
+
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_users,
+		Where:     []Predicate{Eq("id", userID)},
+	})
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("user with id %d not found", userID)
+	}
+
+	return nil
+}
+
+func (s *adminStore) SetUserAttribute(ctx context.Context, userID int64, name string, values []interface{}) error {
+	if !validIdentifier(name) {
+		return fmt.Errorf("invalid attribute name '%s'", name)
+	}
+	if name == "id" || name == "name" {
+		return fmt.Errorf("attribute '%s' is reserved", name)
+	}
+
+	var encoded []string
+	for _, v := range values {
+		if v == nil || !isScalar(v) {
+			return fmt.Errorf("attribute '%s' values must be scalars", name)
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return err
+		}
+		encoded = append(encoded, string(value))
+	}
+
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_user_attributes,
+		Where: []Predicate{
+			Eq("user_id", userID),
+			Eq("name", name),
+		},
+	}); err != nil {
+		return err
+	}
+
+	for _, value := range encoded {
+		if _, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeInsert,
+			TableName: global_user_attributes,
+			Values: []FieldValue{
+				{
+					Name:  "user_id",
+					Value: userID,
+				},
+				{
+					Name:  "name",
+					Value: name,
+				},
+				{
+					Name:  "value",
+					Value: value,
+				},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *adminStore) GetUserAttributes(ctx context.Context, userID int64) (map[string][]interface{}, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_user_attributes,
+		IncludeColumns: []string{"name", "value"},
+		Where:          []Predicate{Eq("user_id", userID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make(map[string][]interface{})
+	for _, rec := range res {
+		var value interface{}
+		if err := json.Unmarshal([]byte(rec["value"].(string)), &value); err != nil {
+			return nil, err
+		}
+		name := rec["name"].(string)
+		ret[name] = append(ret[name], value)
+	}
+
+	return ret, nil
+}
+
+func (s *adminStore) AddRowPolicy(ctx context.Context, policy RowPolicy) error {
+	if err := policy.Validate(); err != nil {
+		return err
+	}
+
+	predicate, err := json.Marshal(policy.Predicate)
+	if err != nil {
+		return err
+	}
+
+	// a policy is identified by (table,name), adding it again replaces it
+	if err := s.RemoveRowPolicy(ctx, policy.TableName, policy.Name); err != nil {
+		return err
+	}
+
+	_, err = s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_row_policies,
+		Values: []FieldValue{
+			{
+				Name:  "table_name",
+				Value: policy.TableName,
+			},
+			{
+				Name:  "name",
+				Value: policy.Name,
+			},
+			{
+				Name:  "predicate",
+				Value: string(predicate),
+			},
+		},
+	})
+
+	return err
+}
+
+func (s *adminStore) RemoveRowPolicy(ctx context.Context, tableName, name string) error {
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_row_policies,
+		Where: []Predicate{
+			Eq("table_name", tableName),
+			Eq("name", name),
+		},
+	})
+	return err
+}
+
+func (s *adminStore) GetRowPolicies(ctx context.Context, tableName string) ([]RowPolicy, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_row_policies,
+		IncludeColumns: []string{"table_name", "name", "predicate"},
+		Where:          []Predicate{Eq("table_name", tableName)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []RowPolicy
+	for _, rec := range res {
+		var predicate Predicate
+		if err := json.Unmarshal([]byte(rec["predicate"].(string)), &predicate); err != nil {
+			return nil, err
+		}
+		ret = append(ret, RowPolicy{
+			TableName: rec["table_name"].(string),
+			Name:      rec["name"].(string),
+			Predicate: predicate,
+		})
+	}
+
+	return ret, nil
 }
 
 func (s *adminStore) GetTable(ctx context.Context, tableName string) (*Table, error) {
 	res, err := s.store.Query(ctx, QueryOptions{
 		TableName:      global_tables_table,
 		IncludeColumns: []string{"id", "name", "store_id"},
-		Where:          []string{fmt.Sprintf("name = '%s'", tableName)},
+		Where:          []Predicate{Eq("name", tableName)},
 		Limit:          1,
 	})
 	if err != nil {
@@ -0,0 +1,281 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const global_admins = "global_admins"
+
+func (s *adminStore) initAdmins(ctx context.Context) error {
+	if err := s.store.CreateTable(ctx, CreateTableOptions{
+		TableName: global_admins,
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text", "not null", "unique"},
+			// comma separated list of admin roles
+			{"roles", "text", "not null"},
+			// same scheme as global_api_keys, one key per admin
+			{"prefix", "text", "not null", "unique"},
+			{"salt", "text", "not null"},
+			{"hash", "text", "not null"},
+			// unix seconds
+			{"created_at", "integer", "not null"},
+		},
+		IfNotExists: true,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("created: ", global_admins)
+
+	return nil
+}
+
+func (s *adminStore) BootstrapAdmin(ctx context.Context, adminName string) (string, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_admins,
+		IncludeColumns: []string{"id"},
+		Limit:          1,
+	})
+	if err != nil {
+		return "", err
+	}
+	// once there is an admin every other admin has to be added by a
+	// superadmin through AddAdmin
+	if len(res) == 1 {
+		return "", fmt.Errorf("admins are already bootstrapped")
+	}
+
+	return s.AddAdmin(ctx, AdminOptions{
+		Name:  adminName,
+		Roles: []string{ADMIN_ROLE_SUPERADMIN},
+	})
+}
+
+func (s *adminStore) AddAdmin(ctx context.Context, opts AdminOptions) (string, error) {
+	if err := opts.Validate(); err != nil {
+		return "", err
+	}
+
+	if _, err := s.GetAdminByName(ctx, opts.Name); err == nil {
+		return "", fmt.Errorf("admin '%s' already exists", opts.Name)
+	}
+
+	token, ht, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_admins,
+		Values: []FieldValue{
+			{
+				Name:  "name",
+				Value: opts.Name,
+			},
+			{
+				Name:  "roles",
+				Value: strings.Join(opts.Roles, ","),
+			},
+			{
+				Name:  "prefix",
+				Value: ht.prefix,
+			},
+			{
+				Name:  "salt",
+				Value: ht.salt,
+			},
+			{
+				Name:  "hash",
+				Value: ht.hash,
+			},
+			{
+				Name:  "created_at",
+				Value: time.Now().Unix(),
+			},
+		},
+	}); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+func (s *adminStore) GetAdmin(ctx context.Context, token string) (*Admin, error) {
+	prefix, secret, err := splitToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_admins,
+		IncludeColumns: append([]string{"salt", "hash"}, adminColumns...),
+		Where:          []Predicate{Eq("prefix", prefix)},
+		Limit:          1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// same as for users, the token is never echoed back
+	if len(res) == 0 {
+		return nil, fmt.Errorf("invalid admin token")
+	}
+	ht := hashedToken{
+		salt: res[0]["salt"].(string),
+		hash: res[0]["hash"].(string),
+	}
+	if !ht.verify(secret) {
+		return nil, fmt.Errorf("invalid admin token")
+	}
+
+	admin := adminFromRecord(res[0])
+	admin.KeyID = prefix
+
+	return admin, nil
+}
+
+func (s *adminStore) GetAdminByName(ctx context.Context, adminName string) (*Admin, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_admins,
+		IncludeColumns: adminColumns,
+		Where:          []Predicate{Eq("name", adminName)},
+		Limit:          1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if len(res) == 0 {
+		return nil, fmt.Errorf("admin with name '%s' not found", adminName)
+	}
+
+	return adminFromRecord(res[0]), nil
+}
+
+func (s *adminStore) SetAdminRoles(ctx context.Context, adminID int64, roles []string) error {
+	if err := validateAdminRoles(roles); err != nil {
+		return err
+	}
+
+	if !slices.Contains(roles, ADMIN_ROLE_SUPERADMIN) {
+		if err := s.checkNotLastSuperadmin(ctx, adminID); err != nil {
+			return err
+		}
+	}
+
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeUpdate,
+		TableName: global_admins,
+		Values: []FieldValue{
+			{
+				Name:  "roles",
+				Value: strings.Join(roles, ","),
+			},
+		},
+		Where: []Predicate{Eq("id", adminID)},
+	})
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("admin with id %d not found", adminID)
+	}
+
+	return nil
+}
+
+func (s *adminStore) RotateAdminKey(ctx context.Context, adminID int64) (string, error) {
+	token, ht, err := newToken()
+	if err != nil {
+		return "", err
+	}
+
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeUpdate,
+		TableName: global_admins,
+		Values: []FieldValue{
+			{
+				Name:  "prefix",
+				Value: ht.prefix,
+			},
+			{
+				Name:  "salt",
+				Value: ht.salt,
+			},
+			{
+				Name:  "hash",
+				Value: ht.hash,
+			},
+		},
+		Where: []Predicate{Eq("id", adminID)},
+	})
+	if err != nil {
+		return "", err
+	}
+	if res.RowsAffected == 0 {
+		return "", fmt.Errorf("admin with id %d not found", adminID)
+	}
+
+	return token, nil
+}
+
+func (s *adminStore) DeleteAdmin(ctx context.Context, adminID int64) error {
+	if err := s.checkNotLastSuperadmin(ctx, adminID); err != nil {
+		return err
+	}
+
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_admins,
+		Where:     []Predicate{Eq("id", adminID)},
+	})
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("admin with id %d not found", adminID)
+	}
+
+	return nil
+}
+
+// checkNotLastSuperadmin fails if adminID is the only superadmin, without
+// one nobody could manage admins anymore and bootstrapping is closed.
+func (s *adminStore) checkNotLastSuperadmin(ctx context.Context, adminID int64) error {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_admins,
+		IncludeColumns: adminColumns,
+	})
+	if err != nil {
+		return err
+	}
+
+	var superadmins []int64
+	for _, rec := range res {
+		if admin := adminFromRecord(rec); slices.Contains(admin.Roles, ADMIN_ROLE_SUPERADMIN) {
+			superadmins = append(superadmins, admin.ID)
+		}
+	}
+	if len(superadmins) == 1 && superadmins[0] == adminID {
+		return fmt.Errorf("admin with id %d is the last superadmin", adminID)
+	}
+
+	return nil
+}
+
+var adminColumns = []string{"id", "name", "roles"}
+
+func adminFromRecord(rec map[string]interface{}) *Admin {
+	return &Admin{
+		ID:    rec["id"].(int64),
+		Name:  rec["name"].(string),
+		Roles: strings.Split(rec["roles"].(string), ","),
+	}
+}
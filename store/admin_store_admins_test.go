@@ -0,0 +1,107 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestAdmins(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rootToken, err := as.BootstrapAdmin(context.TODO(), "root")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// bootstrapping only works once
+	if _, err := as.BootstrapAdmin(context.TODO(), "root2"); err == nil {
+		t.Fatal("expected second bootstrap to fail")
+	}
+
+	root, err := as.GetAdmin(context.TODO(), rootToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, capability := range []string{store.CapabilityManageUsers, store.CapabilityManageSchema, store.CapabilityManageGrants, store.CapabilityManageAdmins} {
+		if !root.Can(capability) {
+			t.Fatalf("expected superadmin to have capability %s", capability)
+		}
+	}
+
+	grantsToken, err := as.AddAdmin(context.TODO(), store.AdminOptions{
+		Name:  "grants",
+		Roles: []string{store.ADMIN_ROLE_GRANT_MANAGER},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	grants, err := as.GetAdmin(context.TODO(), grantsToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !grants.Can(store.CapabilityManageGrants) || grants.Can(store.CapabilityManageUsers) || grants.Can(store.CapabilityManageAdmins) {
+		t.Fatalf("unexpected capabilities for %+v", grants)
+	}
+
+	// admin tokens are not user tokens and the other way around
+	userToken, err := as.AddUser(context.TODO(), "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetUser(context.TODO(), rootToken); err == nil {
+		t.Fatal("expected admin token to not resolve to a user")
+	}
+	if _, err := as.GetAdmin(context.TODO(), userToken); err == nil {
+		t.Fatal("expected user token to not resolve to an admin")
+	}
+
+	for _, opts := range []store.AdminOptions{
+		{Name: "grants", Roles: []string{store.ADMIN_ROLE_USER_MANAGER}},
+		{Name: "", Roles: []string{store.ADMIN_ROLE_USER_MANAGER}},
+		{Name: "nobody"},
+		{Name: "unknown", Roles: []string{"owner"}},
+	} {
+		if _, err := as.AddAdmin(context.TODO(), opts); err == nil {
+			t.Fatalf("expected adding admin %+v to fail", opts)
+		}
+	}
+
+	// the last superadmin can neither be demoted nor deleted
+	if err := as.SetAdminRoles(context.TODO(), root.ID, []string{store.ADMIN_ROLE_USER_MANAGER}); err == nil {
+		t.Fatal("expected demoting the last superadmin to fail")
+	}
+	if err := as.DeleteAdmin(context.TODO(), root.ID); err == nil {
+		t.Fatal("expected deleting the last superadmin to fail")
+	}
+	if err := as.SetAdminRoles(context.TODO(), grants.ID, []string{store.ADMIN_ROLE_SUPERADMIN}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.DeleteAdmin(context.TODO(), root.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetAdmin(context.TODO(), rootToken); err == nil {
+		t.Fatal("expected deleted admin token to fail")
+	}
+
+	rotatedToken, err := as.RotateAdminKey(context.TODO(), grants.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetAdmin(context.TODO(), grantsToken); err == nil {
+		t.Fatal("expected rotated admin token to fail")
+	}
+	rotated, err := as.GetAdmin(context.TODO(), rotatedToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.ID != grants.ID || rotated.KeyID == grants.KeyID {
+		t.Fatalf("unexpected admin %+v after rotation", rotated)
+	}
+}
@@ -0,0 +1,203 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestDenyRules(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tables := []string{"payroll_2023", "payroll_2024", "sales"}
+	for _, table := range tables {
+		err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+			TableName: table,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := as.AddUser(context.TODO(), "analyst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the role grants everything on every table
+	if err := as.AddRole(context.TODO(), "reader"); err != nil {
+		t.Fatal(err)
+	}
+	role, err := as.GetRole(context.TODO(), "reader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range tables {
+		for _, perm := range []string{store.READ_ALL_PERMISSION, store.READ_RESTRICTED_PERMISSION, store.WRITE_ALL_PERMISSION} {
+			err = as.AddRolePermission(context.TODO(), store.RolePermissionOptions{
+				RoleID:     role.ID,
+				TableName:  table,
+				Permission: perm,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := as.AddGroup(context.TODO(), "analysts"); err != nil {
+		t.Fatal(err)
+	}
+	group, err := as.GetGroup(context.TODO(), "analysts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := as.AddGroupMember(context.TODO(), group.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.BindRole(context.TODO(), store.RoleBinding{RoleID: role.ID, GroupID: group.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+	us := ds.AsUser(context.TODO(), store.UserOptions{
+		Token: token,
+	})
+	insert := func(table string) error {
+		_, err := us.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: table,
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		})
+		return err
+	}
+	query := func(table string) (store.QueryResult, error) {
+		return us.Query(context.TODO(), store.QueryOptions{
+			TableName:      table,
+			IncludeColumns: []string{"id", "name"},
+		})
+	}
+
+	for _, table := range tables {
+		if err := insert(table); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// another user writes to the payroll table as well
+	otherToken, err := as.AddUser(context.TODO(), "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := as.GetUser(context.TODO(), otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     other.ID,
+		TableName:  "payroll_2024",
+		Permission: store.WRITE_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ds.AsUser(context.TODO(), store.UserOptions{
+		Token: otherToken,
+	}).Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeInsert,
+		TableName: "payroll_2024",
+		Values:    []store.FieldValue{{Name: "name", Value: "other"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// deny everything on payroll tables for the group
+	err = as.AddDenyRule(context.TODO(), store.DenyRule{
+		Name:         "no-payroll",
+		GroupID:      group.ID,
+		TablePattern: "payroll_*",
+		Permission:   store.AllPermissions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"payroll_2023", "payroll_2024"} {
+		if _, err := query(table); err == nil || !strings.Contains(err.Error(), "no-payroll") {
+			t.Fatalf("expected query on %s to be denied by rule, got %v", table, err)
+		}
+		if err := insert(table); err == nil || !strings.Contains(err.Error(), "no-payroll") {
+			t.Fatalf("expected insert on %s to be denied by rule, got %v", table, err)
+		}
+	}
+	if _, err := query("sales"); err != nil {
+		t.Fatal(err)
+	}
+
+	// denying only READ_ALL falls back to the restricted grant
+	err = as.AddDenyRule(context.TODO(), store.DenyRule{
+		Name:         "no-payroll",
+		RoleID:       role.ID,
+		TablePattern: "payroll_2024",
+		Permission:   store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := query("payroll_2024")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected only own row, got %v", results)
+	}
+
+	// a direct deny on the user works the same way
+	err = as.AddDenyRule(context.TODO(), store.DenyRule{
+		Name:         "no-sales-writes",
+		UserID:       user.ID,
+		TablePattern: "sales",
+		Permission:   store.WRITE_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := insert("sales"); err == nil || !strings.Contains(err.Error(), "no-sales-writes") {
+		t.Fatalf("expected insert to be denied by rule, got %v", err)
+	}
+	if err := as.RemoveDenyRule(context.TODO(), "no-sales-writes"); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert("sales"); err != nil {
+		t.Fatal(err)
+	}
+
+	invalid := []store.DenyRule{
+		{Name: "", UserID: user.ID, TablePattern: "sales", Permission: store.AllPermissions},
+		{Name: "x", TablePattern: "sales", Permission: store.AllPermissions},
+		{Name: "x", UserID: user.ID, GroupID: group.ID, TablePattern: "sales", Permission: store.AllPermissions},
+		{Name: "x", UserID: user.ID, TablePattern: "[", Permission: store.AllPermissions},
+		{Name: "x", UserID: user.ID, TablePattern: "sales", Permission: "READ"},
+	}
+	for _, rule := range invalid {
+		if err := as.AddDenyRule(context.TODO(), rule); err == nil {
+			t.Fatalf("%+v: expected invalid deny rule to be rejected", rule)
+		}
+	}
+}
@@ -0,0 +1,464 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	global_api_keys       = "global_api_keys"
+	global_request_nonces = "global_request_nonces"
+)
+
+// last_used_at is only updated when it is older than this, so that every
+// request does not turn into a write to the admin store
+const lastUsedResolution = time.Minute
+
+func (s *adminStore) initAPIKeys(ctx context.Context) error {
+	if err := s.store.CreateTable(ctx, CreateTableOptions{
+		TableName: global_api_keys,
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"user_id", "integer", "not null"},
+			// non secret part of the key used for lookups, exposed as the key id
+			{"prefix", "text", "not null", "unique"},
+			{"salt", "text", "not null"},
+			// salted sha256 of the secret part of the key
+			{"hash", "text", "not null"},
+			{"label", "text", "not null", "default ''"},
+			// unix seconds, 0 for never
+			{"created_at", "integer", "not null"},
+			{"last_used_at", "integer", "not null", "default 0"},
+			{"expires_at", "integer", "not null", "default 0"},
+			{"revoked_at", "integer", "not null", "default 0"},
+			// json encoded scope, empty for the full access of the user
+			{"scope", "text", "not null", "default ''"},
+			// 1 for signing keys, their token is derived from the signing
+			// secret of the store and not stored
+			{"signing", "integer", "not null", "default 0"},
+		},
+		IfNotExists: true,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("created: ", global_api_keys)
+
+	if err := s.store.CreateTable(ctx, CreateTableOptions{
+		TableName: global_request_nonces,
+		Definitions: [][]string{
+			// '<key id>:<nonce>', the primary key makes recording a nonce
+			// twice fail without a separate lookup
+			{"id", "text", "not null", "primary key"},
+			// unix seconds after which the nonce can be forgotten
+			{"expires_at", "integer", "not null"},
+		},
+		IfNotExists: true,
+	}); err != nil {
+		return err
+	}
+
+	fmt.Println("created: ", global_request_nonces)
+
+	return nil
+}
+
+func (s *adminStore) AddAPIKey(ctx context.Context, opts APIKeyOptions) (*APIKey, string, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, "", err
+	}
+
+	newKeyToken := newToken
+	var signing int64
+	if opts.Signing {
+		if s.signingSecret == nil {
+			return nil, "", fmt.Errorf("signing keys are not enabled")
+		}
+		newKeyToken = func() (string, hashedToken, error) {
+			return newSigningToken(s.signingSecret)
+		}
+		signing = 1
+	}
+	token, ht, err := newKeyToken()
+	if err != nil {
+		return nil, "", err
+	}
+
+	scope, err := encodeKeyScope(opts.Scope)
+	if err != nil {
+		return nil, "", err
+	}
+
+	now := time.Now()
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_api_keys,
+		Values: []FieldValue{
+			{
+				Name:  "user_id",
+				Value: opts.UserID,
+			},
+			{
+				Name:  "prefix",
+				Value: ht.prefix,
+			},
+			{
+				Name:  "salt",
+				Value: ht.salt,
+			},
+			{
+				Name:  "hash",
+				Value: ht.hash,
+			},
+			{
+				Name:  "label",
+				Value: opts.Label,
+			},
+			{
+				Name:  "created_at",
+				Value: now.Unix(),
+			},
+			{
+				Name:  "expires_at",
+				Value: unixOrZero(opts.ExpiresAt),
+			},
+			{
+				Name:  "scope",
+				Value: scope,
+			},
+			{
+				Name:  "signing",
+				Value: signing,
+			},
+		},
+	}); err != nil {
+		return nil, "", err
+	}
+
+	return &APIKey{
+		ID:        ht.prefix,
+		UserID:    opts.UserID,
+		Label:     opts.Label,
+		CreatedAt: timeFromUnix(now.Unix()),
+		ExpiresAt: timeFromUnix(unixOrZero(opts.ExpiresAt)),
+		Scope:     opts.Scope,
+		Signing:   opts.Signing,
+	}, token, nil
+}
+
+func (s *adminStore) GetAPIKeys(ctx context.Context, userID int64) ([]APIKey, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_api_keys,
+		IncludeColumns: apiKeyColumns,
+		Where:          []Predicate{Eq("user_id", userID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []APIKey
+	for _, rec := range res {
+		key, err := apiKeyFromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, *key)
+	}
+	return ret, nil
+}
+
+func (s *adminStore) RotateAPIKey(ctx context.Context, keyID string, gracePeriod time.Duration) (*APIKey, string, error) {
+	key, err := s.getAPIKey(ctx, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+	if !key.RevokedAt.IsZero() {
+		return nil, "", fmt.Errorf("api key '%s' is revoked", keyID)
+	}
+
+	newKey, token, err := s.AddAPIKey(ctx, APIKeyOptions{
+		UserID:    key.UserID,
+		Label:     key.Label,
+		ExpiresAt: key.ExpiresAt,
+		Scope:     key.Scope,
+		Signing:   key.Signing,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// the old key keeps working for the grace period so that clients can
+	// switch over, it is revoked right away without one
+	if gracePeriod <= 0 {
+		return newKey, token, s.RevokeAPIKey(ctx, keyID)
+	}
+	expiresAt := time.Now().Add(gracePeriod)
+	if !key.ExpiresAt.IsZero() && key.ExpiresAt.Before(expiresAt) {
+		return newKey, token, nil
+	}
+	_, err = s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeUpdate,
+		TableName: global_api_keys,
+		Values: []FieldValue{
+			{
+				Name:  "expires_at",
+				Value: expiresAt.Unix(),
+			},
+		},
+		Where: []Predicate{Eq("prefix", keyID)},
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	return newKey, token, nil
+}
+
+func (s *adminStore) RevokeAPIKey(ctx context.Context, keyID string) error {
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeUpdate,
+		TableName: global_api_keys,
+		Values: []FieldValue{
+			{
+				Name:  "revoked_at",
+				Value: time.Now().Unix(),
+			},
+		},
+		Where: []Predicate{
+			Eq("prefix", keyID),
+			Eq("revoked_at", 0),
+		},
+	})
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		if _, err := s.getAPIKey(ctx, keyID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAPIKeyForToken verifies the token against the stored keys and returns
+// the active key matching it.
+func (s *adminStore) getAPIKeyForToken(ctx context.Context, token string) (*APIKey, error) {
+	prefix, secret, err := splitToken(token)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_api_keys,
+		IncludeColumns: append([]string{"salt", "hash"}, apiKeyColumns...),
+		Where:          []Predicate{Eq("prefix", prefix)},
+		Limit:          1,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the token is never echoed back, not even its prefix
+	if len(res) == 0 {
+		return nil, fmt.Errorf("invalid token")
+	}
+	ht := hashedToken{
+		salt: res[0]["salt"].(string),
+		hash: res[0]["hash"].(string),
+	}
+	if !ht.verify(secret) {
+		return nil, fmt.Errorf("invalid token")
+	}
+
+	key, err := apiKeyFromRecord(res[0])
+	if err != nil {
+		return nil, err
+	}
+	// a signing key sent as a bearer token may have been captured, it is
+	// only good for signing requests
+	if key.Signing {
+		return nil, fmt.Errorf("api key '%s' can only be used to sign requests", key.ID)
+	}
+
+	if err := s.checkAPIKey(ctx, key); err != nil {
+		return nil, err
+	}
+
+	return key, nil
+}
+
+// getSigningKey returns the active signing key keyID along with the token
+// requests are signed with.
+func (s *adminStore) getSigningKey(ctx context.Context, keyID string) (*APIKey, string, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_api_keys,
+		IncludeColumns: apiKeyColumns,
+		Where:          []Predicate{Eq("prefix", keyID)},
+		Limit:          1,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if len(res) == 0 {
+		return nil, "", fmt.Errorf("api key '%s' not found", keyID)
+	}
+
+	key, err := apiKeyFromRecord(res[0])
+	if err != nil {
+		return nil, "", err
+	}
+	if !key.Signing {
+		return nil, "", fmt.Errorf("api key '%s' is not a signing key", key.ID)
+	}
+	if s.signingSecret == nil {
+		return nil, "", fmt.Errorf("signing keys are not enabled")
+	}
+
+	if err := s.checkAPIKey(ctx, key); err != nil {
+		return nil, "", err
+	}
+
+	return key, key.ID + tokenSeparator + signingSecret(s.signingSecret, key.ID), nil
+}
+
+// checkAPIKey fails for revoked and expired keys and records the use of the
+// key otherwise.
+func (s *adminStore) checkAPIKey(ctx context.Context, key *APIKey) error {
+	now := time.Now()
+	if !key.RevokedAt.IsZero() {
+		return fmt.Errorf("api key '%s' is revoked", key.ID)
+	}
+	if !key.ExpiresAt.IsZero() && !now.Before(key.ExpiresAt) {
+		return fmt.Errorf("api key '%s' is expired", key.ID)
+	}
+
+	if now.Sub(key.LastUsedAt) >= lastUsedResolution {
+		if _, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeUpdate,
+			TableName: global_api_keys,
+			Values: []FieldValue{
+				{
+					Name:  "last_used_at",
+					Value: now.Unix(),
+				},
+			},
+			Where: []Predicate{Eq("prefix", key.ID)},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *adminStore) GetUserForSigningKey(ctx context.Context, keyID string) (*User, string, error) {
+	key, token, err := s.getSigningKey(ctx, keyID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	user, err := s.getUserForKey(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return user, token, nil
+}
+
+func (s *adminStore) UseNonce(ctx context.Context, keyID, nonce string, expiresAt time.Time) error {
+	if nonce == "" {
+		return fmt.Errorf("nonce is empty")
+	}
+
+	now := time.Now().Unix()
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_request_nonces,
+		Where:     []Predicate{NewPredicate("expires_at", OpLessThan, now)},
+	}); err != nil {
+		return err
+	}
+
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_request_nonces,
+		Values: []FieldValue{
+			{
+				Name:  "id",
+				Value: keyID + ":" + nonce,
+			},
+			{
+				Name:  "expires_at",
+				Value: expiresAt.Unix(),
+			},
+		},
+	}); err != nil {
+		if isUniqueViolation(err) {
+			return fmt.Errorf("nonce was already used")
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s *adminStore) getAPIKey(ctx context.Context, keyID string) (*APIKey, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_api_keys,
+		IncludeColumns: apiKeyColumns,
+		Where:          []Predicate{Eq("prefix", keyID)},
+		Limit:          1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("api key '%s' not found", keyID)
+	}
+	return apiKeyFromRecord(res[0])
+}
+
+var apiKeyColumns = []string{"user_id", "prefix", "label", "created_at", "last_used_at", "expires_at", "revoked_at", "scope", "signing"}
+
+func apiKeyFromRecord(rec map[string]interface{}) (*APIKey, error) {
+	scope, err := decodeKeyScope(rec["scope"].(string))
+	if err != nil {
+		return nil, err
+	}
+
+	return &APIKey{
+		ID:         rec["prefix"].(string),
+		UserID:     rec["user_id"].(int64),
+		Label:      rec["label"].(string),
+		CreatedAt:  timeFromUnix(rec["created_at"].(int64)),
+		LastUsedAt: timeFromUnix(rec["last_used_at"].(int64)),
+		ExpiresAt:  timeFromUnix(rec["expires_at"].(int64)),
+		RevokedAt:  timeFromUnix(rec["revoked_at"].(int64)),
+		Scope:      scope,
+		Signing:    rec["signing"].(int64) != 0,
+	}, nil
+}
+
+func encodeKeyScope(scope []KeyScope) (string, error) {
+	if len(scope) == 0 {
+		return "", nil
+	}
+	b, err := json.Marshal(scope)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+func decodeKeyScope(s string) ([]KeyScope, error) {
+	if s == "" {
+		return nil, nil
+	}
+	var scope []KeyScope
+	if err := json.Unmarshal([]byte(s), &scope); err != nil {
+		return nil, err
+	}
+	return scope, nil
+}
@@ -0,0 +1,391 @@
+package store_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestAPIKeys(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := as.AddUser(context.TODO(), "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, ciToken, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{
+		UserID: user.ID,
+		Label:  "ci",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// every key resolves to the same user
+	ciUser, err := as.GetUser(context.TODO(), ciToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ciUser.ID != user.ID || ciUser.KeyID != key.ID || user.KeyID == key.ID {
+		t.Fatalf("unexpected user %+v for key %+v", ciUser, key)
+	}
+
+	keys, err := as.GetAPIKeys(context.TODO(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 || keys[0].Label != "default" || keys[1].Label != "ci" {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+	if keys[0].LastUsedAt.IsZero() || keys[0].CreatedAt.IsZero() {
+		t.Fatalf("expected timestamps to be set %+v", keys[0])
+	}
+
+	// rotation with a grace period keeps the old key working for now
+	rotated, rotatedToken, err := as.RotateAPIKey(context.TODO(), key.ID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rotated.Label != "ci" {
+		t.Fatalf("expected rotated key to keep the label, got %+v", rotated)
+	}
+	for _, tok := range []string{ciToken, rotatedToken} {
+		if _, err := as.GetUser(context.TODO(), tok); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// rotation without a grace period revokes the old key
+	_, newToken, err := as.RotateAPIKey(context.TODO(), rotated.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetUser(context.TODO(), rotatedToken); err == nil {
+		t.Fatal("expected rotated key to be revoked")
+	}
+	if _, err := as.GetUser(context.TODO(), newToken); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.RevokeAPIKey(context.TODO(), key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetUser(context.TODO(), ciToken); err == nil {
+		t.Fatal("expected revoked key to be rejected")
+	}
+	// revoking twice is fine, unknown keys are not
+	if err := as.RevokeAPIKey(context.TODO(), key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.RevokeAPIKey(context.TODO(), "unknown"); err == nil {
+		t.Fatal("expected revoking an unknown key to fail")
+	}
+	if _, _, err := as.RotateAPIKey(context.TODO(), key.ID, 0); err == nil {
+		t.Fatal("expected rotating a revoked key to fail")
+	}
+
+	// the default key is unaffected
+	if _, err := as.GetUser(context.TODO(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{
+		UserID:    user.ID,
+		ExpiresAt: time.Now().Add(-time.Minute),
+	}); err == nil {
+		t.Fatal("expected key expiring in the past to be rejected")
+	}
+
+	// keys go away with the user
+	if err := as.DeleteUser(context.TODO(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetUser(context.TODO(), newToken); err == nil {
+		t.Fatal("expected keys of a deleted user to be rejected")
+	}
+}
+
+func TestScopedAPIKeys(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"foo", "bar"} {
+		err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+			TableName: table,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := as.AddUser(context.TODO(), "owner")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, perm := range []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION} {
+		err = as.AddPermission(context.TODO(), store.PermissionOptions{
+			UserID:     user.ID,
+			TableName:  "*",
+			Permission: perm,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// a second user writes a row to foo as well
+	otherToken, err := as.AddUser(context.TODO(), "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := as.GetUser(context.TODO(), otherToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     other.ID,
+		TableName:  "foo",
+		Permission: store.WRITE_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+	insert := func(token, table string) error {
+		_, err := ds.AsUser(context.TODO(), store.UserOptions{
+			Token: token,
+		}).Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: table,
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		})
+		return err
+	}
+	query := func(token, table string) (store.QueryResult, error) {
+		return ds.AsUser(context.TODO(), store.UserOptions{
+			Token: token,
+		}).Query(context.TODO(), store.QueryOptions{
+			TableName:      table,
+			IncludeColumns: []string{"id", "name"},
+		})
+	}
+
+	if err := insert(token, "foo"); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(otherToken, "foo"); err != nil {
+		t.Fatal(err)
+	}
+
+	_, readFoo, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{
+		UserID: user.ID,
+		Label:  "ci",
+		Scope:  []store.KeyScope{{TablePattern: "foo", Permission: store.READ_ALL_PERMISSION}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := query(readFoo, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 rows, got %v", results)
+	}
+	if err := insert(readFoo, "foo"); err == nil {
+		t.Fatal("expected insert with a read only key to fail")
+	}
+	if _, err := query(readFoo, "bar"); err == nil {
+		t.Fatal("expected query on a table outside of the scope to fail")
+	}
+
+	// a restricted scope narrows the READ_ALL grant of the owner
+	_, readOwn, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{
+		UserID: user.ID,
+		Scope:  []store.KeyScope{{TablePattern: "*", Permission: store.READ_RESTRICTED_PERMISSION}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = query(readOwn, "foo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected only own row, got %v", results)
+	}
+
+	// a key never exceeds its owner
+	_, deleteKey, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{
+		UserID: user.ID,
+		Scope:  []store.KeyScope{{TablePattern: "*", Permission: store.AllPermissions}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = ds.AsUser(context.TODO(), store.UserOptions{
+		Token: deleteKey,
+	}).Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeDelete,
+		TableName: "foo",
+		Where:     []store.Predicate{store.NewPredicate("id", store.OpGreaterThan, 0)},
+	})
+	if err == nil {
+		t.Fatal("expected delete without a delete grant to fail")
+	}
+	if err := insert(deleteKey, "bar"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, _, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{
+		UserID: user.ID,
+		Scope:  []store.KeyScope{{TablePattern: "foo", Permission: "READ"}},
+	}); err == nil {
+		t.Fatal("expected invalid scope to be rejected")
+	}
+}
+
+func TestSigningAPIKeys(t *testing.T) {
+	dataSource := filepath.Join(t.TempDir(), "admin.db")
+	usf := store.NewUserStoreFactory()
+	secret := bytes.Repeat([]byte("s"), 32)
+
+	// signing keys need a secret to derive their tokens from
+	plain, err := store.NewAdminStore(context.TODO(), ":memory:", ":memory:", usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainToken, err := plain.AddUser(context.TODO(), "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	plainUser, err := plain.GetUser(context.TODO(), plainToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := plain.AddAPIKey(context.TODO(), store.APIKeyOptions{UserID: plainUser.ID, Signing: true}); err == nil {
+		t.Fatal("expected signing key without a signing secret to be rejected")
+	}
+	if _, err := store.NewAdminStore(context.TODO(), ":memory:", ":memory:", usf, store.WithSigningSecret([]byte("short"))); err == nil {
+		t.Fatal("expected short signing secret to be rejected")
+	}
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, ":memory:", usf, store.WithSigningSecret(secret))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := as.AddUser(context.TODO(), "service")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, signingToken, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{
+		UserID:  user.ID,
+		Label:   "signing",
+		Signing: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !key.Signing {
+		t.Fatalf("expected a signing key, got %+v", key)
+	}
+
+	// signing keys can not be used as bearer tokens
+	if _, err := as.GetUser(context.TODO(), signingToken); err == nil {
+		t.Fatal("expected signing key to be rejected as a token")
+	}
+	// and bearer keys can not sign
+	if _, _, err := as.GetUserForSigningKey(context.TODO(), user.KeyID); err == nil {
+		t.Fatal("expected bearer key to be rejected for signing")
+	}
+
+	signer, got, err := as.GetUserForSigningKey(context.TODO(), key.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.ID != user.ID || signer.KeyID != key.ID || got != signingToken {
+		t.Fatalf("unexpected signer %+v", signer)
+	}
+
+	// the token is derived, it is nowhere in the database
+	db, err := os.ReadFile(dataSource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, secretPart, _ := strings.Cut(signingToken, ".")
+	if bytes.Contains(db, []byte(secretPart)) {
+		t.Fatal("expected signing token not to be stored")
+	}
+
+	// nonces can only be used once per key
+	expiresAt := time.Now().Add(time.Minute)
+	if err := as.UseNonce(context.TODO(), key.ID, "n1", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.UseNonce(context.TODO(), key.ID, "n1", expiresAt); err == nil {
+		t.Fatal("expected nonce replay to be rejected")
+	}
+	if err := as.UseNonce(context.TODO(), user.KeyID, "n1", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	// expired nonces are forgotten
+	if err := as.UseNonce(context.TODO(), key.ID, "n2", time.Now().Add(-time.Minute)); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.UseNonce(context.TODO(), key.ID, "n2", expiresAt); err != nil {
+		t.Fatal(err)
+	}
+
+	// rotated signing keys stay signing keys
+	rotated, rotatedToken, err := as.RotateAPIKey(context.TODO(), key.ID, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !rotated.Signing {
+		t.Fatalf("expected a signing key, got %+v", rotated)
+	}
+	if _, _, err := as.GetUserForSigningKey(context.TODO(), key.ID); err == nil {
+		t.Fatal("expected rotated key to be rejected")
+	}
+	if _, got, err := as.GetUserForSigningKey(context.TODO(), rotated.ID); err != nil || got != rotatedToken {
+		t.Fatalf("unexpected signing key token, err %v", err)
+	}
+}
@@ -0,0 +1,704 @@
+package store
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	global_roles            = "global_roles"
+	global_role_permissions = "global_role_permissions"
+	global_groups           = "global_groups"
+	global_group_members    = "global_group_members"
+	global_role_bindings    = "global_role_bindings"
+	global_deny_rules       = "global_deny_rules"
+)
+
+const (
+	principalTypeUser  = "user"
+	principalTypeGroup = "group"
+	principalTypeRole  = "role"
+)
+
+func (s *adminStore) initRBAC(ctx context.Context) error {
+	tables := []CreateTableOptions{
+		{
+			TableName: global_roles,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text", "not null", "unique"},
+			},
+		},
+		{
+			TableName: global_role_permissions,
+			Definitions: [][]string{
+				{"role_id", "integer", "not null"},
+				{"table_name", "text", "not null"},
+				{"permission", "text", "not null"},
+				// comma separated list of columns, empty for all columns
+				{"columns", "text", "not null", "default ''"},
+			},
+		},
+		{
+			TableName: global_groups,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text", "not null", "unique"},
+			},
+		},
+		{
+			TableName: global_group_members,
+			Definitions: [][]string{
+				{"group_id", "integer", "not null"},
+				{"user_id", "integer", "not null"},
+				// who manages the membership, e.g. 'jwt' for the groups
+				// claim, empty for memberships added by admins
+				{"source", "text", "not null", "default ''"},
+			},
+		},
+		{
+			TableName: global_role_bindings,
+			Definitions: [][]string{
+				{"role_id", "integer", "not null"},
+				// user or group
+				{"principal_type", "text", "not null"},
+				{"principal_id", "integer", "not null"},
+			},
+		},
+		{
+			TableName: global_deny_rules,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text", "not null", "unique"},
+				// user, group or role
+				{"principal_type", "text", "not null"},
+				{"principal_id", "integer", "not null"},
+				{"table_pattern", "text", "not null"},
+				// one of the permissions or '*'
+				{"permission", "text", "not null"},
+			},
+		},
+	}
+
+	for _, table := range tables {
+		table.IfNotExists = true
+		if err := s.store.CreateTable(ctx, table); err != nil {
+			return err
+		}
+		fmt.Println("created: ", table.TableName)
+	}
+
+	return nil
+}
+
+func (s *adminStore) AddRole(ctx context.Context, roleName string) error {
+	return s.addNamed(ctx, global_roles, roleName)
+}
+
+func (s *adminStore) GetRole(ctx context.Context, roleName string) (*Role, error) {
+	id, err := s.getNamed(ctx, global_roles, roleName)
+	if err != nil {
+		return nil, err
+	}
+	return &Role{ID: id, Name: roleName}, nil
+}
+
+func (s *adminStore) DeleteRole(ctx context.Context, roleID int64) error {
+	for _, tableName := range []string{global_role_permissions, global_role_bindings} {
+		if _, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeDelete,
+			TableName: tableName,
+			Where:     []Predicate{Eq("role_id", roleID)},
+		}); err != nil {
+			return err
+		}
+	}
+	if err := s.deleteDenyRulesForPrincipal(ctx, principalTypeRole, roleID); err != nil {
+		return err
+	}
+	return s.deleteByID(ctx, global_roles, roleID)
+}
+
+func (s *adminStore) AddRolePermission(ctx context.Context, opts RolePermissionOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	where := []Predicate{
+		Eq("role_id", opts.RoleID),
+		Eq("table_name", opts.TableName),
+		Eq("permission", opts.Permission),
+	}
+
+	// same semantics as AddPermission, adding the grant again replaces the
+	// columns it covers
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_role_permissions,
+		Where:     where,
+	}); err != nil {
+		return err
+	}
+
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_role_permissions,
+		Values: []FieldValue{
+			{
+				Name:  "role_id",
+				Value: opts.RoleID,
+			},
+			{
+				Name:  "table_name",
+				Value: opts.TableName,
+			},
+			{
+				Name:  "permission",
+				Value: opts.Permission,
+			},
+			{
+				Name:  "columns",
+				Value: strings.Join(opts.Columns, ","),
+			},
+		},
+	})
+
+	return err
+}
+
+func (s *adminStore) RemoveRolePermission(ctx context.Context, roleID int64, tableName, permission string) error {
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_role_permissions,
+		Where: []Predicate{
+			Eq("role_id", roleID),
+			Eq("table_name", tableName),
+			Eq("permission", permission),
+		},
+	})
+	return err
+}
+
+func (s *adminStore) AddGroup(ctx context.Context, groupName string) error {
+	return s.addNamed(ctx, global_groups, groupName)
+}
+
+func (s *adminStore) GetGroup(ctx context.Context, groupName string) (*Group, error) {
+	id, err := s.getNamed(ctx, global_groups, groupName)
+	if err != nil {
+		return nil, err
+	}
+	return &Group{ID: id, Name: groupName}, nil
+}
+
+func (s *adminStore) DeleteGroup(ctx context.Context, groupID int64) error {
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_group_members,
+		Where:     []Predicate{Eq("group_id", groupID)},
+	}); err != nil {
+		return err
+	}
+	if err := s.deleteRoleBindingsForPrincipal(ctx, principalTypeGroup, groupID); err != nil {
+		return err
+	}
+	if err := s.deleteDenyRulesForPrincipal(ctx, principalTypeGroup, groupID); err != nil {
+		return err
+	}
+	return s.deleteByID(ctx, global_groups, groupID)
+}
+
+func (s *adminStore) AddGroupMember(ctx context.Context, groupID, userID int64) error {
+	where := []Predicate{
+		Eq("group_id", groupID),
+		Eq("user_id", userID),
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_group_members,
+		IncludeColumns: []string{"source"},
+		Where:          where,
+		Limit:          1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(res) == 1 {
+		if res[0]["source"].(string) == "" {
+			return nil
+		}
+		// an admin adding a membership takes it over from its source
+		_, err = s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeUpdate,
+			TableName: global_group_members,
+			Values:    []FieldValue{{Name: "source", Value: ""}},
+			Where:     where,
+		})
+		return err
+	}
+
+	_, err = s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_group_members,
+		Values: []FieldValue{
+			{
+				Name:  "group_id",
+				Value: groupID,
+			},
+			{
+				Name:  "user_id",
+				Value: userID,
+			},
+		},
+	})
+
+	return err
+}
+
+func (s *adminStore) SyncGroupMembers(ctx context.Context, userID int64, source string, groupIDs []int64) error {
+	if source == "" {
+		return fmt.Errorf("source is empty")
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_group_members,
+		IncludeColumns: []string{"group_id", "source"},
+		Where:          []Predicate{Eq("user_id", userID)},
+	})
+	if err != nil {
+		return err
+	}
+
+	member := make(map[int64]bool, len(res))
+	for _, rec := range res {
+		groupID := rec["group_id"].(int64)
+		member[groupID] = true
+		if rec["source"].(string) != source || slices.Contains(groupIDs, groupID) {
+			continue
+		}
+		if _, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeDelete,
+			TableName: global_group_members,
+			Where: []Predicate{
+				Eq("group_id", groupID),
+				Eq("user_id", userID),
+				Eq("source", source),
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, groupID := range groupIDs {
+		if member[groupID] {
+			continue
+		}
+		member[groupID] = true
+		if _, err := s.store.Exec(ctx, ExecOptions{
+			Type:      ExecTypeInsert,
+			TableName: global_group_members,
+			Values: []FieldValue{
+				{Name: "group_id", Value: groupID},
+				{Name: "user_id", Value: userID},
+				{Name: "source", Value: source},
+			},
+		}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s *adminStore) RemoveGroupMember(ctx context.Context, groupID, userID int64) error {
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_group_members,
+		Where: []Predicate{
+			Eq("group_id", groupID),
+			Eq("user_id", userID),
+		},
+	})
+	return err
+}
+
+func (s *adminStore) BindRole(ctx context.Context, binding RoleBinding) error {
+	if err := binding.Validate(); err != nil {
+		return err
+	}
+
+	where := binding.predicates()
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_role_bindings,
+		IncludeColumns: []string{"role_id"},
+		Where:          where,
+		Limit:          1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(res) == 1 {
+		return nil
+	}
+
+	principalType, principalID := binding.principal()
+	_, err = s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_role_bindings,
+		Values: []FieldValue{
+			{
+				Name:  "role_id",
+				Value: binding.RoleID,
+			},
+			{
+				Name:  "principal_type",
+				Value: principalType,
+			},
+			{
+				Name:  "principal_id",
+				Value: principalID,
+			},
+		},
+	})
+
+	return err
+}
+
+func (s *adminStore) UnbindRole(ctx context.Context, binding RoleBinding) error {
+	if err := binding.Validate(); err != nil {
+		return err
+	}
+
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_role_bindings,
+		Where:     binding.predicates(),
+	})
+	return err
+}
+
+func (s *adminStore) deleteRoleBindingsForPrincipal(ctx context.Context, principalType string, principalID int64) error {
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_role_bindings,
+		Where: []Predicate{
+			Eq("principal_type", principalType),
+			Eq("principal_id", principalID),
+		},
+	})
+	return err
+}
+
+func (b RoleBinding) principal() (string, int64) {
+	if b.UserID != 0 {
+		return principalTypeUser, b.UserID
+	}
+	return principalTypeGroup, b.GroupID
+}
+
+func (b RoleBinding) predicates() []Predicate {
+	principalType, principalID := b.principal()
+	return []Predicate{
+		Eq("role_id", b.RoleID),
+		Eq("principal_type", principalType),
+		Eq("principal_id", principalID),
+	}
+}
+
+func (s *adminStore) GetUserGroups(ctx context.Context, userID int64) ([]Group, error) {
+	groupIDs, err := s.getGroupIDsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(groupIDs) == 0 {
+		return nil, nil
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_groups,
+		IncludeColumns: []string{"id", "name"},
+		Where:          []Predicate{NewPredicate("id", OpIn, groupIDs)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Group
+	for _, rec := range res {
+		ret = append(ret, Group{
+			ID:   rec["id"].(int64),
+			Name: rec["name"].(string),
+		})
+	}
+	return ret, nil
+}
+
+// getGroupIDsForUser returns the ids of the groups the user is a member of.
+func (s *adminStore) getGroupIDsForUser(ctx context.Context, userID int64) ([]int64, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_group_members,
+		IncludeColumns: []string{"group_id"},
+		Where:          []Predicate{Eq("user_id", userID)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []int64
+	for _, rec := range res {
+		ret = append(ret, rec["group_id"].(int64))
+	}
+	return ret, nil
+}
+
+// getRoleIDsForUser returns the ids of the roles bound to the user directly
+// or through one of their groups.
+func (s *adminStore) getRoleIDsForUser(ctx context.Context, userID int64) ([]int64, error) {
+	groupIDs, err := s.getGroupIDsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	principals := []Predicate{
+		And(Eq("principal_type", principalTypeUser), Eq("principal_id", userID)),
+	}
+	if len(groupIDs) > 0 {
+		principals = append(principals, And(
+			Eq("principal_type", principalTypeGroup),
+			NewPredicate("principal_id", OpIn, groupIDs),
+		))
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_role_bindings,
+		IncludeColumns: []string{"role_id"},
+		Where:          []Predicate{Or(principals...)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []int64
+	for _, rec := range res {
+		id := rec["role_id"].(int64)
+		if !slices.Contains(ret, id) {
+			ret = append(ret, id)
+		}
+	}
+	return ret, nil
+}
+
+// getRolePermissionsForUser returns the grants of every role bound to the
+// user.
+func (s *adminStore) getRolePermissionsForUser(ctx context.Context, userID int64) ([]TablePermission, error) {
+	roleIDs, err := s.getRoleIDsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	if len(roleIDs) == 0 {
+		return nil, nil
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_role_permissions,
+		IncludeColumns: []string{"table_name", "permission", "columns"},
+		Where:          []Predicate{NewPredicate("role_id", OpIn, roleIDs)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return tablePermissionsFromRecords(res), nil
+}
+
+func (s *adminStore) AddDenyRule(ctx context.Context, rule DenyRule) error {
+	if err := rule.Validate(); err != nil {
+		return err
+	}
+
+	if err := s.RemoveDenyRule(ctx, rule.Name); err != nil {
+		return err
+	}
+
+	principalType, principalID := rule.principal()
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: global_deny_rules,
+		Values: []FieldValue{
+			{
+				Name:  "name",
+				Value: rule.Name,
+			},
+			{
+				Name:  "principal_type",
+				Value: principalType,
+			},
+			{
+				Name:  "principal_id",
+				Value: principalID,
+			},
+			{
+				Name:  "table_pattern",
+				Value: rule.TablePattern,
+			},
+			{
+				Name:  "permission",
+				Value: rule.Permission,
+			},
+		},
+	})
+
+	return err
+}
+
+func (s *adminStore) RemoveDenyRule(ctx context.Context, name string) error {
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_deny_rules,
+		Where:     []Predicate{Eq("name", name)},
+	})
+	return err
+}
+
+func (s *adminStore) GetDenyRulesForToken(ctx context.Context, token string) ([]DenyRule, error) {
+	user, err := s.GetUser(ctx, token)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.GetDenyRulesForUser(ctx, user.ID)
+}
+
+func (s *adminStore) GetDenyRulesForUser(ctx context.Context, userID int64) ([]DenyRule, error) {
+	groupIDs, err := s.getGroupIDsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+	roleIDs, err := s.getRoleIDsForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	principals := []Predicate{
+		And(Eq("principal_type", principalTypeUser), Eq("principal_id", userID)),
+	}
+	if len(groupIDs) > 0 {
+		principals = append(principals, And(
+			Eq("principal_type", principalTypeGroup),
+			NewPredicate("principal_id", OpIn, groupIDs),
+		))
+	}
+	if len(roleIDs) > 0 {
+		principals = append(principals, And(
+			Eq("principal_type", principalTypeRole),
+			NewPredicate("principal_id", OpIn, roleIDs),
+		))
+	}
+
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      global_deny_rules,
+		IncludeColumns: []string{"name", "principal_type", "principal_id", "table_pattern", "permission"},
+		Where:          []Predicate{Or(principals...)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []DenyRule
+	for _, rec := range res {
+		rule := DenyRule{
+			Name:         rec["name"].(string),
+			TablePattern: rec["table_pattern"].(string),
+			Permission:   rec["permission"].(string),
+		}
+		principalID := rec["principal_id"].(int64)
+		switch rec["principal_type"].(string) {
+		case principalTypeUser:
+			rule.UserID = principalID
+		case principalTypeGroup:
+			rule.GroupID = principalID
+		case principalTypeRole:
+			rule.RoleID = principalID
+		}
+		ret = append(ret, rule)
+	}
+	return ret, nil
+}
+
+func (s *adminStore) deleteDenyRulesForPrincipal(ctx context.Context, principalType string, principalID int64) error {
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: global_deny_rules,
+		Where: []Predicate{
+			Eq("principal_type", principalType),
+			Eq("principal_id", principalID),
+		},
+	})
+	return err
+}
+
+func (r DenyRule) principal() (string, int64) {
+	switch {
+	case r.UserID != 0:
+		return principalTypeUser, r.UserID
+	case r.GroupID != 0:
+		return principalTypeGroup, r.GroupID
+	}
+	return principalTypeRole, r.RoleID
+}
+
+func (s *adminStore) addNamed(ctx context.Context, tableName, name string) error {
+	if name == "" {
+		return fmt.Errorf("name is empty")
+	}
+
+	if _, err := s.getNamed(ctx, tableName, name); err == nil {
+		return nil
+	}
+
+	_, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: tableName,
+		Values: []FieldValue{
+			{
+				Name:  "name",
+				Value: name,
+			},
+		},
+	})
+	return err
+}
+
+func (s *adminStore) getNamed(ctx context.Context, tableName, name string) (int64, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      tableName,
+		IncludeColumns: []string{"id"},
+		Where:          []Predicate{Eq("name", name)},
+		Limit:          1,
+	})
+	if err != nil {
+		return 0, err
+	}
+	if len(res) == 0 {
+		return 0, fmt.Errorf("'%s' not found in %s", name, tableName)
+	}
+	return res[0]["id"].(int64), nil
+}
+
+func (s *adminStore) deleteByID(ctx context.Context, tableName string, id int64) error {
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeDelete,
+		TableName: tableName,
+		Where:     []Predicate{Eq("id", id)},
+	})
+	if err != nil {
+		return err
+	}
+	if res.RowsAffected == 0 {
+		return fmt.Errorf("id %d not found in %s", id, tableName)
+	}
+	return nil
+}
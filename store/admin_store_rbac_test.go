@@ -0,0 +1,167 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestAdminStoreRBAC(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"foo", "bar"} {
+		err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+			TableName: table,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := as.AddUser(context.TODO(), "analyst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// direct grant
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "foo",
+		Permission: store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.AddRole(context.TODO(), "writer"); err != nil {
+		t.Fatal(err)
+	}
+	role, err := as.GetRole(context.TODO(), "writer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, table := range []string{"foo", "bar"} {
+		err = as.AddRolePermission(context.TODO(), store.RolePermissionOptions{
+			RoleID:     role.ID,
+			TableName:  table,
+			Permission: store.WRITE_ALL_PERMISSION,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := as.AddGroup(context.TODO(), "analysts"); err != nil {
+		t.Fatal(err)
+	}
+	group, err := as.GetGroup(context.TODO(), "analysts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := as.AddGroupMember(context.TODO(), group.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+	insert := func() error {
+		_, err := ds.AsUser(context.TODO(), store.UserOptions{
+			Token: token,
+		}).Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "bar",
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		})
+		return err
+	}
+
+	// role is not bound yet
+	if err := insert(); err == nil {
+		t.Fatal("expected insert without a role binding to fail")
+	}
+
+	if err := as.BindRole(context.TODO(), store.RoleBinding{RoleID: role.ID, GroupID: group.ID}); err != nil {
+		t.Fatal(err)
+	}
+	// binding the role to the user as well does not duplicate the grants
+	if err := as.BindRole(context.TODO(), store.RoleBinding{RoleID: role.ID, UserID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	perms, err := as.GetPermissionsForToken(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]store.TablePermission{
+		{TableName: "foo", Permission: store.READ_ALL_PERMISSION},
+		{TableName: "foo", Permission: store.WRITE_ALL_PERMISSION},
+		{TableName: "bar", Permission: store.WRITE_ALL_PERMISSION},
+	}, perms); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if err := insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	// the grant stays as long as one of the bindings is left
+	if err := as.UnbindRole(context.TODO(), store.RoleBinding{RoleID: role.ID, UserID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.RemoveGroupMember(context.TODO(), group.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(); err == nil {
+		t.Fatal("expected insert after leaving the group to fail")
+	}
+
+	// a binding needs exactly one principal
+	if err := as.BindRole(context.TODO(), store.RoleBinding{RoleID: role.ID}); err == nil {
+		t.Fatal("expected binding without a principal to fail")
+	}
+	if err := as.BindRole(context.TODO(), store.RoleBinding{RoleID: role.ID, UserID: user.ID, GroupID: group.ID}); err == nil {
+		t.Fatal("expected binding with two principals to fail")
+	}
+
+	if err := as.AddGroupMember(context.TODO(), group.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.DeleteRole(context.TODO(), role.ID); err != nil {
+		t.Fatal(err)
+	}
+	perms, err = as.GetPermissionsForToken(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]store.TablePermission{
+		{TableName: "foo", Permission: store.READ_ALL_PERMISSION},
+	}, perms); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if err := as.DeleteGroup(context.TODO(), group.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetGroup(context.TODO(), "analysts"); err == nil {
+		t.Fatal("expected deleted group to be gone")
+	}
+}
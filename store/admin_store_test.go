@@ -2,6 +2,7 @@ package store_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 
 	"github.com/google/go-cmp/cmp"
@@ -39,18 +40,22 @@ func TestAdminStore(t *testing.T) {
 		t.Fatal(err)
 	}
 	t.Log("token::", token)
-	token, err = as.AddUser(context.TODO(), "test-user")
-	if err != nil {
-		t.Fatal(err)
+	// the token is only returned when the user is created
+	_, err = as.AddUser(context.TODO(), "test-user")
+	if err == nil {
+		t.Fatal("expected adding an existing user to fail")
 	}
-	t.Log("token::", token)
 
 	user, err := as.GetUser(context.TODO(), token)
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = as.AddPermission(context.TODO(), user.ID, "foo", store.READ_ALL_PERMISSION)
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "foo",
+		Permission: store.READ_ALL_PERMISSION,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -71,3 +76,191 @@ func TestAdminStore(t *testing.T) {
 
 	t.Log(tps)
 }
+
+func TestAdminStoreLifecycle(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"foo", "bar"} {
+		err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+			TableName: name,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := as.AddUser(context.TODO(), "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUserByName(context.TODO(), "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"foo", "bar"} {
+		for _, perm := range []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION} {
+			if err := as.AddPermission(context.TODO(), store.PermissionOptions{
+				UserID:     user.ID,
+				TableName:  table,
+				Permission: perm,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	// revoke a single grant
+	err = as.RemovePermission(context.TODO(), user.ID, "foo", store.WRITE_ALL_PERMISSION)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// drop a table, grants on it go away as well
+	err = as.DropTable(context.TODO(), "bar")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetTable(context.TODO(), "bar"); err == nil {
+		t.Fatal("expected dropped table to be gone")
+	}
+
+	tps, err := as.GetPermissionsForToken(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(tps, []store.TablePermission{
+		{
+			TableName:  "foo",
+			Permission: store.READ_ALL_PERMISSION,
+		},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// rename keeps the token working
+	_, err = as.AddUser(context.TODO(), "other-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := as.RenameUser(context.TODO(), user.ID, "other-user"); err == nil {
+		t.Fatal("expected rename to an existing name to fail")
+	}
+	if err := as.RenameUser(context.TODO(), user.ID, "renamed-user"); err != nil {
+		t.Fatal(err)
+	}
+	renamed, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if renamed.ID != user.ID || renamed.UserName != "renamed-user" {
+		t.Fatalf("unexpected user after rename: %+v", *renamed)
+	}
+
+	// disabled users cannot use their token
+	if err := as.SetUserDisabled(context.TODO(), user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetUser(context.TODO(), token); err == nil {
+		t.Fatal("expected disabled user to be rejected")
+	}
+	disabled, err := as.GetUserByName(context.TODO(), "renamed-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !disabled.Disabled {
+		t.Fatal("expected user to be disabled")
+	}
+	if err := as.SetUserDisabled(context.TODO(), user.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetUser(context.TODO(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	// delete user
+	if err := as.DeleteUser(context.TODO(), user.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := as.GetUser(context.TODO(), token); err == nil {
+		t.Fatal("expected deleted user to be gone")
+	}
+	if _, err := as.GetUserByName(context.TODO(), "renamed-user"); err == nil {
+		t.Fatal("expected deleted user to be gone")
+	}
+}
+
+func TestAdminStoreTokens(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := as.AddUser(context.TODO(), "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix, secret, ok := strings.Cut(token, ".")
+	if !ok || prefix == "" || secret == "" {
+		t.Fatalf("unexpected token format")
+	}
+
+	if _, err := as.GetUser(context.TODO(), token); err != nil {
+		t.Fatal(err)
+	}
+
+	// none of the rejected tokens are echoed back
+	invalid := []string{
+		"",
+		prefix,
+		prefix + ".",
+		prefix + "." + strings.Repeat("0", len(secret)),
+		"unknown." + secret,
+	}
+	for _, tok := range invalid {
+		_, err := as.GetUser(context.TODO(), tok)
+		if err == nil {
+			t.Fatalf("expected token to be rejected")
+		}
+		if (tok != "" && strings.Contains(err.Error(), tok)) || strings.Contains(err.Error(), secret) {
+			t.Fatalf("error leaks the token: %v", err)
+		}
+	}
+
+	// errors of the delegated store do not contain the token either
+	ds := store.NewDelegatedStore(as, usf)
+	_, err = ds.AsUser(context.TODO(), store.UserOptions{
+		Token: token,
+	}).Query(context.TODO(), store.QueryOptions{
+		TableName:      "foo",
+		IncludeColumns: []string{"id"},
+	})
+	if err == nil {
+		t.Fatal("expected query without permissions to fail")
+	}
+	if strings.Contains(err.Error(), prefix) || strings.Contains(err.Error(), secret) {
+		t.Fatalf("error leaks the token: %v", err)
+	}
+}
@@ -0,0 +1,200 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+type AggregateFunc string
+
+const (
+	AggCount         AggregateFunc = "count"
+	AggCountDistinct AggregateFunc = "count_distinct"
+	AggSum           AggregateFunc = "sum"
+	AggMin           AggregateFunc = "min"
+	AggMax           AggregateFunc = "max"
+	AggAvg           AggregateFunc = "avg"
+)
+
+// Aggregate is a result column computed over the rows of a group, or over
+// every row matching the query when there is no group by.
+type Aggregate struct {
+	Func AggregateFunc `json:"func"`
+	// the column aggregated, empty or '*' counts rows and is only valid
+	// for count
+	Column string `json:"column,omitempty"`
+	// name of the result column, defaults to '<func>_<column>' or 'count'
+	// when counting rows
+	As string `json:"as,omitempty"`
+}
+
+func (a Aggregate) countsRows() bool {
+	return a.Column == "" || a.Column == "*"
+}
+
+// Alias returns the name of the result column.
+func (a Aggregate) Alias() string {
+	switch {
+	case a.As != "":
+		return a.As
+	case a.countsRows():
+		return string(a.Func)
+	}
+	return string(a.Func) + "_" + strings.ReplaceAll(a.Column, ".", "_")
+}
+
+func (a Aggregate) Validate() error {
+	switch a.Func {
+	case AggCount:
+	case AggCountDistinct, AggSum, AggMin, AggMax, AggAvg:
+		if a.countsRows() {
+			return NewInvalidQueryOptions(fmt.Sprintf("aggregate '%s' needs a column", a.Func))
+		}
+	default:
+		return NewInvalidQueryOptions(fmt.Sprintf("unknown aggregate '%s'", a.Func))
+	}
+	if _, _, qualified := splitReference(a.Column); !a.countsRows() && !validIdentifier(a.Column) && !qualified {
+		return NewInvalidQueryOptions(fmt.Sprintf("invalid aggregate column '%s'", a.Column))
+	}
+	if !validIdentifier(a.Alias()) {
+		return NewInvalidQueryOptions(fmt.Sprintf("invalid aggregate name '%s'", a.Alias()))
+	}
+	return nil
+}
+
+// expr returns the sql expression of a validated aggregate.
+func (a Aggregate) expr() string {
+	switch {
+	case a.countsRows():
+		return "COUNT(*)"
+	case a.Func == AggCountDistinct:
+		return "COUNT(DISTINCT " + a.Column + ")"
+	}
+	return strings.ToUpper(string(a.Func)) + "(" + a.Column + ")"
+}
+
+// Aggregated reports whether the query returns groups rather than rows.
+func (o QueryOptions) Aggregated() bool {
+	return len(o.Aggregates) > 0 || len(o.GroupBy) > 0
+}
+
+// aggregate returns the aggregate named alias.
+func (o QueryOptions) aggregate(alias string) (Aggregate, bool) {
+	for _, agg := range o.Aggregates {
+		if agg.Alias() == alias {
+			return agg, true
+		}
+	}
+	return Aggregate{}, false
+}
+
+func (o QueryOptions) validateAggregates() error {
+	if !o.Aggregated() {
+		if len(o.Having) > 0 {
+			return NewInvalidQueryOptions("having needs aggregates or group by")
+		}
+		return nil
+	}
+
+	for _, col := range o.GroupBy {
+		if !o.validReference(col) {
+			return NewInvalidQueryOptions(fmt.Sprintf("invalid group by column '%s'", col))
+		}
+	}
+	aliases := make(map[string]bool, len(o.Aggregates))
+	for _, agg := range o.Aggregates {
+		if err := agg.Validate(); err != nil {
+			return err
+		}
+		if !agg.countsRows() && !o.validReference(agg.Column) {
+			return NewInvalidQueryOptions(fmt.Sprintf("invalid aggregate column '%s'", agg.Column))
+		}
+		alias := agg.Alias()
+		if aliases[alias] || slices.Contains(o.GroupBy, alias) {
+			return NewInvalidQueryOptions(fmt.Sprintf("result column '%s' is not unique", alias))
+		}
+		aliases[alias] = true
+	}
+
+	// any other column would take its value from an arbitrary row of the
+	// group
+	for _, col := range o.IncludeColumns {
+		if !slices.Contains(o.GroupBy, col) {
+			return NewInvalidQueryOptions(fmt.Sprintf("column '%s' has to be grouped by to be included", col))
+		}
+	}
+	for _, key := range o.OrderBy {
+		if !aliases[key.Column] && !slices.Contains(o.GroupBy, key.Column) {
+			return NewInvalidQueryOptions(fmt.Sprintf("sort column '%s' is neither grouped by nor an aggregate", key.Column))
+		}
+	}
+	for _, pred := range o.Having {
+		for _, field := range pred.Fields() {
+			if !aliases[field] && !slices.Contains(o.GroupBy, field) {
+				return NewInvalidQueryOptions(fmt.Sprintf("having field '%s' is neither grouped by nor an aggregate", field))
+			}
+		}
+	}
+	if o.Cursor != "" {
+		return NewInvalidQueryOptions("aggregated queries are paged with an offset")
+	}
+	return validatePredicates(unqualified(o.Having))
+}
+
+// resultColumns returns the select list of a validated query.
+func (o QueryOptions) resultColumns() []string {
+	columns := o.IncludeColumns
+	if o.Aggregated() && len(columns) == 0 {
+		columns = o.GroupBy
+	}
+	if len(o.Joins) == 0 && !o.Aggregated() {
+		return columns
+	}
+
+	ret := make([]string, 0, len(columns)+len(o.Aggregates))
+	for _, col := range columns {
+		if len(o.Joins) > 0 {
+			// results are keyed by the qualified name, the column name
+			// alone is ambiguous
+			col += ` AS "` + col + `"`
+		}
+		ret = append(ret, col)
+	}
+	columns = ret
+	for _, agg := range o.Aggregates {
+		columns = append(columns, agg.expr()+" AS "+agg.Alias())
+	}
+	return columns
+}
+
+// resolveAggregates replaces references to aggregates by their expression,
+// sqlite prefers a table column over a result column of the same name in
+// having.
+func (o QueryOptions) resolveAggregates(p Predicate) Predicate {
+	return p.mapFields(func(field string) string {
+		if agg, ok := o.aggregate(field); ok {
+			return agg.expr()
+		}
+		return field
+	})
+}
+
+// Columns returns the table columns the query reads apart from those in
+// its predicates, aggregates counting rows read none.
+func (o QueryOptions) Columns() []string {
+	columns := append([]string{}, o.IncludeColumns...)
+	columns = append(columns, o.GroupBy...)
+	for _, agg := range o.Aggregates {
+		if !agg.countsRows() {
+			columns = append(columns, agg.Column)
+		}
+	}
+	for _, col := range o.SortColumns() {
+		if _, ok := o.aggregate(col); !ok {
+			columns = append(columns, col)
+		}
+	}
+	return columns
+}
@@ -0,0 +1,215 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestSQLite3StoreAggregates(t *testing.T) {
+	s, err := store.NewSQLite3Store(":memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "orders",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"item", "text"},
+			{"customer", "text"},
+			{"amount", "integer"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, order := range []struct {
+		item     string
+		customer string
+		amount   int
+	}{
+		{"book", "alice", 10},
+		{"book", "bob", 20},
+		{"book", "alice", 30},
+		{"pen", "carol", 1},
+		{"pen", "carol", 3},
+		{"ink", "bob", 5},
+	} {
+		_, err := s.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "orders",
+			Values: []store.FieldValue{
+				{Name: "item", Value: order.item},
+				{Name: "customer", Value: order.customer},
+				{Name: "amount", Value: order.amount},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	results, err := s.Query(context.TODO(), store.QueryOptions{
+		TableName: "orders",
+		Aggregates: []store.Aggregate{
+			{Func: store.AggCount},
+			{Func: store.AggCountDistinct, Column: "customer"},
+			{Func: store.AggSum, Column: "amount"},
+			{Func: store.AggMin, Column: "amount"},
+			{Func: store.AggMax, Column: "amount"},
+			{Func: store.AggAvg, Column: "amount", As: "average"},
+		},
+		GroupBy: []string{"item"},
+		Having:  []store.Predicate{store.NewPredicate("count", store.OpGreaterThan, 1)},
+		OrderBy: []store.SortKey{{Column: "sum_amount", Desc: true}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{
+		{"item": "book", "count": int64(3), "count_distinct_customer": int64(2), "sum_amount": int64(60), "min_amount": int64(10), "max_amount": int64(30), "average": float64(20)},
+		{"item": "pen", "count": int64(2), "count_distinct_customer": int64(1), "sum_amount": int64(4), "min_amount": int64(1), "max_amount": int64(3), "average": float64(2)},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// without group by the whole table is one group
+	results, err = s.Query(context.TODO(), store.QueryOptions{
+		TableName:  "orders",
+		Aggregates: []store.Aggregate{{Func: store.AggSum, Column: "amount", As: "total"}},
+		Where:      []store.Predicate{store.Eq("customer", "bob")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{{"total": int64(25)}}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// an aggregate named like a column is still the aggregate in having
+	results, err = s.Query(context.TODO(), store.QueryOptions{
+		TableName:      "orders",
+		IncludeColumns: []string{"customer"},
+		Aggregates:     []store.Aggregate{{Func: store.AggCount, As: "amount"}},
+		GroupBy:        []string{"customer"},
+		Having:         []store.Predicate{store.NewPredicate("amount", store.OpGreaterEqual, 2)},
+		OrderBy:        []store.SortKey{{Column: "customer"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{
+		{"customer": "alice", "amount": int64(2)},
+		{"customer": "bob", "amount": int64(2)},
+		{"customer": "carol", "amount": int64(2)},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts store.QueryOptions
+	}{
+		{"unknown func", store.QueryOptions{Aggregates: []store.Aggregate{{Func: "median", Column: "amount"}}}},
+		{"sum of rows", store.QueryOptions{Aggregates: []store.Aggregate{{Func: store.AggSum}}}},
+		{"invalid column", store.QueryOptions{Aggregates: []store.Aggregate{{Func: store.AggSum, Column: "amount) FROM orders; --"}}}},
+		{"invalid name", store.QueryOptions{Aggregates: []store.Aggregate{{Func: store.AggCount, As: "n; drop table orders"}}}},
+		{"duplicate name", store.QueryOptions{GroupBy: []string{"item"}, Aggregates: []store.Aggregate{{Func: store.AggCount, As: "item"}}}},
+		{"column not grouped by", store.QueryOptions{IncludeColumns: []string{"customer"}, GroupBy: []string{"item"}}},
+		{"select all", store.QueryOptions{IncludeColumns: []string{"*"}, Aggregates: []store.Aggregate{{Func: store.AggCount}}}},
+		{"having on a column", store.QueryOptions{GroupBy: []string{"item"}, Having: []store.Predicate{store.Eq("customer", "bob")}}},
+		{"having without groups", store.QueryOptions{IncludeColumns: []string{"item"}, Having: []store.Predicate{store.Eq("item", "pen")}}},
+		{"sort by a column", store.QueryOptions{GroupBy: []string{"item"}, OrderBy: []store.SortKey{{Column: "amount"}}}},
+	} {
+		tc.opts.TableName = "orders"
+		if _, err := s.Query(context.TODO(), tc.opts); err == nil {
+			t.Fatalf("%s: expected query to be rejected", tc.name)
+		}
+	}
+}
+
+func TestDelegatedStoreAggregates(t *testing.T) {
+	as, usf := newTestAdminStore(t, store.CreateTableOptions{
+		TableName: "orders",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"item", "text"},
+			{"amount", "integer"},
+		},
+	})
+
+	ds := store.NewDelegatedStore(as, usf)
+	restricted := newTestUser(t, as, ds, "restricted-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.READ_RESTRICTED_PERMISSION},
+		store.PermissionOptions{TableName: "orders", Permission: store.WRITE_ALL_PERMISSION},
+	)
+	other := newTestUser(t, as, ds, "other-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.WRITE_ALL_PERMISSION},
+	)
+	narrow := newTestUser(t, as, ds, "narrow-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.READ_ALL_PERMISSION, Columns: []string{"id", "item"}},
+	)
+
+	insert := func(us store.UserStore, item string, amount int) {
+		t.Helper()
+		_, err := us.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "orders",
+			Values:    []store.FieldValue{{Name: "item", Value: item}, {Name: "amount", Value: amount}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	insert(restricted, "book", 10)
+	insert(restricted, "book", 20)
+	insert(restricted, "pen", 1)
+	insert(other, "book", 100)
+	insert(other, "ink", 5)
+
+	// the rows of other users are not counted
+	results, err := restricted.Query(context.TODO(), store.QueryOptions{
+		TableName:  "orders",
+		Aggregates: []store.Aggregate{{Func: store.AggCount}, {Func: store.AggSum, Column: "amount"}},
+		GroupBy:    []string{"item"},
+		OrderBy:    []store.SortKey{{Column: "item"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{
+		{"item": "book", "count": int64(2), "sum_amount": int64(30)},
+		{"item": "pen", "count": int64(1), "sum_amount": int64(1)},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// counting rows reads no column
+	results, err = narrow.Query(context.TODO(), store.QueryOptions{
+		TableName:  "orders",
+		Aggregates: []store.Aggregate{{Func: store.AggCount}, {Func: store.AggCountDistinct, Column: "item"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{{"count": int64(5), "count_distinct_item": int64(3)}}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, tc := range []struct {
+		name string
+		opts store.QueryOptions
+	}{
+		{"aggregate", store.QueryOptions{Aggregates: []store.Aggregate{{Func: store.AggMax, Column: "amount"}}}},
+		{"group by", store.QueryOptions{Aggregates: []store.Aggregate{{Func: store.AggCount}}, GroupBy: []string{"amount"}}},
+	} {
+		tc.opts.TableName = "orders"
+		if _, err := narrow.Query(context.TODO(), tc.opts); err == nil {
+			t.Fatalf("%s: expected access to amount to be denied", tc.name)
+		}
+	}
+}
@@ -0,0 +1,325 @@
+package store
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const audit_events = "audit_events"
+
+// audit decisions
+const (
+	AUDIT_ALLOW = "allow"
+	AUDIT_DENY  = "deny"
+)
+
+// AuditEvent records one authorization decision of the delegated store and,
+// for allowed requests, its outcome.
+type AuditEvent struct {
+	ID   int64     `json:"id"`
+	Time time.Time `json:"time"`
+	// chi request id, empty when the store is not used through the api
+	RequestID string `json:"requestId"`
+	UserID    int64  `json:"userId"`
+	UserName  string `json:"userName"`
+	KeyID     string `json:"keyId"`
+	TableName string `json:"tableName"`
+	// query, insert, update or delete
+	Operation string `json:"operation"`
+	// predicates the request was executed with, including those added for
+	// restricted permissions and row policies
+	Predicates []Predicate `json:"predicates"`
+	Columns    []string    `json:"columns"`
+	Decision   string      `json:"decision"`
+	// the grant which allowed the request or the deny rule which denied it
+	Rule string `json:"rule"`
+	// why the request was denied or failed after it was allowed
+	Error string `json:"error"`
+	// rows returned by a query or affected by an exec
+	Rows int64 `json:"rows"`
+	// the hash chain, see AuditStore.Verify
+	PrevHash string `json:"prevHash"`
+	Hash     string `json:"hash"`
+}
+
+func newAuditEvent(ctx context.Context, tableName, operation string, columns []string, where []Predicate) *AuditEvent {
+	return &AuditEvent{
+		Time:       time.Now(),
+		RequestID:  RequestIDFromContext(ctx),
+		TableName:  tableName,
+		Operation:  operation,
+		Predicates: where,
+		Columns:    columns,
+		Decision:   AUDIT_DENY,
+	}
+}
+
+// setUser records who made the request once the user is known.
+func (e *AuditEvent) setUser(user *User) {
+	e.UserID = user.ID
+	e.UserName = user.UserName
+	e.KeyID = user.KeyID
+}
+
+// allow marks the request as allowed to run with the predicates where.
+func (e *AuditEvent) allow(where []Predicate) {
+	e.Decision = AUDIT_ALLOW
+	e.Predicates = where
+}
+
+type AuditSearchOptions struct {
+	// all of them optional, empty matches everything
+	UserName  string
+	TableName string
+	// events within [From, To)
+	From time.Time
+	To   time.Time
+	// newest events returned, 0 for all of them
+	Limit int
+}
+
+// AuditStore keeps an append-only log of audit events, there is no way to
+// change or remove an event once recorded. Each event is chained to the one
+// before it by its hash so that changes made to the database behind the
+// back of the store can be detected.
+type AuditStore interface {
+	Record(ctx context.Context, event AuditEvent) error
+	// returns the matching events oldest first
+	Search(ctx context.Context, opts AuditSearchOptions) ([]AuditEvent, error)
+	// signs the hash of the newest event, fails when the store has no
+	// checkpoint key
+	Checkpoint(ctx context.Context) (*AuditCheckpoint, error)
+	// walks the chain and the checkpoints and reports the first broken link
+	Verify(ctx context.Context, opts AuditVerifyOptions) (*AuditVerification, error)
+}
+
+type auditStore struct {
+	store CompoundStore
+	// signs checkpoints, nil when checkpoints are disabled
+	checkpointKey ed25519.PrivateKey
+	// verifies checkpoints, nil when there are none to verify
+	verifyKey ed25519.PublicKey
+	// events between automatic checkpoints, 0 to only write them on request
+	checkpointEvery int64
+
+	// serializes appends to the chain
+	mu sync.Mutex
+	// id and hash of the newest event
+	headID   int64
+	headHash string
+}
+
+var _ AuditStore = (*auditStore)(nil)
+
+type AuditStoreOption func(*auditStore)
+
+// WithCheckpoints signs checkpoints with key and writes one after every
+// every events, or only when AuditStore.Checkpoint is called if every is 0.
+func WithCheckpoints(key ed25519.PrivateKey, every int64) AuditStoreOption {
+	return func(s *auditStore) {
+		s.checkpointKey = key
+		s.verifyKey = key.Public().(ed25519.PublicKey)
+		s.checkpointEvery = every
+	}
+}
+
+// WithCheckpointVerification verifies checkpoints written by a store with
+// WithCheckpoints(key, every) where public is the public half of key, so
+// that auditors do not need the signing key.
+func WithCheckpointVerification(public ed25519.PublicKey, every int64) AuditStoreOption {
+	return func(s *auditStore) {
+		s.verifyKey = public
+		s.checkpointEvery = every
+	}
+}
+
+// NewAuditStore returns an audit store kept in its own database, apart from
+// the admin store so that it can live on storage admins cannot write to.
+func NewAuditStore(ctx context.Context, dataSource string, opts ...AuditStoreOption) (*auditStore, error) {
+	store, err := NewSQLite3Store(dataSource, time.Now().UnixNano())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := store.CreateTable(ctx, CreateTableOptions{
+		TableName: audit_events,
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			// unix nanoseconds
+			{"time", "integer", "not null"},
+			{"request_id", "text", "not null"},
+			{"user_id", "integer", "not null"},
+			{"user_name", "text", "not null"},
+			{"key_id", "text", "not null"},
+			{"table_name", "text", "not null"},
+			{"operation", "text", "not null"},
+			// json
+			{"predicates", "text", "not null"},
+			{"columns", "text", "not null"},
+			{"decision", "text", "not null"},
+			{"rule", "text", "not null"},
+			{"error", "text", "not null"},
+			{"rows", "integer", "not null"},
+			// hex sha256, see auditHash
+			{"prev_hash", "text", "not null"},
+			{"hash", "text", "not null"},
+		},
+		IfNotExists: true,
+	}); err != nil {
+		return nil, err
+	}
+	fmt.Println("created: ", audit_events)
+
+	s := &auditStore{store: store}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	if err := s.initCheckpoints(ctx); err != nil {
+		return nil, err
+	}
+	if err := s.loadHead(ctx); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+func (s *auditStore) Record(ctx context.Context, event AuditEvent) error {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+	predicates, err := json.Marshal(event.Predicates)
+	if err != nil {
+		return err
+	}
+	columns, err := json.Marshal(event.Columns)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// ids are assigned here rather than by sqlite as they are covered by
+	// the hash, a gap in them means an event was removed
+	rec := map[string]interface{}{
+		"id":         s.headID + 1,
+		"time":       event.Time.UnixNano(),
+		"request_id": event.RequestID,
+		"user_id":    event.UserID,
+		"user_name":  event.UserName,
+		"key_id":     event.KeyID,
+		"table_name": event.TableName,
+		"operation":  event.Operation,
+		"predicates": string(predicates),
+		"columns":    string(columns),
+		"decision":   event.Decision,
+		"rule":       event.Rule,
+		"error":      event.Error,
+		"rows":       event.Rows,
+	}
+	hash, err := auditHash(s.headHash, rec)
+	if err != nil {
+		return err
+	}
+
+	var values []FieldValue
+	for _, col := range auditHashedColumns {
+		values = append(values, FieldValue{Name: col, Value: rec[col]})
+	}
+	values = append(values,
+		FieldValue{Name: "prev_hash", Value: s.headHash},
+		FieldValue{Name: "hash", Value: hash},
+	)
+	if _, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: audit_events,
+		Values:    values,
+	}); err != nil {
+		return err
+	}
+	s.headID, s.headHash = rec["id"].(int64), hash
+
+	if s.checkpointEvery > 0 && s.headID%s.checkpointEvery == 0 {
+		if _, err := s.checkpoint(ctx); err != nil {
+			return fmt.Errorf("writing audit checkpoint: %w", err)
+		}
+	}
+
+	return nil
+}
+
+func (s *auditStore) Search(ctx context.Context, opts AuditSearchOptions) ([]AuditEvent, error) {
+	var where []Predicate
+	if opts.UserName != "" {
+		where = append(where, Eq("user_name", opts.UserName))
+	}
+	if opts.TableName != "" {
+		where = append(where, Eq("table_name", opts.TableName))
+	}
+	if !opts.From.IsZero() {
+		where = append(where, NewPredicate("time", OpGreaterEqual, opts.From.UnixNano()))
+	}
+	if !opts.To.IsZero() {
+		where = append(where, NewPredicate("time", OpLessThan, opts.To.UnixNano()))
+	}
+
+	// the newest events are picked, then returned oldest first
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      audit_events,
+		IncludeColumns: auditEventColumns,
+		Where:          where,
+		OrderBy:        []SortKey{{Column: "id", Desc: true}},
+		Limit:          opts.Limit,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	events := make([]AuditEvent, len(res))
+	for i, rec := range res {
+		event, err := auditEventFromRecord(rec)
+		if err != nil {
+			return nil, err
+		}
+		events[len(res)-1-i] = *event
+	}
+
+	return events, nil
+}
+
+// auditHashedColumns are the columns covered by the hash of an event
+var auditHashedColumns = []string{"id", "time", "request_id", "user_id", "user_name", "key_id", "table_name", "operation", "predicates", "columns", "decision", "rule", "error", "rows"}
+
+var auditEventColumns = append(append([]string{}, auditHashedColumns...), "prev_hash", "hash")
+
+func auditEventFromRecord(rec map[string]interface{}) (*AuditEvent, error) {
+	event := &AuditEvent{
+		ID:        rec["id"].(int64),
+		Time:      time.Unix(0, rec["time"].(int64)),
+		RequestID: rec["request_id"].(string),
+		UserID:    rec["user_id"].(int64),
+		UserName:  rec["user_name"].(string),
+		KeyID:     rec["key_id"].(string),
+		TableName: rec["table_name"].(string),
+		Operation: rec["operation"].(string),
+		Decision:  rec["decision"].(string),
+		Rule:      rec["rule"].(string),
+		Error:     rec["error"].(string),
+		Rows:      rec["rows"].(int64),
+		PrevHash:  rec["prev_hash"].(string),
+		Hash:      rec["hash"].(string),
+	}
+	if err := json.Unmarshal([]byte(rec["predicates"].(string)), &event.Predicates); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(rec["columns"].(string)), &event.Columns); err != nil {
+		return nil, err
+	}
+	return event, nil
+}
@@ -0,0 +1,277 @@
+package store
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const audit_checkpoints = "audit_checkpoints"
+
+// AuditCheckpoint is a signed statement of the hash of an event, anyone
+// rewriting the chain up to that event would also need the checkpoint key.
+type AuditCheckpoint struct {
+	ID      int64     `json:"id"`
+	EventID int64     `json:"eventId"`
+	Hash    string    `json:"hash"`
+	Time    time.Time `json:"time"`
+	// base64 ed25519 signature of checkpointMessage
+	Signature string `json:"signature"`
+}
+
+// AuditVerifyOptions anchors a verification to an earlier one. Everything
+// Verify looks at lives in the database, so without an anchor removing the
+// newest events together with their checkpoints leaves a log which verifies.
+type AuditVerifyOptions struct {
+	// the newest checkpoint of an earlier verification, kept by the auditor
+	// outside the database, optional
+	Since *AuditCheckpoint `json:"since"`
+}
+
+type AuditVerification struct {
+	Events      int  `json:"events"`
+	Checkpoints int  `json:"checkpoints"`
+	OK          bool `json:"ok"`
+	// the first event which does not verify and why, when not OK
+	BrokenEventID int64  `json:"brokenEventId"`
+	Reason        string `json:"reason"`
+	// to pass as AuditVerifyOptions.Since next time, nil when not OK or
+	// there are no checkpoints
+	NewestCheckpoint *AuditCheckpoint `json:"newestCheckpoint"`
+}
+
+func (s *auditStore) initCheckpoints(ctx context.Context) error {
+	if err := s.store.CreateTable(ctx, CreateTableOptions{
+		TableName: audit_checkpoints,
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"event_id", "integer", "not null"},
+			{"hash", "text", "not null"},
+			// unix nanoseconds
+			{"time", "integer", "not null"},
+			{"signature", "text", "not null"},
+		},
+		IfNotExists: true,
+	}); err != nil {
+		return err
+	}
+	fmt.Println("created: ", audit_checkpoints)
+	return nil
+}
+
+// loadHead picks up the chain where an earlier instance of the store left
+// it.
+func (s *auditStore) loadHead(ctx context.Context) error {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      audit_events,
+		IncludeColumns: []string{"id", "hash"},
+		OrderBy:        []SortKey{{Column: "id", Desc: true}},
+		Limit:          1,
+	})
+	if err != nil {
+		return err
+	}
+	if len(res) > 0 {
+		s.headID, s.headHash = res[0]["id"].(int64), res[0]["hash"].(string)
+	}
+	return nil
+}
+
+// auditHash chains an event to the previous one, it covers the hash of the
+// previous event and every column of the event as stored.
+func auditHash(prevHash string, rec map[string]interface{}) (string, error) {
+	h := sha256.New()
+	h.Write([]byte(prevHash))
+	for _, col := range auditHashedColumns {
+		// json keeps strings and numbers apart and quotes separators
+		b, err := json.Marshal(rec[col])
+		if err != nil {
+			return "", err
+		}
+		h.Write([]byte("\n"))
+		h.Write(b)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+func checkpointMessage(eventID int64, hash string, t time.Time) []byte {
+	return []byte(fmt.Sprintf("audit checkpoint\n%d\n%s\n%d", eventID, hash, t.UnixNano()))
+}
+
+func (s *auditStore) Checkpoint(ctx context.Context) (*AuditCheckpoint, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.checkpoint(ctx)
+}
+
+// checkpoint signs the head of the chain, s.mu must be held.
+func (s *auditStore) checkpoint(ctx context.Context) (*AuditCheckpoint, error) {
+	if s.checkpointKey == nil {
+		return nil, fmt.Errorf("no audit checkpoint key configured")
+	}
+	if s.headID == 0 {
+		return nil, fmt.Errorf("audit log is empty")
+	}
+
+	now := time.Now()
+	sig := ed25519.Sign(s.checkpointKey, checkpointMessage(s.headID, s.headHash, now))
+	cp := &AuditCheckpoint{
+		EventID:   s.headID,
+		Hash:      s.headHash,
+		Time:      now,
+		Signature: base64.StdEncoding.EncodeToString(sig),
+	}
+
+	res, err := s.store.Exec(ctx, ExecOptions{
+		Type:      ExecTypeInsert,
+		TableName: audit_checkpoints,
+		Values: []FieldValue{
+			{Name: "event_id", Value: cp.EventID},
+			{Name: "hash", Value: cp.Hash},
+			{Name: "time", Value: now.UnixNano()},
+			{Name: "signature", Value: cp.Signature},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+	cp.ID = res.LastInsertId
+
+	return cp, nil
+}
+
+// validSignature reports whether signature is the checkpoint key's signature
+// of the checkpoint.
+func (s *auditStore) validSignature(eventID int64, hash string, t time.Time, signature string) bool {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	return err == nil && ed25519.Verify(s.verifyKey, checkpointMessage(eventID, hash, t), sig)
+}
+
+// Verify recomputes the hash of every event, oldest first, and checks that
+// ids have no gaps and that each event names the hash of the one before it.
+// Checkpoints then catch a chain which was rewritten consistently, as long
+// as the checkpoint key was not compromised. Since they live in the same
+// database the checkpoints have to be complete as well, otherwise removing
+// them along with a rewrite would go unnoticed. A chain cut short together
+// with its checkpoints is only caught against opts.Since, a checkpoint kept
+// outside the database.
+func (s *auditStore) Verify(ctx context.Context, opts AuditVerifyOptions) (*AuditVerification, error) {
+	res, err := s.store.Query(ctx, QueryOptions{
+		TableName:      audit_events,
+		IncludeColumns: auditEventColumns,
+		OrderBy:        []SortKey{{Column: "id"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &AuditVerification{Events: len(res)}
+	broken := func(eventID int64, format string, args ...interface{}) (*AuditVerification, error) {
+		ret.BrokenEventID = eventID
+		ret.Reason = fmt.Sprintf(format, args...)
+		return ret, nil
+	}
+
+	hashes := make(map[int64]string, len(res))
+	var prevHash string
+	for i, rec := range res {
+		id := rec["id"].(int64)
+		if want := int64(i + 1); id != want {
+			return broken(want, "event %d is missing", want)
+		}
+		if rec["prev_hash"].(string) != prevHash {
+			return broken(id, "event %d is not chained to event %d", id, id-1)
+		}
+		hash, err := auditHash(prevHash, rec)
+		if err != nil {
+			return nil, err
+		}
+		if hash != rec["hash"].(string) {
+			return broken(id, "event %d was modified", id)
+		}
+		hashes[id] = hash
+		prevHash = hash
+	}
+
+	checkpoints, err := s.store.Query(ctx, QueryOptions{
+		TableName:      audit_checkpoints,
+		IncludeColumns: []string{"id", "event_id", "hash", "time", "signature"},
+		OrderBy:        []SortKey{{Column: "id"}},
+	})
+	if err != nil {
+		return nil, err
+	}
+	ret.Checkpoints = len(checkpoints)
+	if (len(checkpoints) > 0 || opts.Since != nil) && s.verifyKey == nil {
+		return nil, fmt.Errorf("no audit checkpoint key configured to verify checkpoints with")
+	}
+
+	checkpointed := make(map[int64]bool, len(checkpoints))
+	var newest *AuditCheckpoint
+	for i, rec := range checkpoints {
+		cp := &AuditCheckpoint{
+			ID:        rec["id"].(int64),
+			EventID:   rec["event_id"].(int64),
+			Hash:      rec["hash"].(string),
+			Time:      time.Unix(0, rec["time"].(int64)),
+			Signature: rec["signature"].(string),
+		}
+		if want := int64(i + 1); cp.ID != want {
+			return broken(cp.EventID, "checkpoint %d is missing", want)
+		}
+		if !s.validSignature(cp.EventID, cp.Hash, cp.Time, cp.Signature) {
+			return broken(cp.EventID, "checkpoint %d has an invalid signature", cp.ID)
+		}
+		got, ok := hashes[cp.EventID]
+		if !ok {
+			return broken(cp.EventID, "event %d of checkpoint %d is missing", cp.EventID, cp.ID)
+		}
+		if got != cp.Hash {
+			return broken(cp.EventID, "events up to %d were rewritten after checkpoint %d", cp.EventID, cp.ID)
+		}
+		checkpointed[cp.EventID] = true
+		newest = cp
+	}
+
+	// the kept checkpoint and everything before it has to still be there
+	if since := opts.Since; since != nil {
+		if !s.validSignature(since.EventID, since.Hash, since.Time, since.Signature) {
+			return nil, fmt.Errorf("checkpoint to verify since has an invalid signature")
+		}
+		got, ok := hashes[since.EventID]
+		if !ok {
+			return broken(since.EventID, "event %d of the kept checkpoint is missing, the log was truncated", since.EventID)
+		}
+		if got != since.Hash {
+			return broken(since.EventID, "events up to %d were rewritten after the kept checkpoint", since.EventID)
+		}
+		if int64(len(checkpoints)) < since.ID {
+			return broken(since.EventID, "checkpoint %d is missing", since.ID)
+		}
+	}
+
+	// every multiple of the interval since checkpoints were turned on has
+	// its checkpoint, which also keeps the newest one within an interval of
+	// the head
+	if every := s.checkpointEvery; every > 0 {
+		head := int64(len(res))
+		from := every
+		if len(checkpoints) > 0 {
+			from = checkpoints[0]["event_id"].(int64)
+		}
+		for m := (from + every - 1) / every * every; m <= head; m += every {
+			if !checkpointed[m] {
+				return broken(m, "checkpoint of event %d is missing", m)
+			}
+		}
+	}
+
+	ret.OK = true
+	ret.NewestCheckpoint = newest
+	return ret, nil
+}
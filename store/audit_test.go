@@ -0,0 +1,395 @@
+package store_test
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"database/sql"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestAuditLog(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	audit, err := store.NewAuditStore(context.TODO(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"foo", "bar"} {
+		err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+			TableName: table,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := as.AddUser(context.TODO(), "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, perm := range []string{store.READ_RESTRICTED_PERMISSION, store.WRITE_ALL_PERMISSION} {
+		err = as.AddPermission(context.TODO(), store.PermissionOptions{
+			UserID:     user.ID,
+			TableName:  "foo",
+			Permission: perm,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "b*",
+		Permission: store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = as.AddDenyRule(context.TODO(), store.DenyRule{
+		Name:         "no-bar",
+		UserID:       user.ID,
+		TablePattern: "bar",
+		Permission:   store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	ds := store.NewDelegatedStore(as, usf, store.WithAuditStore(audit))
+	ctx := store.ContextWithRequestID(context.TODO(), "req-1")
+	us := ds.AsUser(ctx, store.UserOptions{Token: token})
+
+	for i := 0; i < 2; i++ {
+		_, err = us.Exec(ctx, store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := us.Query(ctx, store.QueryOptions{
+		TableName:      "foo",
+		IncludeColumns: []string{"id", "name"},
+		Where:          []store.Predicate{store.Eq("name", "test")},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if _, err := us.Exec(ctx, store.ExecOptions{
+		Type:      store.ExecTypeDelete,
+		TableName: "foo",
+		Where:     []store.Predicate{store.Eq("id", 1)},
+	}); err == nil {
+		t.Fatal("expected delete to be denied")
+	}
+	if _, err := us.Query(ctx, store.QueryOptions{
+		TableName:      "bar",
+		IncludeColumns: []string{"id"},
+	}); err == nil {
+		t.Fatal("expected query to be denied")
+	}
+
+	events, err := audit.Search(context.TODO(), store.AuditSearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 5 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	for _, event := range events {
+		if event.UserName != "test-user" || event.KeyID != user.KeyID || event.RequestID != "req-1" {
+			t.Fatalf("unexpected event %+v", event)
+		}
+	}
+
+	insert := events[0]
+	if insert.Operation != store.ExecTypeInsert || insert.Decision != store.AUDIT_ALLOW ||
+		insert.Rule != "grant WRITE_ALL on 'foo'" || insert.Rows != 1 ||
+		len(insert.Columns) != 1 || insert.Columns[0] != "name" {
+		t.Fatalf("unexpected insert event %+v", insert)
+	}
+
+	// the recorded predicates include the one added for READ_RESTRICTED
+	query := events[2]
+	if query.Operation != "query" || query.Decision != store.AUDIT_ALLOW ||
+		query.Rule != "grant READ_RESTRICTED on 'foo'" || query.Rows != 2 || len(query.Predicates) != 2 {
+		t.Fatalf("unexpected query event %+v", query)
+	}
+
+	del := events[3]
+	if del.Operation != store.ExecTypeDelete || del.Decision != store.AUDIT_DENY || del.Rule != "" || del.Error == "" {
+		t.Fatalf("unexpected delete event %+v", del)
+	}
+
+	denied := events[4]
+	if denied.TableName != "bar" || denied.Decision != store.AUDIT_DENY || denied.Rule != "deny rule 'no-bar'" {
+		t.Fatalf("unexpected denied event %+v", denied)
+	}
+
+	for _, tc := range []struct {
+		opts  store.AuditSearchOptions
+		count int
+	}{
+		{store.AuditSearchOptions{TableName: "foo"}, 4},
+		{store.AuditSearchOptions{TableName: "bar"}, 1},
+		{store.AuditSearchOptions{UserName: "other-user"}, 0},
+		{store.AuditSearchOptions{From: start, To: time.Now()}, 5},
+		{store.AuditSearchOptions{To: start}, 0},
+		{store.AuditSearchOptions{Limit: 2}, 2},
+	} {
+		events, err := audit.Search(context.TODO(), tc.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(events) != tc.count {
+			t.Fatalf("expected %d events for %+v, got %+v", tc.count, tc.opts, events)
+		}
+	}
+
+	// limit keeps the newest events
+	events, err = audit.Search(context.TODO(), store.AuditSearchOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[0].TableName != "bar" {
+		t.Fatalf("unexpected event %+v", events[0])
+	}
+
+	// requests failing before the user or the table is known are audited too
+	key, keyToken, err := as.AddAPIKey(context.TODO(), store.APIKeyOptions{UserID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := as.RevokeAPIKey(context.TODO(), key.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ds.AsUser(ctx, store.UserOptions{Token: keyToken}).Exec(ctx, store.ExecOptions{
+		Type:      store.ExecTypeInsert,
+		TableName: "foo",
+		Values:    []store.FieldValue{{Name: "name", Value: "revoked"}},
+	}); err == nil {
+		t.Fatal("expected revoked key to be rejected")
+	}
+	if _, err := us.Query(ctx, store.QueryOptions{
+		TableName:      "missing",
+		IncludeColumns: []string{"id"},
+	}); err == nil {
+		t.Fatal("expected query of missing table to fail")
+	}
+	events, err = audit.Search(context.TODO(), store.AuditSearchOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events) != 2 {
+		t.Fatalf("unexpected events %+v", events)
+	}
+	revoked, missing := events[0], events[1]
+	if revoked.KeyID != key.ID || revoked.UserName != "" || revoked.TableName != "foo" ||
+		revoked.Decision != store.AUDIT_DENY || revoked.Error == "" {
+		t.Fatalf("unexpected revoked key event %+v", revoked)
+	}
+	if missing.UserName != "test-user" || missing.TableName != "missing" ||
+		missing.Decision != store.AUDIT_DENY || missing.Error == "" {
+		t.Fatalf("unexpected missing table event %+v", missing)
+	}
+}
+
+func TestAuditChain(t *testing.T) {
+	public, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// newLog returns the path of an audit log with n events and a
+	// checkpoint after every second one
+	newLog := func(n int) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "audit.db")
+		audit, err := store.NewAuditStore(context.TODO(), path, store.WithCheckpoints(key, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < n; i++ {
+			err := audit.Record(context.TODO(), store.AuditEvent{
+				UserName:  "test-user",
+				TableName: "foo",
+				Operation: "query",
+				Decision:  store.AUDIT_ALLOW,
+				Rows:      int64(i),
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+		}
+		return path
+	}
+	verify := func(path string) *store.AuditVerification {
+		t.Helper()
+		audit, err := store.NewAuditStore(context.TODO(), path, store.WithCheckpoints(key, 2))
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := audit.Verify(context.TODO(), store.AuditVerifyOptions{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+	tamper := func(path, query string) {
+		t.Helper()
+		db, err := sql.Open("sqlite3", path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		if _, err := db.Exec(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	path := newLog(5)
+	if v := verify(path); !v.OK || v.Events != 5 || v.Checkpoints != 2 {
+		t.Fatalf("unexpected verification %+v", v)
+	}
+
+	// a reopened store continues the chain
+	audit, err := store.NewAuditStore(context.TODO(), path, store.WithCheckpoints(key, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := audit.Record(context.TODO(), store.AuditEvent{UserName: "test-user", Decision: store.AUDIT_DENY}); err != nil {
+		t.Fatal(err)
+	}
+	cp, err := audit.Checkpoint(context.TODO())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cp.EventID != 6 {
+		t.Fatalf("unexpected checkpoint %+v", cp)
+	}
+	events, err := audit.Search(context.TODO(), store.AuditSearchOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if events[5].PrevHash != events[4].Hash || cp.Hash != events[5].Hash {
+		t.Fatalf("unexpected chain %+v", events)
+	}
+	if v := verify(path); !v.OK || v.Events != 6 || v.Checkpoints != 4 {
+		t.Fatalf("unexpected verification %+v", v)
+	}
+
+	// auditors verify with the public key
+	auditor, err := store.NewAuditStore(context.TODO(), path, store.WithCheckpointVerification(public, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := auditor.Verify(context.TODO(), store.AuditVerifyOptions{}); err != nil || !v.OK {
+		t.Fatalf("unexpected verification %+v, %v", v, err)
+	}
+	if _, err := auditor.Checkpoint(context.TODO()); err == nil {
+		t.Fatal("expected checkpoint without the signing key to fail")
+	}
+
+	for _, tc := range []struct {
+		name    string
+		query   string
+		eventID int64
+		reason  string
+	}{
+		{"modified", "update audit_events set decision = 'deny' where id = 3", 3, "modified"},
+		{"unchained", "update audit_events set prev_hash = 'x' where id = 3", 3, "not chained"},
+		{"removed", "delete from audit_events where id = 2", 2, "missing"},
+		{"truncated", "delete from audit_events where id >= 4", 4, "checkpoint"},
+		{"forged checkpoint", "update audit_checkpoints set hash = 'x' where id = 1", 2, "signature"},
+		{"checkpoints removed", "delete from audit_checkpoints", 2, "checkpoint of event 2 is missing"},
+		{"first checkpoint removed", "delete from audit_checkpoints where id = 1", 4, "checkpoint 1 is missing"},
+		{"newest checkpoint removed", "delete from audit_checkpoints where id = 2", 4, "checkpoint of event 4 is missing"},
+	} {
+		path := newLog(5)
+		tamper(path, tc.query)
+		v := verify(path)
+		if v.OK || v.BrokenEventID != tc.eventID || !strings.Contains(v.Reason, tc.reason) {
+			t.Fatalf("%s: unexpected verification %+v", tc.name, v)
+		}
+	}
+
+	// cutting off the newest events together with their checkpoints needs
+	// no key and leaves a log which verifies on its own, only the newest
+	// checkpoint of an earlier verification kept by the auditor catches it
+	path = newLog(7)
+	kept := verify(path).NewestCheckpoint
+	if kept == nil || kept.EventID != 6 {
+		t.Fatalf("unexpected newest checkpoint %+v", kept)
+	}
+	tamper(path, "delete from audit_events where id > 2")
+	tamper(path, "delete from audit_checkpoints where event_id > 2")
+	if v := verify(path); !v.OK || v.Events != 2 || v.Checkpoints != 1 {
+		t.Fatalf("unexpected verification %+v", v)
+	}
+	auditor, err = store.NewAuditStore(context.TODO(), path, store.WithCheckpointVerification(public, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	v, err := auditor.Verify(context.TODO(), store.AuditVerifyOptions{Since: kept})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.OK || v.BrokenEventID != 6 || !strings.Contains(v.Reason, "truncated") {
+		t.Fatalf("unexpected verification %+v", v)
+	}
+	// events after the kept checkpoint are not covered by it
+	path = newLog(7)
+	kept = verify(path).NewestCheckpoint
+	tamper(path, "delete from audit_events where id = 7")
+	auditor, err = store.NewAuditStore(context.TODO(), path, store.WithCheckpointVerification(public, 2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := auditor.Verify(context.TODO(), store.AuditVerifyOptions{Since: kept}); err != nil || !v.OK || v.Events != 6 {
+		t.Fatalf("unexpected verification %+v, %v", v, err)
+	}
+	// and a kept checkpoint has to be signed with the checkpoint key
+	forged := *kept
+	forged.EventID = 1
+	if _, err := auditor.Verify(context.TODO(), store.AuditVerifyOptions{Since: &forged}); err == nil {
+		t.Fatal("expected forged checkpoint to be rejected")
+	}
+
+	// checkpoints need a key
+	noKey, err := store.NewAuditStore(context.TODO(), ":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := noKey.Record(context.TODO(), store.AuditEvent{UserName: "test-user"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := noKey.Checkpoint(context.TODO()); err == nil {
+		t.Fatal("expected checkpoint without a key to fail")
+	}
+}
@@ -0,0 +1,49 @@
+package store
+
+import (
+	"context"
+	"errors"
+)
+
+// Authenticator resolves the user behind a token presented by a client.
+// Implementations return ErrUnsupportedToken for tokens they do not handle
+// so that they can be chained with NewChainAuthenticator.
+type Authenticator interface {
+	Authenticate(ctx context.Context, token string) (*User, error)
+}
+
+type apiKeyAuthenticator struct {
+	as AdminStore
+}
+
+// NewAPIKeyAuthenticator returns an authenticator for the api keys kept by
+// the admin store.
+func NewAPIKeyAuthenticator(as AdminStore) *apiKeyAuthenticator {
+	return &apiKeyAuthenticator{as: as}
+}
+
+func (a *apiKeyAuthenticator) Authenticate(ctx context.Context, token string) (*User, error) {
+	if _, _, err := splitToken(token); err != nil {
+		return nil, ErrUnsupportedToken
+	}
+	return a.as.GetUser(ctx, token)
+}
+
+type chainAuthenticator []Authenticator
+
+// NewChainAuthenticator returns an authenticator which asks each of
+// authenticators in turn, the first one supporting the token decides.
+func NewChainAuthenticator(authenticators ...Authenticator) chainAuthenticator {
+	return chainAuthenticator(authenticators)
+}
+
+func (c chainAuthenticator) Authenticate(ctx context.Context, token string) (*User, error) {
+	for _, a := range c {
+		user, err := a.Authenticate(ctx, token)
+		if errors.Is(err, ErrUnsupportedToken) {
+			continue
+		}
+		return user, err
+	}
+	return nil, ErrUnsupportedToken
+}
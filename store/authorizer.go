@@ -0,0 +1,217 @@
+package store
+
+import (
+	"fmt"
+	"path"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+// matchTable reports whether a grant or deny rule on pattern covers the
+// table. Patterns follow path.Match, a namespace is covered by a prefix
+// pattern such as 'analytics_*'.
+func matchTable(pattern, tableName string) bool {
+	if pattern == tableName {
+		return true
+	}
+	ok, _ := path.Match(pattern, tableName)
+	return ok
+}
+
+func validateTablePattern(pattern string) error {
+	if pattern == "" {
+		return fmt.Errorf("table name is empty")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid table pattern '%s': %w", pattern, err)
+	}
+	return nil
+}
+
+// authorizer decides what a user may do on a single table. It is built from
+// every grant and deny rule of the user, grants on patterns are resolved
+// against the table, grants outside of their window and grants matched by a
+// deny rule are dropped, and what is left is intersected with the scope of
+// the api key in use.
+type authorizer struct {
+	user      *User
+	tableName string
+	// grants covering the table which are not denied
+	perms []TablePermission
+	// the rule which denied a permission, used for reporting
+	denied map[string]DenyRule
+	// permissions dropped because the key is not scoped for them
+	outOfScope map[string]bool
+}
+
+// restrictedPermissions maps the ALL permissions to their restricted
+// counterpart.
+var restrictedPermissions = map[string]string{
+	READ_ALL_PERMISSION:   READ_RESTRICTED_PERMISSION,
+	WRITE_ALL_PERMISSION:  WRITE_RESTRICTED_PERMISSION,
+	DELETE_ALL_PERMISSION: DELETE_RESTRICTED_PERMISSION,
+}
+
+func newAuthorizer(user *User, tableName string, perms []TablePermission, rules []DenyRule, now time.Time) *authorizer {
+	a := &authorizer{
+		user:       user,
+		tableName:  tableName,
+		denied:     map[string]DenyRule{},
+		outOfScope: map[string]bool{},
+	}
+
+	for _, perm := range perms {
+		if !matchTable(perm.TableName, tableName) || !perm.ActiveAt(now) {
+			continue
+		}
+		// deny wins
+		idx := slices.IndexFunc(rules, func(r DenyRule) bool {
+			return r.Matches(tableName, perm.Permission)
+		})
+		if idx >= 0 {
+			a.denied[perm.Permission] = rules[idx]
+			continue
+		}
+		scoped, ok := scopePermission(user.Scope, tableName, perm)
+		if !ok {
+			a.outOfScope[perm.Permission] = true
+			continue
+		}
+		a.perms = append(a.perms, scoped)
+	}
+
+	return a
+}
+
+// scopePermission intersects a grant with the scope of a key. The grant is
+// kept if the scope allows its permission, narrowed to the restricted
+// permission if the scope only allows that one, and dropped otherwise.
+func scopePermission(scope []KeyScope, tableName string, perm TablePermission) (TablePermission, bool) {
+	if len(scope) == 0 {
+		return perm, true
+	}
+
+	allowed := func(permission string) bool {
+		return slices.ContainsFunc(scope, func(s KeyScope) bool {
+			return s.Matches(tableName, permission)
+		})
+	}
+
+	if allowed(perm.Permission) {
+		return perm, true
+	}
+	if restricted, ok := restrictedPermissions[perm.Permission]; ok && allowed(restricted) {
+		perm.Permission = restricted
+		return perm, true
+	}
+	return perm, false
+}
+
+func (a *authorizer) has(permission string) bool {
+	return slices.ContainsFunc(a.perms, func(p TablePermission) bool {
+		return p.Permission == permission
+	})
+}
+
+// authorize returns the permission out of allPerm and restrictedPerm which
+// decides the access of the user, allPerm wins if the user has both.
+func (a *authorizer) authorize(action string, allPerm, restrictedPerm string) (string, error) {
+	if len(a.perms) == 0 {
+		if err := a.denyError(defaultPermissions...); err != nil {
+			return "", err
+		}
+		if len(a.outOfScope) > 0 {
+			return "", fmt.Errorf("api key '%s' is not scoped for table '%s'", a.user.KeyID, a.tableName)
+		}
+		return "", fmt.Errorf("user '%s' not allowed to access table '%s'", a.user.UserName, a.tableName)
+	}
+
+	switch {
+	case a.has(allPerm):
+		return allPerm, nil
+	case a.has(restrictedPerm):
+		return restrictedPerm, nil
+	}
+
+	if err := a.denyError(allPerm, restrictedPerm); err != nil {
+		return "", err
+	}
+	if a.outOfScope[allPerm] || a.outOfScope[restrictedPerm] {
+		return "", fmt.Errorf("api key '%s' is not scoped for %s action on table '%s'", a.user.KeyID, action, a.tableName)
+	}
+	return "", fmt.Errorf("user '%s' cannot perform %s action on table '%s'", a.user.UserName, action, a.tableName)
+}
+
+// denyError returns an error naming the deny rule which dropped the grant of
+// one of the permissions, nil if none of them were denied.
+func (a *authorizer) denyError(permissions ...string) error {
+	for _, perm := range permissions {
+		if rule, ok := a.denied[perm]; ok {
+			return fmt.Errorf("%s on table '%s' denied by rule '%s' (%s on '%s')", perm, a.tableName, rule.Name, rule.Permission, rule.TablePattern)
+		}
+	}
+	return nil
+}
+
+// matchedRule describes what decided a request needing one of permissions,
+// the grant of the first of them the user has or else the deny rule which
+// dropped it, for the audit log.
+func (a *authorizer) matchedRule(permissions ...string) string {
+	for _, permission := range permissions {
+		for _, perm := range a.perms {
+			if perm.Permission == permission {
+				return fmt.Sprintf("grant %s on '%s'", perm.Permission, perm.TableName)
+			}
+		}
+	}
+	for _, permission := range permissions {
+		if rule, ok := a.denied[permission]; ok {
+			return fmt.Sprintf("deny rule '%s'", rule.Name)
+		}
+	}
+	return ""
+}
+
+// allowedColumns returns the columns covered by the grants of permission,
+// nil means every column is covered.
+func (a *authorizer) allowedColumns(permission string) []string {
+	var columns []string
+	for _, perm := range a.perms {
+		if perm.Permission != permission {
+			continue
+		}
+		if len(perm.Columns) == 0 {
+			return nil
+		}
+		columns = append(columns, perm.Columns...)
+	}
+	return columns
+}
+
+// checkColumns ensures that every column referenced directly or through a
+// predicate is covered by the grants of permission. When access is limited
+// to a set of columns selecting all columns with '*' is not allowed.
+func (a *authorizer) checkColumns(permission string, columns []string, where []Predicate) error {
+	allowed := a.allowedColumns(permission)
+	if allowed == nil {
+		return nil
+	}
+
+	referenced := append([]string{}, columns...)
+	for _, pred := range where {
+		referenced = append(referenced, pred.Fields()...)
+	}
+
+	for _, col := range referenced {
+		if col == "*" {
+			return fmt.Errorf("selecting all columns not allowed, access is limited to columns '%s'", strings.Join(allowed, ","))
+		}
+		if !slices.Contains(allowed, col) {
+			return fmt.Errorf("access to column '%s' not allowed", col)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,234 @@
+package store_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestWildcardGrants(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	createTable := func(table string) {
+		t.Helper()
+		err := as.CreateTable(context.TODO(), store.CreateTableOptions{
+			TableName: table,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+				{"secret", "text"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	createTable("analytics_events")
+	createTable("sales")
+
+	token, err := as.AddUser(context.TODO(), "analyst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	grants := []store.PermissionOptions{
+		{UserID: user.ID, TableName: "analytics_*", Permission: store.READ_ALL_PERMISSION, Columns: []string{"id", "name"}},
+		{UserID: user.ID, TableName: "analytics_*", Permission: store.WRITE_ALL_PERMISSION},
+		{UserID: user.ID, TableName: "*", Permission: store.READ_RESTRICTED_PERMISSION},
+	}
+	for _, grant := range grants {
+		if err := as.AddPermission(context.TODO(), grant); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// tables created after the grant are covered as well
+	createTable("analytics_sessions")
+
+	ds := store.NewDelegatedStore(as, usf)
+	us := ds.AsUser(context.TODO(), store.UserOptions{
+		Token: token,
+	})
+	insert := func(table string) error {
+		_, err := us.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: table,
+			Values:    []store.FieldValue{{Name: "name", Value: "test"}},
+		})
+		return err
+	}
+	query := func(table string, columns ...string) (store.QueryResult, error) {
+		return us.Query(context.TODO(), store.QueryOptions{
+			TableName:      table,
+			IncludeColumns: columns,
+		})
+	}
+
+	for _, table := range []string{"analytics_events", "analytics_sessions"} {
+		if err := insert(table); err != nil {
+			t.Fatal(err)
+		}
+		results, err := query(table, "id", "name")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(results) != 1 {
+			t.Fatalf("expected 1 row in %s, got %v", table, results)
+		}
+		// column limits of the pattern grant apply
+		if _, err := query(table, "secret"); err == nil {
+			t.Fatalf("expected query of secret column in %s to fail", table)
+		}
+	}
+
+	if err := insert("sales"); err == nil {
+		t.Fatal("expected insert on table not covered by a write grant to fail")
+	}
+	// the catch all READ_RESTRICTED grant covers sales
+	results, err := query("sales", "id", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 0 {
+		t.Fatalf("expected no rows, got %v", results)
+	}
+
+	// deny rules apply to pattern grants
+	err = as.AddDenyRule(context.TODO(), store.DenyRule{
+		Name:         "no-sessions",
+		UserID:       user.ID,
+		TablePattern: "analytics_sessions",
+		Permission:   store.AllPermissions,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := query("analytics_sessions", "id"); err == nil || !strings.Contains(err.Error(), "no-sessions") {
+		t.Fatalf("expected query to be denied by rule, got %v", err)
+	}
+	if _, err := query("analytics_events", "id"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "analytics_[",
+		Permission: store.READ_ALL_PERMISSION,
+	})
+	if err == nil {
+		t.Fatal("expected invalid pattern to be rejected")
+	}
+}
+
+func TestTimeBoundedGrants(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, table := range []string{"current", "future", "past"} {
+		err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+			TableName: table,
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := as.AddUser(context.TODO(), "contractor")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now()
+	grants := []store.PermissionOptions{
+		{UserID: user.ID, TableName: "current", NotBefore: now.Add(-time.Hour), NotAfter: now.Add(time.Hour)},
+		{UserID: user.ID, TableName: "future", NotBefore: now.Add(time.Hour)},
+		{UserID: user.ID, TableName: "past", NotAfter: now.Add(-time.Hour)},
+	}
+	for _, grant := range grants {
+		grant.Permission = store.READ_ALL_PERMISSION
+		if err := as.AddPermission(context.TODO(), grant); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+	us := ds.AsUser(context.TODO(), store.UserOptions{
+		Token: token,
+	})
+	query := func(table string) error {
+		_, err := us.Query(context.TODO(), store.QueryOptions{
+			TableName:      table,
+			IncludeColumns: []string{"id", "name"},
+		})
+		return err
+	}
+
+	if err := query("current"); err != nil {
+		t.Fatal(err)
+	}
+	if err := query("future"); err == nil {
+		t.Fatal("expected grant which has not started to be ignored")
+	}
+	if err := query("past"); err == nil {
+		t.Fatal("expected expired grant to be ignored")
+	}
+
+	// only the grant ending within the next two hours is expiring
+	perms, err := as.GetExpiringPermissions(context.TODO(), now.Add(2*time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(perms) != 1 || perms[0].TableName != "current" || perms[0].UserName != "contractor" {
+		t.Fatalf("unexpected expiring permissions %+v", perms)
+	}
+
+	// extending the grant replaces its window
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "past",
+		Permission: store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := query("past"); err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "current",
+		Permission: store.READ_ALL_PERMISSION,
+		NotBefore:  now,
+		NotAfter:   now.Add(-time.Minute),
+	})
+	if err == nil {
+		t.Fatal("expected window ending before it starts to be rejected")
+	}
+}
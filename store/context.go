@@ -0,0 +1,50 @@
+package store
+
+import (
+	"context"
+)
+
+type contextKey int
+
+const (
+	userContextKey contextKey = iota
+	adminContextKey
+	requestIDContextKey
+)
+
+// ContextWithUser returns a copy of ctx carrying a user that was already
+// authenticated, so that it does not have to be resolved from its token
+// again.
+func ContextWithUser(ctx context.Context, user *User) context.Context {
+	return context.WithValue(ctx, userContextKey, user)
+}
+
+// UserFromContext returns the user put on ctx by ContextWithUser.
+func UserFromContext(ctx context.Context) (*User, bool) {
+	user, ok := ctx.Value(userContextKey).(*User)
+	return user, ok && user != nil
+}
+
+// ContextWithAdmin returns a copy of ctx carrying an authenticated admin.
+func ContextWithAdmin(ctx context.Context, admin *Admin) context.Context {
+	return context.WithValue(ctx, adminContextKey, admin)
+}
+
+// AdminFromContext returns the admin put on ctx by ContextWithAdmin.
+func AdminFromContext(ctx context.Context) (*Admin, bool) {
+	admin, ok := ctx.Value(adminContextKey).(*Admin)
+	return admin, ok && admin != nil
+}
+
+// ContextWithRequestID returns a copy of ctx carrying the id of the request
+// being served, it ends up in the audit log.
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDContextKey, requestID)
+}
+
+// RequestIDFromContext returns the request id put on ctx by
+// ContextWithRequestID, empty if there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDContextKey).(string)
+	return requestID
+}
@@ -0,0 +1,154 @@
+package store
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"golang.org/x/exp/slices"
+)
+
+// pageCursor is the position right after the last row of a page, the values
+// of its sort keys. It is issued by the delegated store for one user and
+// table and signed so that it cannot be forged or handed to another user.
+type pageCursor struct {
+	UserID    int64         `json:"u"`
+	TableName string        `json:"t"`
+	OrderBy   []SortKey     `json:"o"`
+	Values    []interface{} `json:"v"`
+}
+
+// encodeCursor returns the cursor as '<payload>.<mac>', both base64.
+func encodeCursor(c pageCursor, key []byte) (string, error) {
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(cursorMAC(payload, key)), nil
+}
+
+func cursorMAC(payload, key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// verifyCursor decodes a cursor signed with key.
+func verifyCursor(s string, key []byte) (*pageCursor, error) {
+	payload, mac, err := splitCursor(s)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(mac, cursorMAC(payload, key)) {
+		return nil, NewInvalidQueryOptions("invalid cursor")
+	}
+	return parseCursor(payload)
+}
+
+// decodeCursor decodes a cursor without checking its signature, which is
+// up to whoever issued it.
+func decodeCursor(s string) (*pageCursor, error) {
+	payload, _, err := splitCursor(s)
+	if err != nil {
+		return nil, err
+	}
+	return parseCursor(payload)
+}
+
+func splitCursor(s string) ([]byte, []byte, error) {
+	p, m, ok := strings.Cut(s, ".")
+	if !ok {
+		return nil, nil, NewInvalidQueryOptions("invalid cursor")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(p)
+	if err != nil {
+		return nil, nil, NewInvalidQueryOptions("invalid cursor")
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(m)
+	if err != nil {
+		return nil, nil, NewInvalidQueryOptions("invalid cursor")
+	}
+	return payload, mac, nil
+}
+
+func parseCursor(payload []byte) (*pageCursor, error) {
+	dec := json.NewDecoder(bytes.NewReader(payload))
+	// keep integers exact rather than turning them into floats
+	dec.UseNumber()
+	var c pageCursor
+	if err := dec.Decode(&c); err != nil {
+		return nil, NewInvalidQueryOptions("invalid cursor")
+	}
+	for i, v := range c.Values {
+		n, ok := v.(json.Number)
+		if !ok {
+			continue
+		}
+		if integer, err := n.Int64(); err == nil {
+			c.Values[i] = integer
+			continue
+		}
+		f, err := n.Float64()
+		if err != nil {
+			return nil, NewInvalidQueryOptions("invalid cursor")
+		}
+		c.Values[i] = f
+	}
+	return &c, nil
+}
+
+// keyset returns the predicate selecting the rows after the cursor, for
+// keys (a, b) ascending that is a > x OR (a = x AND b > y).
+func (c *pageCursor) keyset(orderBy []SortKey) (Predicate, error) {
+	if !slices.Equal(c.OrderBy, orderBy) || len(c.Values) != len(orderBy) {
+		return Predicate{}, NewInvalidQueryOptions("cursor was issued for other sort keys")
+	}
+
+	var or []Predicate
+	for i, key := range orderBy {
+		var and []Predicate
+		for j := 0; j < i; j++ {
+			and = append(and, Eq(orderBy[j].Column, c.Values[j]))
+		}
+		op := OpGreaterThan
+		if key.Desc {
+			op = OpLessThan
+		}
+		and = append(and, NewPredicate(key.Column, op, c.Values[i]))
+		or = append(or, And(and...))
+	}
+	return Or(or...), nil
+}
+
+// nextCursor returns the cursor continuing after the last of results, empty
+// when results is the last page.
+func nextCursor(userID int64, opts QueryOptions, results QueryResult, key []byte) (string, error) {
+	if len(opts.OrderBy) == 0 || opts.Aggregated() || opts.Limit == 0 || len(results) < opts.Limit {
+		return "", nil
+	}
+
+	last := results[len(results)-1]
+	values := make([]interface{}, 0, len(opts.OrderBy))
+	for _, sortKey := range opts.OrderBy {
+		v, ok := last[sortKey.Column]
+		if !ok {
+			return "", NewInvalidQueryOptions(fmt.Sprintf("sort column '%s' has to be included to page through the results", sortKey.Column))
+		}
+		if v == nil {
+			return "", NewInvalidQueryOptions(fmt.Sprintf("cannot page past a null in sort column '%s'", sortKey.Column))
+		}
+		values = append(values, v)
+	}
+
+	return encodeCursor(pageCursor{
+		UserID:    userID,
+		TableName: opts.TableName,
+		OrderBy:   opts.OrderBy,
+		Values:    values,
+	}, key)
+}
@@ -2,33 +2,91 @@ package store
 
 import (
 	"context"
+	"crypto/rand"
 	"fmt"
-
-	"golang.org/x/exp/slices"
+	"strings"
+	"time"
 )
 
 type delegatedStore struct {
 	as  AdminStore
 	uo  UserOptions
 	usf UserStoreFactory
+	// user taken from the context when no token is given
+	user *User
+	// records every authorization decision, nil when auditing is disabled
+	auditStore AuditStore
+	// signs page cursors
+	cursorKey []byte
+	// caps queries joining tables of several stores
+	federationLimits FederationLimits
 }
 
 var _ DelegatedStore = (*delegatedStore)(nil)
+var _ PagedStore = (*delegatedStore)(nil)
+
+type DelegatedStoreOption func(*delegatedStore)
+
+// WithAuditStore records every authorization decision of the store along
+// with the outcome of allowed requests in audit.
+func WithAuditStore(audit AuditStore) DelegatedStoreOption {
+	return func(s *delegatedStore) {
+		s.auditStore = audit
+	}
+}
 
-func NewDelegatedStore(as AdminStore, usf UserStoreFactory) *delegatedStore {
-	return &delegatedStore{
-		as:  as,
-		usf: usf,
+// WithCursorKey signs page cursors with key, instances behind a load
+// balancer need to share it. A random key is used otherwise, which makes
+// cursors stop working on a restart.
+func WithCursorKey(key []byte) DelegatedStoreOption {
+	return func(s *delegatedStore) {
+		s.cursorKey = key
 	}
 }
 
+func NewDelegatedStore(as AdminStore, usf UserStoreFactory, opts ...DelegatedStoreOption) *delegatedStore {
+	s := &delegatedStore{
+		as:               as,
+		usf:              usf,
+		federationLimits: DefaultFederationLimits,
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.cursorKey == nil {
+		s.cursorKey = make([]byte, 32)
+		if _, err := rand.Read(s.cursorKey); err != nil {
+			panic(err)
+		}
+	}
+	return s
+}
+
 func (s *delegatedStore) AsUser(ctx context.Context, opts UserOptions) UserStore {
+	ds := &delegatedStore{
+		as:               s.as,
+		uo:               opts,
+		usf:              s.usf,
+		auditStore:       s.auditStore,
+		cursorKey:        s.cursorKey,
+		federationLimits: s.federationLimits,
+	}
+	if opts.Token == "" {
+		ds.user, _ = UserFromContext(ctx)
+	}
+	return ds
+}
 
-	return &delegatedStore{
-		as:  s.as,
-		uo:  opts,
-		usf: s.usf,
+// getUser returns the user the store acts as, the user is resolved from the
+// token unless it was already authenticated by the caller.
+func (s *delegatedStore) getUser(ctx context.Context) (*User, error) {
+	if s.uo.Token == "" {
+		if s.user == nil {
+			return nil, fmt.Errorf("no user to act as")
+		}
+		return s.user, nil
 	}
+	return s.as.GetUser(ctx, s.uo.Token)
 }
 
 var _ UserStore = (*delegatedStore)(nil)
@@ -37,111 +95,223 @@ func (s *delegatedStore) ID() int64 {
 	return -1
 }
 
-func (s *delegatedStore) Query(ctx context.Context, opts QueryOptions) (QueryResult, error) {
+func (s *delegatedStore) Query(ctx context.Context, opts QueryOptions) (results QueryResult, err error) {
+
+	event := s.newAuditEvent(ctx, opts.TableName, "query", opts.IncludeColumns, opts.Where)
+	defer func() {
+		event.Rows = int64(len(results))
+		if auditErr := s.audit(ctx, event, err); auditErr != nil {
+			results, err = nil, auditErr
+		}
+	}()
 
-	user, err := s.as.GetUser(ctx, s.uo.Token)
+	user, err := s.getUser(ctx)
 	if err != nil {
 		return nil, err
 	}
+	event.setUser(user)
 
 	table, err := s.as.GetTable(ctx, opts.TableName)
 	if err != nil {
 		return nil, err
 	}
 
-	perms, err := s.as.GetPermissionsForToken(ctx, s.uo.Token)
+	var stores map[string]int64
+	if len(opts.Joins) > 0 {
+		stores, err = s.authorizeJoins(ctx, user, &opts, event)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		var filters []Predicate
+		event.Rule, filters, err = s.authorizeRead(ctx, user, opts.TableName, opts.Columns(), opts.Where)
+		if err != nil {
+			return nil, err
+		}
+		opts.Where = append(opts.Where, filters...)
+	}
+
+	// the filters are added again on every page, the cursor only has to be
+	// one this user got for this table
+	if opts.Cursor != "" {
+		cursor, err := verifyCursor(opts.Cursor, s.cursorKey)
+		if err != nil {
+			return nil, err
+		}
+		if cursor.UserID != user.ID || cursor.TableName != opts.TableName {
+			return nil, NewInvalidQueryOptions("cursor was issued for another user or table")
+		}
+	}
+	event.allow(opts.Where)
+
+	// a join across stores cannot be pushed into one of them
+	if !singleStore(stores) {
+		return federatedQuery(ctx, s.usf, s.federationLimits, opts, stores)
+	}
+
+	us, err := s.usf.New(ctx, UserStoreOptions{
+		ID: table.StoreID,
+	})
 	if err != nil {
 		return nil, err
 	}
 
-	var tablePerms []string
+	return us.Query(ctx, opts)
+}
 
-	for _, perm := range perms {
-		if opts.TableName == perm.TableName {
-			tablePerms = append(tablePerms, perm.Permission)
-		}
+// authorizeRead checks that the user can read columns of the table and
+// returns the rule which allowed it along with the predicates limiting the
+// rows they can read.
+func (s *delegatedStore) authorizeRead(ctx context.Context, user *User, tableName string, columns []string, where []Predicate) (string, []Predicate, error) {
+	auth, err := s.authorizer(ctx, user, tableName)
+	if err != nil {
+		return "", nil, err
 	}
 
-	if len(tablePerms) == 0 {
-		return nil, fmt.Errorf("user with token '%s' not allowed to access table '%s'", s.uo.Token, opts.TableName)
+	// assumption if user has both READ_ALL and READ_RESTRICTED then user will have READ_ALL
+	readPerm, err := auth.authorize("query", READ_ALL_PERMISSION, READ_RESTRICTED_PERMISSION)
+	if err != nil {
+		return auth.matchedRule(READ_ALL_PERMISSION, READ_RESTRICTED_PERMISSION), nil, err
 	}
+	rule := auth.matchedRule(readPerm)
 
-	hasReadPermission := func() bool {
-		return slices.ContainsFunc(tablePerms, func(s string) bool {
-			if s == READ_ALL_PERMISSION || s == READ_RESTRICTED_PERMISSION {
-				return true
-			}
-			return false
-		})
+	// the grants which decide the rows that can be read also decide the
+	// columns, sorting, grouping and aggregating by a column reveals its
+	// values as well
+	if err := auth.checkColumns(readPerm, columns, where); err != nil {
+		return rule, nil, err
 	}
 
-	// assumption if user has both READ_ALL and READ_RESTRICTED then user will have READ_ALL
-	hasOnlyRestrictedReadPermission := func() bool {
-		return slices.Contains(tablePerms, READ_RESTRICTED_PERMISSION) && !slices.Contains(tablePerms, READ_ALL_PERMISSION)
+	// rows are filtered before they are grouped, so aggregates only cover
+	// rows the user can read
+	var filters []Predicate
+	if readPerm == READ_RESTRICTED_PERMISSION {
+		filters = append(filters, Eq("created_by", user.ID))
+	}
+
+	policies, err := s.rowPolicyPredicates(ctx, user, tableName)
+	if err != nil {
+		return rule, nil, err
 	}
+	return rule, append(filters, policies...), nil
+}
 
-	if !hasReadPermission() {
-		return nil, fmt.Errorf("user with '%s' token cannot perform this query action", s.uo.Token)
+// authorizeJoins authorizes the read of every table of a query with joins
+// and adds the filters of each table to the query under its alias, those
+// of the queried table to the where clause and those of joined tables to
+// their join condition. A left join then joins nulls in place of rows the
+// user cannot read rather than dropping the row they would be joined to.
+// It returns the store of every table by alias.
+func (s *delegatedStore) authorizeJoins(ctx context.Context, user *User, opts *QueryOptions, event *AuditEvent) (map[string]int64, error) {
+	// the checks below rely on well formed references
+	if err := opts.Validate(); err != nil {
+		return nil, err
 	}
+	columns := opts.columnsByAlias()
+	stores := make(map[string]int64)
+
+	var rules []string
+	authorize := func(tableName, alias string) ([]Predicate, error) {
+		table, err := s.as.GetTable(ctx, tableName)
+		if err != nil {
+			return nil, err
+		}
+		stores[alias] = table.StoreID
 
-	if hasOnlyRestrictedReadPermission() {
-		opts.Where = append(opts.Where, fmt.Sprintf("created_by = %d", user.ID))
+		rule, filters, err := s.authorizeRead(ctx, user, tableName, columns[alias], nil)
+		rules = append(rules, rule)
+		event.Rule = strings.Join(rules, ", ")
+		if err != nil {
+			return nil, err
+		}
+		return qualified(filters, alias), nil
 	}
 
-	us, err := s.usf.New(ctx, UserStoreOptions{
-		ID: table.StoreID,
-	})
+	filters, err := authorize(opts.TableName, opts.Alias())
 	if err != nil {
 		return nil, err
 	}
+	opts.Where = append(opts.Where, filters...)
 
-	return us.Query(ctx, opts)
+	joins := make([]Join, len(opts.Joins))
+	for i, join := range opts.Joins {
+		filters, err := authorize(join.TableName, join.Alias())
+		if err != nil {
+			return nil, err
+		}
+		join.Where = append(join.Where[:len(join.Where):len(join.Where)], filters...)
+		joins[i] = join
+	}
+	opts.Joins = joins
+
+	return stores, nil
 }
 
-func (s *delegatedStore) Exec(ctx context.Context, opts ExecOptions) (*ExecResult, error) {
-	user, err := s.as.GetUser(ctx, s.uo.Token)
+func (s *delegatedStore) Exec(ctx context.Context, opts ExecOptions) (result *ExecResult, err error) {
+	var columns []string
+	for _, v := range opts.Values {
+		columns = append(columns, v.Name)
+	}
+
+	event := s.newAuditEvent(ctx, opts.TableName, string(opts.Type), columns, opts.Where)
+	defer func() {
+		if result != nil {
+			event.Rows = result.RowsAffected
+		}
+		// the change is made by now, but the caller learns that it
+		// went unaudited
+		if auditErr := s.audit(ctx, event, err); auditErr != nil {
+			result, err = nil, auditErr
+		}
+	}()
+
+	user, err := s.getUser(ctx)
 	if err != nil {
 		return nil, err
 	}
+	event.setUser(user)
 
 	table, err := s.as.GetTable(ctx, opts.TableName)
 	if err != nil {
 		return nil, err
 	}
 
-	perms, err := s.as.GetPermissionsForToken(ctx, s.uo.Token)
+	auth, err := s.authorizer(ctx, user, opts.TableName)
 	if err != nil {
 		return nil, err
 	}
 
-	var tablePerms []string
-
-	for _, perm := range perms {
-		if opts.TableName == perm.TableName {
-			tablePerms = append(tablePerms, perm.Permission)
-		}
+	// deletes are governed by their own pair of permissions, inserts and
+	// updates by the write permissions
+	allPerm, restrictedPerm := WRITE_ALL_PERMISSION, WRITE_RESTRICTED_PERMISSION
+	if opts.Type == ExecTypeDelete {
+		allPerm, restrictedPerm = DELETE_ALL_PERMISSION, DELETE_RESTRICTED_PERMISSION
 	}
 
-	if len(tablePerms) == 0 {
-		return nil, fmt.Errorf("user with token '%s' not allowed to access table '%s'", s.uo.Token, opts.TableName)
+	// assumption if user have both the restricted and the all permission, then they will have the all permission
+	execPerm, err := auth.authorize(string(opts.Type), allPerm, restrictedPerm)
+	if err != nil {
+		event.Rule = auth.matchedRule(allPerm, restrictedPerm)
+		return nil, err
 	}
+	event.Rule = auth.matchedRule(execPerm)
 
-	hasWritePermission := func() bool {
-		return slices.ContainsFunc(tablePerms, func(s string) bool {
-			if s == WRITE_ALL_PERMISSION || s == WRITE_RESTRICTED_PERMISSION {
-				return true
-			}
-			return false
-		})
+	err = auth.checkColumns(execPerm, columns, opts.Where)
+	if err != nil {
+		return nil, err
 	}
 
-	// assumption if user have both WRITE_RESTRICTED and WRITE_ALL, then they will have WRITE_ALL
-	hasOnlyRestrictedUpdatePermission := func() bool {
-		return slices.Contains(tablePerms, WRITE_RESTRICTED_PERMISSION) && !slices.Contains(tablePerms, WRITE_ALL_PERMISSION)
+	// the owner of a row is set by the store, writing it would hand rows
+	// to other users or take theirs
+	for _, col := range columns {
+		if col == "created_by" {
+			return nil, fmt.Errorf("column 'created_by' cannot be written")
+		}
 	}
 
-	if !hasWritePermission() {
-		return nil, fmt.Errorf("user with token '%s' cannot perform exec action", s.uo.Token)
+	policies, err := s.rowPolicies(ctx, user, opts.TableName)
+	if err != nil {
+		return nil, err
 	}
 
 	switch opts.Type {
@@ -150,11 +320,26 @@ func (s *delegatedStore) Exec(ctx context.Context, opts ExecOptions) (*ExecResul
 			Name:  "created_by",
 			Value: user.ID,
 		})
-	case ExecTypeUpdate:
-		if hasOnlyRestrictedUpdatePermission() {
-			opts.Where = append(opts.Where, fmt.Sprintf("created_by = %d", user.ID))
+		if _, err := checkWrite(opts, policies); err != nil {
+			return nil, err
+		}
+	case ExecTypeUpdate, ExecTypeDelete:
+		if execPerm == restrictedPerm {
+			opts.Where = append(opts.Where, Eq("created_by", user.ID))
+		}
+
+		for _, policy := range policies {
+			opts.Where = append(opts.Where, policy.Predicate)
+		}
+		if opts.Type == ExecTypeUpdate {
+			residuals, err := checkWrite(opts, policies)
+			if err != nil {
+				return nil, err
+			}
+			opts.Where = append(opts.Where, residuals...)
 		}
 	}
+	event.allow(opts.Where)
 
 	us, err := s.usf.New(ctx, UserStoreOptions{
 		ID: table.StoreID,
@@ -165,3 +350,143 @@ func (s *delegatedStore) Exec(ctx context.Context, opts ExecOptions) (*ExecResul
 
 	return us.Exec(ctx, opts)
 }
+
+// newAuditEvent starts the event of a request before the user is looked up,
+// so that requests with unknown or revoked keys are recorded under the key id
+// of their token.
+func (s *delegatedStore) newAuditEvent(ctx context.Context, tableName, operation string, columns []string, where []Predicate) *AuditEvent {
+	event := newAuditEvent(ctx, tableName, operation, columns, where)
+	if s.uo.Token == "" {
+		if s.user != nil {
+			event.setUser(s.user)
+		}
+	} else if prefix, _, err := splitToken(s.uo.Token); err == nil {
+		event.KeyID = prefix
+	}
+	return event
+}
+
+// audit records event once the request is done, a request which cannot be
+// audited fails.
+func (s *delegatedStore) audit(ctx context.Context, event *AuditEvent, err error) error {
+	if s.auditStore == nil {
+		return nil
+	}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	if auditErr := s.auditStore.Record(ctx, *event); auditErr != nil {
+		return fmt.Errorf("recording audit event: %w", auditErr)
+	}
+	return nil
+}
+
+func (s *delegatedStore) NextCursor(ctx context.Context, opts QueryOptions, results QueryResult) (string, error) {
+	user, err := s.getUser(ctx)
+	if err != nil {
+		return "", err
+	}
+	return nextCursor(user.ID, opts, results, s.cursorKey)
+}
+
+// authorizer returns the authorizer of the user for the table built from
+// their grants and deny rules.
+func (s *delegatedStore) authorizer(ctx context.Context, user *User, tableName string) (*authorizer, error) {
+	perms, err := s.as.GetPermissionsForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	denyRules, err := s.as.GetDenyRulesForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newAuthorizer(user, tableName, perms, denyRules, time.Now()), nil
+}
+
+// rowPolicies returns the row policies of the table with their predicates
+// resolved against the attributes of the user.
+func (s *delegatedStore) rowPolicies(ctx context.Context, user *User, tableName string) ([]RowPolicy, error) {
+	policies, err := s.as.GetRowPolicies(ctx, tableName)
+	if err != nil {
+		return nil, err
+	}
+	if len(policies) == 0 {
+		return nil, nil
+	}
+
+	attrs, err := s.as.GetUserAttributes(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	lookup := newUserAttributeLookup(user, attrs)
+
+	ret := make([]RowPolicy, 0, len(policies))
+	for _, policy := range policies {
+		pred, err := resolvePredicate(policy.Predicate, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("row policy '%s' on table '%s': %w", policy.Name, tableName, err)
+		}
+		if err := pred.Validate(); err != nil {
+			return nil, fmt.Errorf("row policy '%s' on table '%s': %w", policy.Name, tableName, err)
+		}
+		policy.Predicate = pred
+		ret = append(ret, policy)
+	}
+
+	return ret, nil
+}
+
+// rowPolicyPredicates returns the resolved predicates of the row policies
+// of the table.
+func (s *delegatedStore) rowPolicyPredicates(ctx context.Context, user *User, tableName string) ([]Predicate, error) {
+	policies, err := s.rowPolicies(ctx, user, tableName)
+	if err != nil {
+		return nil, err
+	}
+	var ret []Predicate
+	for _, policy := range policies {
+		ret = append(ret, policy.Predicate)
+	}
+	return ret, nil
+}
+
+// checkWrite checks the row an insert or update writes against the row
+// policies of the table. An insert has to satisfy every policy, columns it
+// does not set taken as null. An update has to leave the rows it changes
+// within every policy which refers to a column it sets, the part of a
+// policy which depends on columns it does not set is returned to be added
+// to its where clause.
+func checkWrite(opts ExecOptions, policies []RowPolicy) ([]Predicate, error) {
+	values := make(map[string]interface{}, len(opts.Values))
+	for _, v := range opts.Values {
+		values[v.Name] = v.Value
+	}
+
+	var ret []Predicate
+	for _, policy := range policies {
+		fields := policy.Predicate.Fields()
+		written := false
+		for _, field := range fields {
+			if _, ok := values[field]; ok {
+				written = true
+			} else if opts.Type == ExecTypeInsert {
+				values[field] = nil
+			}
+		}
+		if !written && opts.Type != ExecTypeInsert {
+			continue
+		}
+
+		residual, t := policy.Predicate.check(values)
+		switch t {
+		case truthTrue:
+		case truthOpen:
+			ret = append(ret, residual)
+		default:
+			return nil, fmt.Errorf("%s on table '%s' violates row policy '%s'", opts.Type, opts.TableName, policy.Name)
+		}
+	}
+	return ret, nil
+}
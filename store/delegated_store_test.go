@@ -2,8 +2,10 @@ package store_test
 
 import (
 	"context"
+	"strings"
 	"testing"
 
+	"github.com/google/go-cmp/cmp"
 	"github.com/thekb/chroma-takehome/store"
 )
 
@@ -47,12 +49,20 @@ func TestDelegatedStoreBasic(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = as.AddPermission(context.TODO(), user.ID, "foo", store.WRITE_ALL_PERMISSION)
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "foo",
+		Permission: store.WRITE_ALL_PERMISSION,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = as.AddPermission(context.TODO(), user.ID, "foo", store.READ_ALL_PERMISSION)
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "foo",
+		Permission: store.READ_ALL_PERMISSION,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -101,7 +111,7 @@ func TestDelegatedStoreBasic(t *testing.T) {
 				Value: "test2",
 			},
 		},
-		Where: []string{"name = 'test'"},
+		Where: []store.Predicate{store.Eq("name", "test")},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -113,7 +123,7 @@ func TestDelegatedStoreBasic(t *testing.T) {
 	}).Query(context.TODO(), store.QueryOptions{
 		TableName:      "foo",
 		IncludeColumns: []string{"id", "name"},
-		Where:          []string{"name = 'test2'"},
+		Where:          []store.Predicate{store.Eq("name", "test2")},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -162,12 +172,20 @@ func TestDelegatedStoreRestricted(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = as.AddPermission(context.TODO(), user1.ID, "foo", store.WRITE_RESTRICTED_PERMISSION)
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user1.ID,
+		TableName:  "foo",
+		Permission: store.WRITE_RESTRICTED_PERMISSION,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = as.AddPermission(context.TODO(), user1.ID, "foo", store.READ_RESTRICTED_PERMISSION)
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user1.ID,
+		TableName:  "foo",
+		Permission: store.READ_RESTRICTED_PERMISSION,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -183,12 +201,20 @@ func TestDelegatedStoreRestricted(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	err = as.AddPermission(context.TODO(), user2.ID, "foo", store.WRITE_RESTRICTED_PERMISSION)
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user2.ID,
+		TableName:  "foo",
+		Permission: store.WRITE_RESTRICTED_PERMISSION,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
 
-	err = as.AddPermission(context.TODO(), user2.ID, "foo", store.READ_RESTRICTED_PERMISSION)
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user2.ID,
+		TableName:  "foo",
+		Permission: store.READ_RESTRICTED_PERMISSION,
+	})
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -252,7 +278,7 @@ func TestDelegatedStoreRestricted(t *testing.T) {
 				Value: "test3",
 			},
 		},
-		Where: []string{"name = 'test2'"},
+		Where: []store.Predicate{store.Eq("name", "test2")},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -264,7 +290,7 @@ func TestDelegatedStoreRestricted(t *testing.T) {
 	}).Query(context.TODO(), store.QueryOptions{
 		TableName:      "foo",
 		IncludeColumns: []string{"id", "name"},
-		Where:          []string{"name = 'test2'"},
+		Where:          []store.Predicate{store.Eq("name", "test2")},
 	})
 	if err != nil {
 		t.Fatal(err)
@@ -272,3 +298,495 @@ func TestDelegatedStoreRestricted(t *testing.T) {
 	t.Log(results)
 
 }
+
+func TestDelegatedStoreRestrictedPredicates(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	var tokens []string
+	for _, name := range []string{"test-user-1", "test-user-2"} {
+		token, err := as.AddUser(context.TODO(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := as.GetUser(context.TODO(), token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, perm := range []string{store.READ_RESTRICTED_PERMISSION, store.WRITE_RESTRICTED_PERMISSION} {
+			if err := as.AddPermission(context.TODO(), store.PermissionOptions{
+				UserID:     user.ID,
+				TableName:  "foo",
+				Permission: perm,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		_, err = ds.AsUser(context.TODO(), store.UserOptions{
+			Token: token,
+		}).Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: name}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, token)
+	}
+
+	// an OR at the top level must not widen the created_by restriction
+	results, err := ds.AsUser(context.TODO(), store.UserOptions{
+		Token: tokens[0],
+	}).Query(context.TODO(), store.QueryOptions{
+		TableName:      "foo",
+		IncludeColumns: []string{"id", "name"},
+		Where: []store.Predicate{
+			store.Or(store.Eq("name", "test-user-2"), store.NewPredicate("id", store.OpGreaterThan, 0)),
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0]["name"] != "test-user-1" {
+		t.Fatalf("expected only own row, got %v", results)
+	}
+
+	res, err := ds.AsUser(context.TODO(), store.UserOptions{
+		Token: tokens[0],
+	}).Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeUpdate,
+		TableName: "foo",
+		Values:    []store.FieldValue{{Name: "name", Value: "hijacked"}},
+		Where:     []store.Predicate{store.Or(store.Eq("name", "test-user-2"), store.Eq("id", 2))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 0 {
+		t.Fatalf("expected no rows to be updated, got %d", res.RowsAffected)
+	}
+}
+
+func TestDelegatedStoreDelete(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	// writer can insert but not delete, deleter can only delete own rows
+	perms := map[string][]string{
+		"writer":  {store.WRITE_ALL_PERMISSION},
+		"deleter": {store.WRITE_RESTRICTED_PERMISSION, store.DELETE_RESTRICTED_PERMISSION},
+		"admin":   {store.READ_ALL_PERMISSION, store.DELETE_ALL_PERMISSION},
+	}
+	tokens := make(map[string]string)
+	for name, userPerms := range perms {
+		token, err := as.AddUser(context.TODO(), name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		user, err := as.GetUser(context.TODO(), token)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, perm := range userPerms {
+			if err := as.AddPermission(context.TODO(), store.PermissionOptions{
+				UserID:     user.ID,
+				TableName:  "foo",
+				Permission: perm,
+			}); err != nil {
+				t.Fatal(err)
+			}
+		}
+		tokens[name] = token
+	}
+
+	for _, name := range []string{"writer", "deleter"} {
+		_, err = ds.AsUser(context.TODO(), store.UserOptions{
+			Token: tokens[name],
+		}).Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: name}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	deleteAll := func(name string) (*store.ExecResult, error) {
+		return ds.AsUser(context.TODO(), store.UserOptions{
+			Token: tokens[name],
+		}).Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeDelete,
+			TableName: "foo",
+			Where:     []store.Predicate{store.NewPredicate("id", store.OpGreaterThan, 0)},
+		})
+	}
+
+	if _, err := deleteAll("writer"); err == nil {
+		t.Fatal("expected writer to be denied delete")
+	}
+
+	res, err := deleteAll("deleter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 1 {
+		t.Fatalf("expected deleter to delete only its own row, got %d", res.RowsAffected)
+	}
+
+	results, err := ds.AsUser(context.TODO(), store.UserOptions{
+		Token: tokens["admin"],
+	}).Query(context.TODO(), store.QueryOptions{
+		TableName:      "foo",
+		IncludeColumns: []string{"id", "name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[0]["name"] != "writer" {
+		t.Fatalf("expected only writer row to remain, got %v", results)
+	}
+
+	res, err = deleteAll("admin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 1 {
+		t.Fatalf("expected admin to delete remaining row, got %d", res.RowsAffected)
+	}
+}
+
+func TestDelegatedStoreColumnPermissions(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "orders",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"item", "text"},
+			{"card_last4", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := as.AddUser(context.TODO(), "support-agent")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, opts := range []store.PermissionOptions{
+		{UserID: user.ID, TableName: "orders", Permission: store.READ_ALL_PERMISSION, Columns: []string{"id", "item"}},
+		{UserID: user.ID, TableName: "orders", Permission: store.WRITE_ALL_PERMISSION, Columns: []string{"item"}},
+	} {
+		if err := as.AddPermission(context.TODO(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tps, err := as.GetPermissionsForToken(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(tps, []store.TablePermission{
+		{TableName: "orders", Permission: store.READ_ALL_PERMISSION, Columns: []string{"id", "item"}},
+		{TableName: "orders", Permission: store.WRITE_ALL_PERMISSION, Columns: []string{"item"}},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	us := store.NewDelegatedStore(as, usf).AsUser(context.TODO(), store.UserOptions{
+		Token: token,
+	})
+
+	insert := func(values ...store.FieldValue) error {
+		_, err := us.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "orders",
+			Values:    values,
+		})
+		return err
+	}
+	if err := insert(store.FieldValue{Name: "item", Value: "book"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := insert(store.FieldValue{Name: "item", Value: "pen"}, store.FieldValue{Name: "card_last4", Value: "1234"}); err == nil {
+		t.Fatal("expected write to card_last4 to be denied")
+	}
+
+	query := func(columns []string, where ...store.Predicate) (store.QueryResult, error) {
+		return us.Query(context.TODO(), store.QueryOptions{
+			TableName:      "orders",
+			IncludeColumns: columns,
+			Where:          where,
+		})
+	}
+
+	results, err := query([]string{"id", "item"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 {
+		t.Fatalf("expected 1 row, got %v", results)
+	}
+
+	if _, err := query([]string{"id", "card_last4"}); err == nil {
+		t.Fatal("expected read of card_last4 to be denied")
+	}
+	if _, err := query([]string{"*"}); err == nil {
+		t.Fatal("expected select * to be denied")
+	}
+	if _, err := query([]string{"id"}, store.Or(store.Eq("item", "book"), store.Eq("card_last4", "1234"))); err == nil {
+		t.Fatal("expected filtering on card_last4 to be denied")
+	}
+
+	// widening the grant replaces the columns
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "orders",
+		Permission: store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = query([]string{"*"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || len(results[0]) != 4 {
+		t.Fatalf("expected all columns, got %v", results)
+	}
+}
+
+func TestDelegatedStoreUserFromContext(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	token, err := as.AddUser(context.TODO(), "test-user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     user.ID,
+		TableName:  "foo",
+		Permission: store.READ_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+	query := store.QueryOptions{
+		TableName:      "foo",
+		IncludeColumns: []string{"id", "name"},
+	}
+
+	// without a token or a user on the context there is nobody to act as
+	if _, err := ds.AsUser(context.TODO(), store.UserOptions{}).Query(context.TODO(), query); err == nil {
+		t.Fatal("expected query without a user to fail")
+	}
+
+	ctx := store.ContextWithUser(context.TODO(), user)
+	if _, err := ds.AsUser(ctx, store.UserOptions{}).Query(ctx, query); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDelegatedStorePaging(t *testing.T) {
+	as, usf := newTestAdminStore(t, store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+			{"secret", "text"},
+		},
+	})
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	// the restricted user only gets to see their own rows on every page
+	restricted := newTestUser(t, as, ds, "restricted-user",
+		store.PermissionOptions{TableName: "foo", Permission: store.READ_RESTRICTED_PERMISSION},
+		store.PermissionOptions{TableName: "foo", Permission: store.WRITE_ALL_PERMISSION},
+	)
+	other := newTestUser(t, as, ds, "other-user",
+		store.PermissionOptions{TableName: "foo", Permission: store.READ_ALL_PERMISSION},
+		store.PermissionOptions{TableName: "foo", Permission: store.WRITE_ALL_PERMISSION},
+	)
+	narrow := newTestUser(t, as, ds, "narrow-user",
+		store.PermissionOptions{TableName: "foo", Permission: store.READ_ALL_PERMISSION, Columns: []string{"id"}},
+	)
+
+	insert := func(us store.UserStore, name string) {
+		t.Helper()
+		_, err := us.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: name}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"a", "b", "b", "c", "d"} {
+		insert(restricted, name)
+	}
+	insert(other, "b")
+	insert(other, "e")
+
+	// name descending, id ascending among equal names
+	orderBy := []store.SortKey{{Column: "name", Desc: true}, {Column: "id"}}
+	query := func(us store.UserStore, opts store.QueryOptions) (store.QueryResult, string, error) {
+		opts.TableName = "foo"
+		opts.IncludeColumns = []string{"id", "name"}
+		results, err := us.Query(context.TODO(), opts)
+		if err != nil {
+			return nil, "", err
+		}
+		cursor, err := us.(store.PagedStore).NextCursor(context.TODO(), opts, results)
+		return results, cursor, err
+	}
+
+	var ids []int64
+	var cursor string
+	for pages := 0; ; pages++ {
+		if pages > 3 {
+			t.Fatalf("too many pages, got %v", ids)
+		}
+		results, next, err := query(restricted, store.QueryOptions{OrderBy: orderBy, Limit: 2, Cursor: cursor})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, rec := range results {
+			ids = append(ids, rec["id"].(int64))
+		}
+		if next == "" {
+			break
+		}
+		cursor = next
+	}
+	if diff := cmp.Diff(ids, []int64{5, 4, 2, 3, 1}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	results, _, err := query(other, store.QueryOptions{OrderBy: []store.SortKey{{Column: "id"}}, Offset: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0]["id"].(int64) != 6 {
+		t.Fatalf("unexpected results %v", results)
+	}
+
+	// a cursor of the other user would continue with rows the restricted
+	// user cannot see if it was taken as is
+	_, otherCursor, err := query(other, store.QueryOptions{OrderBy: orderBy, Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if otherCursor == "" {
+		t.Fatal("expected a cursor")
+	}
+	if _, _, err := query(restricted, store.QueryOptions{OrderBy: orderBy, Limit: 2, Cursor: otherCursor}); err == nil {
+		t.Fatal("expected cursor of another user to be rejected")
+	}
+
+	_, firstCursor, err := query(restricted, store.QueryOptions{OrderBy: orderBy, Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, mac, _ := strings.Cut(firstCursor, ".")
+	for _, tc := range []struct {
+		name string
+		opts store.QueryOptions
+	}{
+		{"tampered", store.QueryOptions{OrderBy: orderBy, Limit: 2, Cursor: payload + "x." + mac}},
+		{"unsigned", store.QueryOptions{OrderBy: orderBy, Limit: 2, Cursor: payload}},
+		{"other sort keys", store.QueryOptions{OrderBy: []store.SortKey{{Column: "id"}}, Limit: 2, Cursor: firstCursor}},
+		{"with offset", store.QueryOptions{OrderBy: orderBy, Limit: 2, Offset: 2, Cursor: firstCursor}},
+		{"without sort keys", store.QueryOptions{Limit: 2, Cursor: firstCursor}},
+		{"invalid sort column", store.QueryOptions{OrderBy: []store.SortKey{{Column: "name; drop table foo"}}}},
+		{"negative offset", store.QueryOptions{Offset: -1}},
+	} {
+		if _, _, err := query(restricted, tc.opts); err == nil {
+			t.Fatalf("%s: expected query to be rejected", tc.name)
+		}
+	}
+
+	// sorting by a column reveals its values
+	if _, err := narrow.Query(context.TODO(), store.QueryOptions{
+		TableName:      "foo",
+		IncludeColumns: []string{"id"},
+		OrderBy:        []store.SortKey{{Column: "secret"}},
+	}); err == nil {
+		t.Fatal("expected sorting by secret to be denied")
+	}
+}
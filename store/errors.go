@@ -8,6 +8,11 @@ import (
 var ErrInvalidQueryOptions = errors.New("invalid query options")
 var ErrInvalidExecOptions = errors.New("invalid exec options")
 var ErrInvalidTableCreationOptions = errors.New("invalid table creation options")
+var ErrInvalidTableDropOptions = errors.New("invalid table drop options")
+var ErrInvalidPredicate = errors.New("invalid predicate")
+var ErrUnsupportedToken = errors.New("unsupported token")
+var ErrInvalidSQL = errors.New("invalid sql")
+var ErrFederationLimitExceeded = errors.New("federated query limit exceeded")
 
 func NewInvalidQueryOptions(msg string) error {
 	return fmt.Errorf("%w: %s", ErrInvalidQueryOptions, msg)
@@ -20,3 +25,19 @@ func NewInvalidExecOptions(msg string) error {
 func NewInvalidTableCreationOptions(msg string) error {
 	return fmt.Errorf("%w: %s", ErrInvalidTableCreationOptions, msg)
 }
+
+func NewInvalidTableDropOptions(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidTableDropOptions, msg)
+}
+
+func NewInvalidPredicate(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidPredicate, msg)
+}
+
+func NewFederationLimitExceeded(msg string) error {
+	return fmt.Errorf("%w: %s", ErrFederationLimitExceeded, msg)
+}
+
+func NewInvalidSQL(msg string) error {
+	return fmt.Errorf("%w: %s", ErrInvalidSQL, msg)
+}
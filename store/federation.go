@@ -0,0 +1,219 @@
+package store
+
+import (
+	"context"
+	"fmt"
+
+	"golang.org/x/exp/slices"
+)
+
+// FederationLimits caps what a query joining tables of several stores
+// pulls into memory, zero values leave that dimension unlimited.
+type FederationLimits struct {
+	// rows read from all stores together
+	MaxRows int
+	// approximate size of the values read, strings and blobs by their
+	// length and anything else as 8 bytes
+	MaxBytes int64
+}
+
+var DefaultFederationLimits = FederationLimits{
+	MaxRows:  100000,
+	MaxBytes: 64 << 20,
+}
+
+// WithFederationLimits replaces DefaultFederationLimits for queries joining
+// tables of several stores.
+func WithFederationLimits(limits FederationLimits) DelegatedStoreOption {
+	return func(s *delegatedStore) {
+		s.federationLimits = limits
+	}
+}
+
+// scanner is implemented by stores which can hand out the rows of a query
+// one at a time as they are read.
+type scanner interface {
+	scan(ctx context.Context, opts QueryOptions, row func(map[string]interface{}) error) error
+}
+
+// singleStore reports whether every table of a query is in the same store.
+func singleStore(stores map[string]int64) bool {
+	var first int64
+	for _, id := range stores {
+		if first == 0 {
+			first = id
+		} else if id != first {
+			return false
+		}
+	}
+	return true
+}
+
+// federatedQuery runs an authorized query joining tables of several stores.
+// Every table is read from its store with the predicates which concern only
+// that table, which include the filters of the delegated store, and the
+// rows are loaded into an in-memory database private to the query where
+// the query is then run as is, joins, aggregates and all. The limits are
+// enforced as rows are read, a table over them is never read in full.
+func federatedQuery(ctx context.Context, usf UserStoreFactory, limits FederationLimits, opts QueryOptions, stores map[string]int64) (QueryResult, error) {
+	if err := opts.Validate(); err != nil {
+		return nil, err
+	}
+
+	db, err := NewSQLite3Store(":memory:", 0)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	tables := opts.Tables()
+	columns := opts.columnsByAlias()
+	pushed := opts.pushdown()
+
+	aliases := []string{opts.Alias()}
+	for _, join := range opts.Joins {
+		aliases = append(aliases, join.Alias())
+	}
+
+	var rows int
+	var size int64
+	for _, alias := range aliases {
+		var cols []string
+		for _, col := range columns[alias] {
+			if !slices.Contains(cols, col) {
+				cols = append(cols, col)
+			}
+		}
+		if len(cols) == 0 {
+			return nil, NewInvalidQueryOptions(fmt.Sprintf("table '%s' in another store has to be referenced by a column", alias))
+		}
+
+		var defs [][]string
+		for _, col := range cols {
+			defs = append(defs, []string{col})
+		}
+		if err := db.CreateTable(ctx, CreateTableOptions{
+			TableName:   alias,
+			Definitions: defs,
+		}); err != nil {
+			return nil, err
+		}
+		load := func(rec map[string]interface{}) error {
+			rows++
+			if limits.MaxRows > 0 && rows > limits.MaxRows {
+				return NewFederationLimitExceeded(fmt.Sprintf("more than %d rows", limits.MaxRows))
+			}
+			values := make([]FieldValue, 0, len(cols))
+			for _, col := range cols {
+				size += valueSize(rec[col])
+				values = append(values, FieldValue{Name: col, Value: rec[col]})
+			}
+			if limits.MaxBytes > 0 && size > limits.MaxBytes {
+				return NewFederationLimitExceeded(fmt.Sprintf("more than %d bytes", limits.MaxBytes))
+			}
+			_, err := db.Exec(ctx, ExecOptions{
+				Type:      ExecTypeInsert,
+				TableName: alias,
+				Values:    values,
+			})
+			return err
+		}
+
+		sub := QueryOptions{
+			TableName:      tables[alias],
+			IncludeColumns: cols,
+			Where:          pushed[alias],
+		}
+		// one more than allowed tells that the limit is exceeded
+		if limits.MaxRows > 0 {
+			sub.Limit = limits.MaxRows - rows + 1
+		}
+		us, err := usf.New(ctx, UserStoreOptions{
+			ID: stores[alias],
+		})
+		if err != nil {
+			return nil, err
+		}
+		if sc, ok := us.(scanner); ok {
+			if err := sc.scan(ctx, sub, load); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		res, err := us.Query(ctx, sub)
+		if err != nil {
+			return nil, err
+		}
+		for _, rec := range res {
+			if err := load(rec); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// tables of the in-memory database are named by their alias
+	opts.TableName = opts.Alias()
+	joins := make([]Join, len(opts.Joins))
+	for i, join := range opts.Joins {
+		join.TableName = join.Alias()
+		joins[i] = join
+	}
+	opts.Joins = joins
+
+	return db.Query(ctx, opts)
+}
+
+// pushdown returns the predicates of a query with joins which can be
+// applied to a single table before it is joined, by alias and unqualified.
+// Predicates of the where clause are left to tables which cannot be joined
+// as nulls, on a table which is left joined filtering its rows beforehand
+// would turn the rows they were joined to into nulls rather than drop them.
+func (o QueryOptions) pushdown() map[string][]Predicate {
+	nullable := make(map[string]bool)
+	for _, join := range o.Joins {
+		if join.Type == JoinLeft {
+			nullable[join.Alias()] = true
+		}
+	}
+
+	ret := make(map[string][]Predicate)
+	push := func(pred Predicate, allowed func(alias string) bool) {
+		var alias string
+		for _, field := range pred.Fields() {
+			a, _, ok := splitReference(field)
+			if !ok || (alias != "" && a != alias) {
+				return
+			}
+			alias = a
+		}
+		if alias != "" && allowed(alias) {
+			ret[alias] = append(ret[alias], unqualified([]Predicate{pred})...)
+		}
+	}
+
+	for _, pred := range o.Where {
+		push(pred, func(alias string) bool {
+			return !nullable[alias]
+		})
+	}
+	for _, join := range o.Joins {
+		for _, pred := range join.Where {
+			push(pred, func(alias string) bool {
+				return alias == join.Alias()
+			})
+		}
+	}
+	return ret
+}
+
+func valueSize(v interface{}) int64 {
+	switch v := v.(type) {
+	case nil:
+		return 0
+	case string:
+		return int64(len(v))
+	case []byte:
+		return int64(len(v))
+	}
+	return 8
+}
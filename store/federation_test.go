@@ -0,0 +1,156 @@
+package store_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestDelegatedStoreFederatedJoins(t *testing.T) {
+	// every table gets a store of its own
+	as, usf := newTestAdminStore(t,
+		store.CreateTableOptions{
+			TableName: "customers",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+				{"email", "text"},
+			},
+		},
+		store.CreateTableOptions{
+			TableName: "orders",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"customer_id", "integer"},
+				{"amount", "real"},
+			},
+		},
+	)
+	customers, err := as.GetTable(context.TODO(), "customers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders, err := as.GetTable(context.TODO(), "orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if customers.StoreID == orders.StoreID {
+		t.Fatal("expected tables in different stores")
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+	sales := newTestUser(t, as, ds, "sales-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.READ_RESTRICTED_PERMISSION},
+		store.PermissionOptions{TableName: "orders", Permission: store.WRITE_ALL_PERMISSION},
+		store.PermissionOptions{TableName: "customers", Permission: store.READ_ALL_PERMISSION, Columns: []string{"id", "name"}},
+		store.PermissionOptions{TableName: "customers", Permission: store.WRITE_ALL_PERMISSION},
+	)
+	other := newTestUser(t, as, ds, "other-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.WRITE_ALL_PERMISSION},
+	)
+
+	insert := func(us store.UserStore, table string, values ...store.FieldValue) {
+		t.Helper()
+		_, err := us.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: table,
+			Values:    values,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		insert(sales, "customers", store.FieldValue{Name: "name", Value: name}, store.FieldValue{Name: "email", Value: name + "@example.com"})
+	}
+	for _, order := range []struct {
+		customerID int
+		amount     float64
+	}{{1, 10}, {1, 5.5}, {2, 20}} {
+		insert(sales, "orders", store.FieldValue{Name: "customer_id", Value: order.customerID}, store.FieldValue{Name: "amount", Value: order.amount})
+	}
+	insert(other, "orders", store.FieldValue{Name: "customer_id", Value: 3}, store.FieldValue{Name: "amount", Value: 100})
+
+	customersWithOrders := func(opts store.QueryOptions) store.QueryOptions {
+		opts.TableName = "customers"
+		opts.As = "c"
+		opts.Joins = append(opts.Joins, store.Join{
+			Type:      store.JoinLeft,
+			TableName: "orders",
+			As:        "o",
+			On:        []store.JoinOn{{Left: "c.id", Right: "o.customer_id"}},
+		})
+		return opts
+	}
+
+	results, err := sales.Query(context.TODO(), customersWithOrders(store.QueryOptions{
+		IncludeColumns: []string{"c.name", "o.amount"},
+		OrderBy:        []store.SortKey{{Column: "o.id"}},
+		Where:          []store.Predicate{store.NewPredicate("c.name", store.OpNotEqual, "bob")},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the order of carol is not readable, so she is joined to a null
+	if diff := cmp.Diff(results, store.QueryResult{
+		{"c.name": "carol", "o.amount": nil},
+		{"c.name": "alice", "o.amount": float64(10)},
+		{"c.name": "alice", "o.amount": float64(5.5)},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// aggregated in process
+	results, err = sales.Query(context.TODO(), customersWithOrders(store.QueryOptions{
+		Aggregates: []store.Aggregate{{Func: store.AggSum, Column: "o.amount", As: "total"}, {Func: store.AggCount, Column: "o.id", As: "orders"}},
+		GroupBy:    []string{"c.name"},
+		OrderBy:    []store.SortKey{{Column: "c.name"}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{
+		{"c.name": "alice", "total": float64(15.5), "orders": int64(2)},
+		{"c.name": "bob", "total": float64(20), "orders": int64(1)},
+		{"c.name": "carol", "total": nil, "orders": int64(0)},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// a filter on a left joined table applies after the join rather than
+	// to the table beforehand
+	results, err = sales.Query(context.TODO(), customersWithOrders(store.QueryOptions{
+		IncludeColumns: []string{"c.name"},
+		Where:          []store.Predicate{store.NewPredicate("o.id", store.OpIsNull, nil)},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{{"c.name": "carol"}}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	if _, err := sales.Query(context.TODO(), customersWithOrders(store.QueryOptions{
+		IncludeColumns: []string{"c.email", "o.amount"},
+	})); err == nil {
+		t.Fatal("expected read of email to be denied")
+	}
+
+	// the joined tables are read into memory up to the limits
+	for i, limits := range []store.FederationLimits{{MaxRows: 5}, {MaxBytes: 40}} {
+		limited := newTestUser(t, as, store.NewDelegatedStore(as, usf, store.WithFederationLimits(limits)), fmt.Sprintf("limited-user-%d", i),
+			store.PermissionOptions{TableName: "orders", Permission: store.READ_ALL_PERMISSION},
+			store.PermissionOptions{TableName: "customers", Permission: store.READ_ALL_PERMISSION},
+		)
+		_, err := limited.Query(context.TODO(), customersWithOrders(store.QueryOptions{
+			IncludeColumns: []string{"c.name", "o.amount"},
+		}))
+		if !errors.Is(err, store.ErrFederationLimitExceeded) {
+			t.Fatalf("expected %+v to be exceeded, got %v", limits, err)
+		}
+	}
+}
@@ -0,0 +1,47 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+// newTestAdminStore returns an in memory admin store with tables created.
+func newTestAdminStore(t *testing.T, tables ...store.CreateTableOptions) (store.AdminStore, store.UserStoreFactory) {
+	t.Helper()
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, opts := range tables {
+		if err := as.CreateTable(context.TODO(), opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return as, usf
+}
+
+// newTestUser adds a user with perms and returns the store of ds acting as
+// them.
+func newTestUser(t *testing.T, as store.AdminStore, ds store.DelegatedStore, name string, perms ...store.PermissionOptions) store.UserStore {
+	t.Helper()
+	token, err := as.AddUser(context.TODO(), name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	user, err := as.GetUser(context.TODO(), token)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, perm := range perms {
+		perm.UserID = user.ID
+		if err := as.AddPermission(context.TODO(), perm); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return ds.AsUser(context.TODO(), store.UserOptions{Token: token})
+}
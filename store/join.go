@@ -0,0 +1,239 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+)
+
+type JoinType string
+
+const (
+	JoinInner JoinType = "inner"
+	JoinLeft  JoinType = "left"
+)
+
+// Join adds a table of the same store to a query. Once a query has joins
+// every column is referred to as '<alias>.<column>', also in the results.
+type Join struct {
+	// inner when empty
+	Type      JoinType `json:"type"`
+	TableName string   `json:"tableName"`
+	// name the table is referred to by, the table name when empty
+	As string `json:"as"`
+	// columns which have to be equal, referring to this or earlier tables
+	On []JoinOn `json:"on"`
+	// further conditions on the joined rows combined with AND, for a left
+	// join rows which do not match are joined as nulls rather than dropped
+	Where []Predicate `json:"where"`
+}
+
+type JoinOn struct {
+	Left  string `json:"left"`
+	Right string `json:"right"`
+}
+
+// Alias returns the name the joined table is referred to by.
+func (j Join) Alias() string {
+	if j.As != "" {
+		return j.As
+	}
+	return j.TableName
+}
+
+// Alias returns the name the queried table is referred to by in a query
+// with joins.
+func (o QueryOptions) Alias() string {
+	if o.As != "" {
+		return o.As
+	}
+	return o.TableName
+}
+
+// Tables returns the table of every alias of the query.
+func (o QueryOptions) Tables() map[string]string {
+	tables := map[string]string{o.Alias(): o.TableName}
+	for _, join := range o.Joins {
+		tables[join.Alias()] = join.TableName
+	}
+	return tables
+}
+
+// splitReference splits '<alias>.<column>'.
+func splitReference(ref string) (alias, column string, ok bool) {
+	alias, column, ok = strings.Cut(ref, ".")
+	return alias, column, ok && validIdentifier(alias) && validIdentifier(column)
+}
+
+// validReference reports whether ref is a column of the query, plain
+// without joins and qualified by a known alias with them.
+func (o QueryOptions) validReference(ref string) bool {
+	if len(o.Joins) == 0 {
+		return validIdentifier(ref)
+	}
+	alias, _, ok := splitReference(ref)
+	if !ok {
+		return false
+	}
+	_, ok = o.Tables()[alias]
+	return ok
+}
+
+func (o QueryOptions) validateJoins() error {
+	if len(o.Joins) == 0 {
+		return nil
+	}
+	if !validIdentifier(o.Alias()) {
+		return NewInvalidQueryOptions(fmt.Sprintf("invalid table alias '%s'", o.Alias()))
+	}
+
+	aliases := map[string]bool{o.Alias(): true}
+	for _, join := range o.Joins {
+		switch join.Type {
+		case "", JoinInner, JoinLeft:
+		default:
+			return NewInvalidQueryOptions(fmt.Sprintf("invalid join type '%s'", join.Type))
+		}
+		if !validIdentifier(join.TableName) {
+			return NewInvalidQueryOptions(fmt.Sprintf("invalid table name '%s'", join.TableName))
+		}
+		alias := join.Alias()
+		if !validIdentifier(alias) {
+			return NewInvalidQueryOptions(fmt.Sprintf("invalid table alias '%s'", alias))
+		}
+		if aliases[alias] {
+			return NewInvalidQueryOptions(fmt.Sprintf("table alias '%s' is not unique", alias))
+		}
+		aliases[alias] = true
+
+		if len(join.On) == 0 {
+			return NewInvalidQueryOptions(fmt.Sprintf("join of '%s' has no columns to join on", alias))
+		}
+		// sqlite only resolves tables joined before
+		known := func(ref string) bool {
+			alias, _, ok := splitReference(ref)
+			return ok && aliases[alias]
+		}
+		for _, on := range join.On {
+			if !known(on.Left) || !known(on.Right) {
+				return NewInvalidQueryOptions(fmt.Sprintf("invalid join columns '%s' and '%s'", on.Left, on.Right))
+			}
+		}
+		for _, pred := range join.Where {
+			for _, field := range pred.Fields() {
+				if !known(field) {
+					return NewInvalidQueryOptions(fmt.Sprintf("invalid field name '%s'", field))
+				}
+			}
+		}
+		if err := validatePredicates(unqualified(join.Where)); err != nil {
+			return err
+		}
+	}
+
+	for _, col := range o.IncludeColumns {
+		if col == "*" {
+			return NewInvalidQueryOptions("columns of joined tables have to be named")
+		}
+	}
+	return nil
+}
+
+// validatePredicates validates predicates on the columns of the query.
+func (o QueryOptions) validatePredicates(preds []Predicate) error {
+	if len(o.Joins) == 0 {
+		return validatePredicates(preds)
+	}
+	for _, pred := range preds {
+		for _, field := range pred.Fields() {
+			if !o.validReference(field) {
+				return NewInvalidPredicate(fmt.Sprintf("invalid field name '%s'", field))
+			}
+		}
+	}
+	return validatePredicates(unqualified(preds))
+}
+
+// mapFields returns a copy of p with every field replaced by f(field).
+func (p Predicate) mapFields(f func(string) string) Predicate {
+	if p.Field != "" {
+		p.Field = f(p.Field)
+	}
+	for _, children := range []*[]Predicate{&p.And, &p.Or} {
+		if len(*children) == 0 {
+			continue
+		}
+		mapped := make([]Predicate, len(*children))
+		for i, child := range *children {
+			mapped[i] = child.mapFields(f)
+		}
+		*children = mapped
+	}
+	if p.Not != nil {
+		not := p.Not.mapFields(f)
+		p.Not = &not
+	}
+	return p
+}
+
+// unqualified strips the aliases of the fields of preds, leaving predicates
+// which can be validated like those of a single table.
+func unqualified(preds []Predicate) []Predicate {
+	ret := make([]Predicate, len(preds))
+	for i, pred := range preds {
+		ret[i] = pred.mapFields(func(field string) string {
+			if _, column, ok := splitReference(field); ok {
+				return column
+			}
+			return field
+		})
+	}
+	return ret
+}
+
+// qualified prefixes the fields of preds on a single table with alias.
+func qualified(preds []Predicate, alias string) []Predicate {
+	ret := make([]Predicate, len(preds))
+	for i, pred := range preds {
+		ret[i] = pred.mapFields(func(field string) string {
+			return alias + "." + field
+		})
+	}
+	return ret
+}
+
+// references returns every column the query refers to, including those in
+// predicates and join conditions.
+func (o QueryOptions) references() []string {
+	refs := o.Columns()
+	for _, pred := range o.Where {
+		refs = append(refs, pred.Fields()...)
+	}
+	for _, pred := range o.Having {
+		for _, field := range pred.Fields() {
+			if _, ok := o.aggregate(field); !ok {
+				refs = append(refs, field)
+			}
+		}
+	}
+	for _, join := range o.Joins {
+		for _, on := range join.On {
+			refs = append(refs, on.Left, on.Right)
+		}
+		for _, pred := range join.Where {
+			refs = append(refs, pred.Fields()...)
+		}
+	}
+	return refs
+}
+
+// columnsByAlias returns the columns referred to in a query with joins by
+// the alias of their table.
+func (o QueryOptions) columnsByAlias() map[string][]string {
+	ret := make(map[string][]string)
+	for _, ref := range o.references() {
+		if alias, column, ok := splitReference(ref); ok {
+			ret[alias] = append(ret[alias], column)
+		}
+	}
+	return ret
+}
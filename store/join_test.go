@@ -0,0 +1,176 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestDelegatedStoreJoins(t *testing.T) {
+	as, usf := newTestAdminStore(t,
+		store.CreateTableOptions{
+			TableName: "customers",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"name", "text"},
+				{"email", "text"},
+			},
+		},
+		store.CreateTableOptions{
+			TableName: "orders",
+			Definitions: [][]string{
+				{"id", "integer", "not null", "primary key"},
+				{"customer_id", "integer"},
+				{"item", "text"},
+			},
+			ColocateWith: "customers",
+		},
+	)
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	// sales only sees their own orders and not the email of customers
+	sales := newTestUser(t, as, ds, "sales-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.READ_RESTRICTED_PERMISSION},
+		store.PermissionOptions{TableName: "orders", Permission: store.WRITE_ALL_PERMISSION},
+		store.PermissionOptions{TableName: "customers", Permission: store.READ_ALL_PERMISSION, Columns: []string{"id", "name"}},
+		store.PermissionOptions{TableName: "customers", Permission: store.WRITE_ALL_PERMISSION},
+	)
+	other := newTestUser(t, as, ds, "other-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.WRITE_ALL_PERMISSION},
+	)
+	ordersOnly := newTestUser(t, as, ds, "orders-user",
+		store.PermissionOptions{TableName: "orders", Permission: store.READ_ALL_PERMISSION},
+	)
+
+	insert := func(us store.UserStore, table string, values ...store.FieldValue) {
+		t.Helper()
+		_, err := us.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: table,
+			Values:    values,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"alice", "bob", "carol"} {
+		insert(sales, "customers", store.FieldValue{Name: "name", Value: name}, store.FieldValue{Name: "email", Value: name + "@example.com"})
+	}
+	insert(sales, "orders", store.FieldValue{Name: "customer_id", Value: 1}, store.FieldValue{Name: "item", Value: "book"})
+	insert(sales, "orders", store.FieldValue{Name: "customer_id", Value: 2}, store.FieldValue{Name: "item", Value: "pen"})
+	insert(other, "orders", store.FieldValue{Name: "customer_id", Value: 3}, store.FieldValue{Name: "item", Value: "ink"})
+
+	ordersWithCustomers := func(opts store.QueryOptions) store.QueryOptions {
+		opts.TableName = "orders"
+		opts.As = "o"
+		opts.Joins = append(opts.Joins, store.Join{
+			TableName: "customers",
+			As:        "c",
+			On:        []store.JoinOn{{Left: "o.customer_id", Right: "c.id"}},
+		})
+		return opts
+	}
+
+	results, err := sales.Query(context.TODO(), ordersWithCustomers(store.QueryOptions{
+		IncludeColumns: []string{"o.item", "c.name"},
+		OrderBy:        []store.SortKey{{Column: "o.id"}},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{
+		{"o.item": "book", "c.name": "alice"},
+		{"o.item": "pen", "c.name": "bob"},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// the order of carol is not readable, she is joined to nulls rather
+	// than dropped or joined to it
+	results, err = sales.Query(context.TODO(), store.QueryOptions{
+		TableName:      "customers",
+		IncludeColumns: []string{"customers.name", "orders.item"},
+		Joins: []store.Join{{
+			Type:      store.JoinLeft,
+			TableName: "orders",
+			On:        []store.JoinOn{{Left: "customers.id", Right: "orders.customer_id"}},
+		}},
+		OrderBy: []store.SortKey{{Column: "customers.id"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{
+		{"customers.name": "alice", "orders.item": "book"},
+		{"customers.name": "bob", "orders.item": "pen"},
+		{"customers.name": "carol", "orders.item": nil},
+	}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	results, err = sales.Query(context.TODO(), ordersWithCustomers(store.QueryOptions{
+		Aggregates: []store.Aggregate{{Func: store.AggCount}},
+		GroupBy:    []string{"c.name"},
+		Where:      []store.Predicate{store.NewPredicate("c.name", store.OpNotEqual, "bob")},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{{"c.name": "alice", "count": int64(1)}}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	// row policies of joined tables apply to their alias
+	err = as.AddRowPolicy(context.TODO(), store.RowPolicy{
+		TableName: "customers",
+		Name:      "no-alice",
+		Predicate: store.NewPredicate("name", store.OpNotEqual, "alice"),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err = sales.Query(context.TODO(), ordersWithCustomers(store.QueryOptions{
+		IncludeColumns: []string{"o.item", "c.name"},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(results, store.QueryResult{{"o.item": "pen", "c.name": "bob"}}); diff != "" {
+		t.Fatal(diff)
+	}
+
+	for _, tc := range []struct {
+		name string
+		us   store.UserStore
+		opts store.QueryOptions
+	}{
+		{"hidden column", sales, ordersWithCustomers(store.QueryOptions{IncludeColumns: []string{"o.item", "c.email"}})},
+		{"filter on hidden column", sales, ordersWithCustomers(store.QueryOptions{IncludeColumns: []string{"o.item"}, Where: []store.Predicate{store.Eq("c.email", "bob@example.com")}})},
+		{"join on hidden column", sales, store.QueryOptions{
+			TableName:      "orders",
+			IncludeColumns: []string{"orders.item"},
+			Joins:          []store.Join{{TableName: "customers", On: []store.JoinOn{{Left: "orders.item", Right: "customers.email"}}}},
+		}},
+		{"joined table not readable", ordersOnly, ordersWithCustomers(store.QueryOptions{IncludeColumns: []string{"o.item"}})},
+		{"unqualified column", sales, ordersWithCustomers(store.QueryOptions{IncludeColumns: []string{"item"}})},
+		{"unknown alias", sales, ordersWithCustomers(store.QueryOptions{IncludeColumns: []string{"x.item"}})},
+		{"select all", sales, ordersWithCustomers(store.QueryOptions{IncludeColumns: []string{"*"}})},
+		{"no join columns", sales, store.QueryOptions{
+			TableName:      "orders",
+			IncludeColumns: []string{"orders.item"},
+			Joins:          []store.Join{{TableName: "customers"}},
+		}},
+		{"duplicate alias", sales, store.QueryOptions{
+			TableName:      "orders",
+			IncludeColumns: []string{"orders.item"},
+			Joins:          []store.Join{{TableName: "orders", On: []store.JoinOn{{Left: "orders.id", Right: "orders.id"}}}},
+		}},
+	} {
+		if _, err := tc.us.Query(context.TODO(), tc.opts); err == nil {
+			t.Fatalf("%s: expected query to be rejected", tc.name)
+		}
+	}
+}
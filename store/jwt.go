@@ -0,0 +1,384 @@
+package store
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+type JWTOptions struct {
+	// optional path of a JWKS file, its keys are added to Keys
+	JWKSFile string
+	// verification keys by key id, a token without a kid is verified with
+	// the only key when there is just one
+	Keys map[string]crypto.PublicKey
+	// expected iss and aud claims, not checked when empty
+	Issuer   string
+	Audience string
+	// claim holding the group names of the user, 'groups' when empty
+	GroupsClaim string
+	// add users seen for the first time instead of rejecting them
+	AutoProvision bool
+	// clock skew tolerated when checking exp and nbf
+	Leeway time.Duration
+}
+
+// jwtAuthenticator authenticates users with JWTs issued by another party.
+// The sub claim is the name of the user in global_users and the groups
+// claim, when present, decides which groups the user is a member of.
+type jwtAuthenticator struct {
+	as   AdminStore
+	opts JWTOptions
+	keys map[string]crypto.PublicKey
+}
+
+func NewJWTAuthenticator(as AdminStore, opts JWTOptions) (*jwtAuthenticator, error) {
+	keys := make(map[string]crypto.PublicKey)
+	for kid, key := range opts.Keys {
+		keys[kid] = key
+	}
+	if opts.JWKSFile != "" {
+		jwks, err := LoadJWKS(opts.JWKSFile)
+		if err != nil {
+			return nil, err
+		}
+		for kid, key := range jwks {
+			keys[kid] = key
+		}
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("no keys to verify jwts with")
+	}
+	if opts.GroupsClaim == "" {
+		opts.GroupsClaim = "groups"
+	}
+
+	return &jwtAuthenticator{
+		as:   as,
+		opts: opts,
+		keys: keys,
+	}, nil
+}
+
+func (a *jwtAuthenticator) Authenticate(ctx context.Context, token string) (*User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, ErrUnsupportedToken
+	}
+
+	claims, err := a.verify(parts)
+	if err != nil {
+		return nil, err
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return nil, fmt.Errorf("jwt has no subject")
+	}
+
+	var user *User
+	if a.opts.AutoProvision {
+		user, err = a.as.EnsureUser(ctx, subject)
+	} else {
+		user, err = a.as.GetUserByName(ctx, subject)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if user.Disabled {
+		return nil, fmt.Errorf("user '%s' is disabled", user.UserName)
+	}
+
+	if groups, ok := claims[a.opts.GroupsClaim]; ok {
+		names, err := stringsClaim(a.opts.GroupsClaim, groups)
+		if err != nil {
+			return nil, err
+		}
+		if err := a.syncGroups(ctx, user.ID, names); err != nil {
+			return nil, err
+		}
+	}
+
+	return user, nil
+}
+
+// verify checks the signature and the registered claims of a token and
+// returns its claims.
+func (a *jwtAuthenticator) verify(parts []string) (map[string]interface{}, error) {
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	key, ok := a.keys[header.Kid]
+	if !ok && header.Kid == "" && len(a.keys) == 1 {
+		for _, k := range a.keys {
+			key, ok = k, true
+		}
+	}
+	if !ok {
+		return nil, fmt.Errorf("unknown jwt key '%s'", header.Kid)
+	}
+
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed jwt signature")
+	}
+	if err := verifyJWTSignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	now := time.Now()
+	exp, ok, err := numericDateClaim(claims, "exp")
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("jwt has no expiry")
+	}
+	if !now.Before(exp.Add(a.opts.Leeway)) {
+		return nil, fmt.Errorf("jwt is expired")
+	}
+	nbf, ok, err := numericDateClaim(claims, "nbf")
+	if err != nil {
+		return nil, err
+	}
+	if ok && now.Add(a.opts.Leeway).Before(nbf) {
+		return nil, fmt.Errorf("jwt is not valid yet")
+	}
+
+	if a.opts.Issuer != "" && claims["iss"] != a.opts.Issuer {
+		return nil, fmt.Errorf("unexpected jwt issuer")
+	}
+	if a.opts.Audience != "" {
+		aud, err := stringsClaim("aud", claims["aud"])
+		if err != nil {
+			return nil, err
+		}
+		if !slices.Contains(aud, a.opts.Audience) {
+			return nil, fmt.Errorf("unexpected jwt audience")
+		}
+	}
+
+	return claims, nil
+}
+
+// jwtGroupSource marks the group memberships which follow the groups claim.
+const jwtGroupSource = "jwt"
+
+// syncGroups makes the memberships of the user which follow the groups
+// claim exactly the named groups, memberships added by admins are kept.
+// Names of groups which do not exist are ignored as groups are created by
+// admins.
+func (a *jwtAuthenticator) syncGroups(ctx context.Context, userID int64, names []string) error {
+	var groupIDs []int64
+	for _, name := range names {
+		group, err := a.as.GetGroup(ctx, name)
+		if err != nil {
+			continue
+		}
+		groupIDs = append(groupIDs, group.ID)
+	}
+	return a.as.SyncGroupMembers(ctx, userID, jwtGroupSource, groupIDs)
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("malformed jwt")
+	}
+	dec := json.NewDecoder(bytes.NewReader(b))
+	dec.UseNumber()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("malformed jwt")
+	}
+	return nil
+}
+
+func numericDateClaim(claims map[string]interface{}, name string) (time.Time, bool, error) {
+	v, ok := claims[name]
+	if !ok {
+		return time.Time{}, false, nil
+	}
+	n, ok := v.(json.Number)
+	if !ok {
+		return time.Time{}, false, fmt.Errorf("jwt claim '%s' is not a number", name)
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("jwt claim '%s' is not a number", name)
+	}
+	return time.Unix(int64(f), 0), true, nil
+}
+
+// stringsClaim returns a claim which is either a string or a list of
+// strings, such as aud.
+func stringsClaim(name string, v interface{}) ([]string, error) {
+	switch v := v.(type) {
+	case string:
+		return []string{v}, nil
+	case []interface{}:
+		var ret []string
+		for _, item := range v {
+			s, ok := item.(string)
+			if !ok {
+				return nil, fmt.Errorf("jwt claim '%s' is not a list of strings", name)
+			}
+			ret = append(ret, s)
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("jwt claim '%s' is not a list of strings", name)
+}
+
+var jwtHashes = map[string]crypto.Hash{
+	"RS256": crypto.SHA256,
+	"RS384": crypto.SHA384,
+	"RS512": crypto.SHA512,
+	"ES256": crypto.SHA256,
+	"ES384": crypto.SHA384,
+	"ES512": crypto.SHA512,
+}
+
+var jwtCurves = map[string]elliptic.Curve{
+	"ES256": elliptic.P256(),
+	"ES384": elliptic.P384(),
+	"ES512": elliptic.P521(),
+}
+
+// verifyJWTSignature verifies sig over signed, only asymmetric algorithms
+// are supported so that the service never holds a key able to mint tokens.
+func verifyJWTSignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	hash, ok := jwtHashes[alg]
+	if !ok {
+		return fmt.Errorf("unsupported jwt algorithm '%s'", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			break
+		}
+		if err := rsa.VerifyPKCS1v15(key, hash, digest, sig); err != nil {
+			return fmt.Errorf("invalid jwt signature")
+		}
+		return nil
+	case *ecdsa.PublicKey:
+		if jwtCurves[alg] != key.Curve {
+			break
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("invalid jwt signature")
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(key, digest, r, s) {
+			return fmt.Errorf("invalid jwt signature")
+		}
+		return nil
+	}
+	return fmt.Errorf("jwt algorithm '%s' does not match its key", alg)
+}
+
+// LoadJWKS reads the RSA and EC keys of a JWKS file by key id.
+func LoadJWKS(path string) (map[string]crypto.PublicKey, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(b)
+}
+
+// ParseJWKS returns the RSA and EC keys of a JWKS document by key id, keys
+// of other types and keys not meant for signatures are skipped.
+func ParseJWKS(b []byte) (map[string]crypto.PublicKey, error) {
+	var jwks struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(b, &jwks); err != nil {
+		return nil, fmt.Errorf("invalid jwks: %w", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeJWKInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks key '%s': %w", k.Kid, err)
+			}
+			e, err := decodeJWKInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("invalid jwks key '%s': invalid exponent", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+		case "EC":
+			curve, ok := map[string]elliptic.Curve{
+				"P-256": elliptic.P256(),
+				"P-384": elliptic.P384(),
+				"P-521": elliptic.P521(),
+			}[k.Crv]
+			if !ok {
+				return nil, fmt.Errorf("invalid jwks key '%s': unsupported curve '%s'", k.Kid, k.Crv)
+			}
+			x, err := decodeJWKInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks key '%s': %w", k.Kid, err)
+			}
+			y, err := decodeJWKInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("invalid jwks key '%s': %w", k.Kid, err)
+			}
+			if !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("invalid jwks key '%s': point is not on the curve", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+
+	return keys, nil
+}
+
+func decodeJWKInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) == 0 {
+		return nil, fmt.Errorf("invalid key parameter")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,315 @@
+package store_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+// signJWT returns a token over claims signed with key, RS256 for RSA keys
+// and ES256 for EC keys.
+func signJWT(t *testing.T, key crypto.Signer, kid string, claims map[string]interface{}) string {
+	t.Helper()
+
+	alg := "RS256"
+	if _, ok := key.(*ecdsa.PrivateKey); ok {
+		alg = "ES256"
+	}
+	header, err := json.Marshal(map[string]string{"alg": alg, "kid": kid, "typ": "JWT"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	payload, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+
+	var sig []byte
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = append(r.FillBytes(make([]byte, 32)), s.FillBytes(make([]byte, 32))...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func writeJWKS(t *testing.T, kid string, key *rsa.PublicKey) string {
+	t.Helper()
+
+	b, err := json.Marshal(map[string]interface{}{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": kid,
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestJWTAuthenticator(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ja, err := store.NewJWTAuthenticator(as, store.JWTOptions{
+		JWKSFile: writeJWKS(t, "rsa-1", &rsaKey.PublicKey),
+		Keys:     map[string]crypto.PublicKey{"ec-1": &ecKey.PublicKey},
+		Issuer:   "https://idp.example.com",
+		Audience: "store",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	claims := func(sub string, extra map[string]interface{}) map[string]interface{} {
+		c := map[string]interface{}{
+			"sub": sub,
+			"iss": "https://idp.example.com",
+			"aud": []string{"store", "other"},
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		for k, v := range extra {
+			c[k] = v
+		}
+		return c
+	}
+
+	// users are not provisioned unless configured
+	if _, err := ja.Authenticate(context.TODO(), signJWT(t, rsaKey, "rsa-1", claims("alice", nil))); err == nil {
+		t.Fatal("expected unknown user to be rejected")
+	}
+
+	if _, err := as.AddUser(context.TODO(), "alice"); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []struct {
+		signer crypto.Signer
+		kid    string
+	}{{rsaKey, "rsa-1"}, {ecKey, "ec-1"}} {
+		user, err := ja.Authenticate(context.TODO(), signJWT(t, key.signer, key.kid, claims("alice", nil)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if user.UserName != "alice" {
+			t.Fatalf("unexpected user %+v", user)
+		}
+	}
+
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := signJWT(t, rsaKey, "rsa-1", claims("alice", nil))
+	tampered = tampered[:len(tampered)-4] + "AAAA"
+
+	for name, token := range map[string]string{
+		"expired":       signJWT(t, rsaKey, "rsa-1", claims("alice", map[string]interface{}{"exp": time.Now().Add(-time.Minute).Unix()})),
+		"no expiry":     signJWT(t, rsaKey, "rsa-1", claims("alice", map[string]interface{}{"exp": nil})),
+		"not yet valid": signJWT(t, rsaKey, "rsa-1", claims("alice", map[string]interface{}{"nbf": time.Now().Add(time.Hour).Unix()})),
+		"issuer":        signJWT(t, rsaKey, "rsa-1", claims("alice", map[string]interface{}{"iss": "https://evil.example.com"})),
+		"audience":      signJWT(t, rsaKey, "rsa-1", claims("alice", map[string]interface{}{"aud": "other"})),
+		"unknown key":   signJWT(t, otherKey, "rsa-2", claims("alice", nil)),
+		"wrong key":     signJWT(t, otherKey, "rsa-1", claims("alice", nil)),
+		"key mismatch":  signJWT(t, rsaKey, "ec-1", claims("alice", nil)),
+		"tampered":      tampered,
+		"alg none": base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"none","kid":"rsa-1"}`)) + "." +
+			base64.RawURLEncoding.EncodeToString([]byte(`{"sub":"alice","exp":9999999999}`)) + ".",
+	} {
+		if _, err := ja.Authenticate(context.TODO(), token); err == nil {
+			t.Fatalf("expected %s token to be rejected", name)
+		}
+	}
+
+	// api keys are left to the api key authenticator
+	token, err := as.AddUser(context.TODO(), "bob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ja.Authenticate(context.TODO(), token); err != store.ErrUnsupportedToken {
+		t.Fatalf("expected api key to be unsupported, got %v", err)
+	}
+	jwt := signJWT(t, rsaKey, "rsa-1", claims("alice", nil))
+	if _, err := store.NewAPIKeyAuthenticator(as).Authenticate(context.TODO(), jwt); err != store.ErrUnsupportedToken {
+		t.Fatalf("expected jwt to be unsupported, got %v", err)
+	}
+	// either order works, each passes on the tokens of the other
+	for _, chain := range []store.Authenticator{
+		store.NewChainAuthenticator(ja, store.NewAPIKeyAuthenticator(as)),
+		store.NewChainAuthenticator(store.NewAPIKeyAuthenticator(as), ja),
+	} {
+		for _, token := range []string{token, jwt} {
+			if _, err := chain.Authenticate(context.TODO(), token); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+func TestJWTAuthenticatorProvisioning(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ja, err := store.NewJWTAuthenticator(as, store.JWTOptions{
+		Keys:          map[string]crypto.PublicKey{"": &key.PublicKey},
+		AutoProvision: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"analysts", "admins"} {
+		if err := as.AddGroup(context.TODO(), name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	authenticate := func(groups ...interface{}) *store.User {
+		t.Helper()
+		claims := map[string]interface{}{
+			"sub": "carol",
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+		if groups != nil {
+			claims["groups"] = groups
+		}
+		user, err := ja.Authenticate(context.TODO(), signJWT(t, key, "", claims))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return user
+	}
+	groupNames := func(userID int64) []string {
+		t.Helper()
+		groups, err := as.GetUserGroups(context.TODO(), userID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var ret []string
+		for _, g := range groups {
+			ret = append(ret, g.Name)
+		}
+		return ret
+	}
+
+	// the user is provisioned on first sight, unknown groups are ignored
+	user := authenticate("analysts", "unknown")
+	if _, err := as.GetUserByName(context.TODO(), "carol"); err != nil {
+		t.Fatal(err)
+	}
+	if names := groupNames(user.ID); len(names) != 1 || names[0] != "analysts" {
+		t.Fatalf("unexpected groups %v", names)
+	}
+	// provisioned users do not get an api key
+	keys, err := as.GetAPIKeys(context.TODO(), user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 0 {
+		t.Fatalf("unexpected keys %+v", keys)
+	}
+
+	// memberships follow the groups claim
+	if again := authenticate("admins"); again.ID != user.ID {
+		t.Fatalf("expected the same user, got %+v", again)
+	}
+	if names := groupNames(user.ID); len(names) != 1 || names[0] != "admins" {
+		t.Fatalf("unexpected groups %v", names)
+	}
+	// and are left alone when the claim is missing
+	authenticate()
+	if names := groupNames(user.ID); len(names) != 1 || names[0] != "admins" {
+		t.Fatalf("unexpected groups %v", names)
+	}
+
+	// memberships added by admins are kept whatever the claim says
+	analysts, err := as.GetGroup(context.TODO(), "analysts")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := as.AddGroupMember(context.TODO(), analysts.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	authenticate("unknown")
+	if names := groupNames(user.ID); len(names) != 1 || names[0] != "analysts" {
+		t.Fatalf("unexpected groups %v", names)
+	}
+	// including ones the claim named before an admin added them
+	authenticate("admins")
+	admins, err := as.GetGroup(context.TODO(), "admins")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := as.AddGroupMember(context.TODO(), admins.ID, user.ID); err != nil {
+		t.Fatal(err)
+	}
+	authenticate("unknown")
+	if names := groupNames(user.ID); len(names) != 2 {
+		t.Fatalf("unexpected groups %v", names)
+	}
+
+	if err := as.SetUserDisabled(context.TODO(), user.ID, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ja.Authenticate(context.TODO(), signJWT(t, key, "", map[string]interface{}{
+		"sub": "carol",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	})); err == nil {
+		t.Fatal("expected disabled user to be rejected")
+	}
+}
@@ -0,0 +1,405 @@
+package store
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/huandu/go-sqlbuilder"
+)
+
+type Operator string
+
+const (
+	OpEqual        Operator = "eq"
+	OpNotEqual     Operator = "ne"
+	OpLessThan     Operator = "lt"
+	OpLessEqual    Operator = "lte"
+	OpGreaterThan  Operator = "gt"
+	OpGreaterEqual Operator = "gte"
+	OpLike         Operator = "like"
+	OpIn           Operator = "in"
+	OpNotIn        Operator = "nin"
+	OpIsNull       Operator = "isnull"
+	OpIsNotNull    Operator = "notnull"
+)
+
+// Predicate is a node in a structured filter tree. A node is either a leaf
+// comparing Field against Value with Op, or a compound node combining its
+// children with And, Or or Not. Values are always sent to the database as
+// bind parameters and never formatted into the query text.
+type Predicate struct {
+	Field string      `json:"field,omitempty"`
+	Op    Operator    `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+
+	And []Predicate `json:"and,omitempty"`
+	Or  []Predicate `json:"or,omitempty"`
+	Not *Predicate  `json:"not,omitempty"`
+}
+
+func NewPredicate(field string, op Operator, value interface{}) Predicate {
+	return Predicate{Field: field, Op: op, Value: value}
+}
+
+func Eq(field string, value interface{}) Predicate {
+	return NewPredicate(field, OpEqual, value)
+}
+
+func And(preds ...Predicate) Predicate {
+	return Predicate{And: preds}
+}
+
+func Or(preds ...Predicate) Predicate {
+	return Predicate{Or: preds}
+}
+
+func Not(pred Predicate) Predicate {
+	return Predicate{Not: &pred}
+}
+
+// UnmarshalJSON accepts either the structured object form or the legacy
+// string form ("name = 'test'"), which is parsed with ParsePredicate.
+func (p *Predicate) UnmarshalJSON(data []byte) error {
+	var legacy string
+	if err := json.Unmarshal(data, &legacy); err == nil {
+		parsed, err := ParsePredicate(legacy)
+		if err != nil {
+			return err
+		}
+		*p = parsed
+		return nil
+	}
+
+	// alias to avoid recursing into this method
+	type predicate Predicate
+	var pred predicate
+	if err := json.Unmarshal(data, &pred); err != nil {
+		return err
+	}
+	*p = Predicate(pred)
+	return nil
+}
+
+func (p Predicate) Validate() error {
+	var kinds int
+	if p.Field != "" || p.Op != "" {
+		kinds++
+	}
+	if len(p.And) > 0 {
+		kinds++
+	}
+	if len(p.Or) > 0 {
+		kinds++
+	}
+	if p.Not != nil {
+		kinds++
+	}
+	if kinds != 1 {
+		return NewInvalidPredicate("predicate must have exactly one of field/op, and, or, not")
+	}
+
+	for _, children := range [][]Predicate{p.And, p.Or} {
+		for _, child := range children {
+			if err := child.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+	if p.Not != nil {
+		return p.Not.Validate()
+	}
+	if len(p.And) > 0 || len(p.Or) > 0 {
+		return nil
+	}
+
+	if !validIdentifier(p.Field) {
+		return NewInvalidPredicate(fmt.Sprintf("invalid field name '%s'", p.Field))
+	}
+
+	switch p.Op {
+	case OpEqual, OpNotEqual, OpLessThan, OpLessEqual, OpGreaterThan, OpGreaterEqual, OpLike:
+		if p.Value == nil {
+			return NewInvalidPredicate(fmt.Sprintf("operator '%s' on '%s' requires a value, use '%s' to match null", p.Op, p.Field, OpIsNull))
+		}
+		if !isScalar(p.Value) {
+			return NewInvalidPredicate(fmt.Sprintf("operator '%s' on '%s' requires a scalar value", p.Op, p.Field))
+		}
+	case OpIn, OpNotIn:
+		values, ok := listValues(p.Value)
+		if !ok || len(values) == 0 {
+			return NewInvalidPredicate(fmt.Sprintf("operator '%s' on '%s' requires a non empty list", p.Op, p.Field))
+		}
+		for _, v := range values {
+			if v == nil || !isScalar(v) {
+				return NewInvalidPredicate(fmt.Sprintf("operator '%s' on '%s' requires a list of scalar values", p.Op, p.Field))
+			}
+		}
+	case OpIsNull, OpIsNotNull:
+		if p.Value != nil {
+			return NewInvalidPredicate(fmt.Sprintf("operator '%s' on '%s' does not take a value", p.Op, p.Field))
+		}
+	default:
+		return NewInvalidPredicate(fmt.Sprintf("invalid operator '%s'", p.Op))
+	}
+
+	return nil
+}
+
+// Fields returns every field referenced by the predicate tree.
+func (p Predicate) Fields() []string {
+	var fields []string
+	if p.Field != "" {
+		fields = append(fields, p.Field)
+	}
+	for _, children := range [][]Predicate{p.And, p.Or} {
+		for _, child := range children {
+			fields = append(fields, child.Fields()...)
+		}
+	}
+	if p.Not != nil {
+		fields = append(fields, p.Not.Fields()...)
+	}
+	return fields
+}
+
+// build compiles a validated predicate into an expression, registering the
+// values as arguments on cond.
+func (p Predicate) build(cond *sqlbuilder.Cond) string {
+	switch {
+	case len(p.And) > 0:
+		return cond.And(buildPredicates(cond, p.And)...)
+	case len(p.Or) > 0:
+		return cond.Or(buildPredicates(cond, p.Or)...)
+	case p.Not != nil:
+		return "NOT (" + p.Not.build(cond) + ")"
+	}
+
+	switch p.Op {
+	case OpEqual:
+		return cond.Equal(p.Field, p.Value)
+	case OpNotEqual:
+		return cond.NotEqual(p.Field, p.Value)
+	case OpLessThan:
+		return cond.LessThan(p.Field, p.Value)
+	case OpLessEqual:
+		return cond.LessEqualThan(p.Field, p.Value)
+	case OpGreaterThan:
+		return cond.GreaterThan(p.Field, p.Value)
+	case OpGreaterEqual:
+		return cond.GreaterEqualThan(p.Field, p.Value)
+	case OpLike:
+		return cond.Like(p.Field, p.Value)
+	case OpIn:
+		values, _ := listValues(p.Value)
+		return cond.In(p.Field, values...)
+	case OpNotIn:
+		values, _ := listValues(p.Value)
+		return cond.NotIn(p.Field, values...)
+	case OpIsNull:
+		return cond.IsNull(p.Field)
+	case OpIsNotNull:
+		return cond.IsNotNull(p.Field)
+	}
+
+	// unreachable for validated predicates, match nothing to fail closed
+	return "0"
+}
+
+func buildPredicates(cond *sqlbuilder.Cond, preds []Predicate) []string {
+	exprs := make([]string, 0, len(preds))
+	for _, pred := range preds {
+		exprs = append(exprs, pred.build(cond))
+	}
+	return exprs
+}
+
+func validatePredicates(preds []Predicate) error {
+	for _, pred := range preds {
+		if err := pred.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func isScalar(v interface{}) bool {
+	if v == nil {
+		return true
+	}
+	switch reflect.TypeOf(v).Kind() {
+	case reflect.Slice, reflect.Array, reflect.Map, reflect.Struct, reflect.Pointer, reflect.Func, reflect.Chan, reflect.Interface:
+		// blobs and timestamps are understood by the driver
+		switch v.(type) {
+		case []byte, time.Time:
+			return true
+		}
+		return false
+	}
+	return true
+}
+
+func listValues(v interface{}) ([]interface{}, bool) {
+	if v == nil {
+		return nil, false
+	}
+	if values, ok := v.([]interface{}); ok {
+		return values, true
+	}
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+		return nil, false
+	}
+	if _, ok := v.([]byte); ok {
+		return nil, false
+	}
+	values := make([]interface{}, rv.Len())
+	for i := range values {
+		values[i] = rv.Index(i).Interface()
+	}
+	return values, true
+}
+
+var legacyOperators = map[string]Operator{
+	"=":    OpEqual,
+	"==":   OpEqual,
+	"!=":   OpNotEqual,
+	"<>":   OpNotEqual,
+	"<":    OpLessThan,
+	"<=":   OpLessEqual,
+	">":    OpGreaterThan,
+	">=":   OpGreaterEqual,
+	"LIKE": OpLike,
+}
+
+// ParsePredicate parses the legacy string form of a predicate. Only a single
+// comparison of the form "<column> <op> <literal>" or "<column> IS [NOT] NULL"
+// is accepted, where literal is a single quoted string or a number. Anything
+// else, including boolean connectives and parentheses, is rejected.
+func ParsePredicate(s string) (Predicate, error) {
+	tokens, err := tokenizePredicate(s)
+	if err != nil {
+		return Predicate{}, err
+	}
+
+	if len(tokens) < 3 || tokens[0].kind != tokenIdentifier {
+		return Predicate{}, NewInvalidPredicate(fmt.Sprintf("unsupported predicate '%s'", s))
+	}
+	field := tokens[0].text
+
+	if strings.EqualFold(tokens[1].text, "IS") && tokens[1].kind == tokenIdentifier {
+		switch {
+		case len(tokens) == 3 && strings.EqualFold(tokens[2].text, "NULL"):
+			return NewPredicate(field, OpIsNull, nil), nil
+		case len(tokens) == 4 && strings.EqualFold(tokens[2].text, "NOT") && strings.EqualFold(tokens[3].text, "NULL"):
+			return NewPredicate(field, OpIsNotNull, nil), nil
+		}
+		return Predicate{}, NewInvalidPredicate(fmt.Sprintf("unsupported predicate '%s'", s))
+	}
+
+	op, ok := legacyOperators[strings.ToUpper(tokens[1].text)]
+	if !ok || len(tokens) != 3 {
+		return Predicate{}, NewInvalidPredicate(fmt.Sprintf("unsupported predicate '%s'", s))
+	}
+
+	var value interface{}
+	switch tokens[2].kind {
+	case tokenString:
+		value = tokens[2].text
+	case tokenNumber:
+		if i, err := strconv.ParseInt(tokens[2].text, 10, 64); err == nil {
+			value = i
+		} else if f, err := strconv.ParseFloat(tokens[2].text, 64); err == nil {
+			value = f
+		} else {
+			return Predicate{}, NewInvalidPredicate(fmt.Sprintf("invalid number '%s'", tokens[2].text))
+		}
+	default:
+		return Predicate{}, NewInvalidPredicate(fmt.Sprintf("unsupported predicate '%s'", s))
+	}
+
+	pred := NewPredicate(field, op, value)
+	if err := pred.Validate(); err != nil {
+		return Predicate{}, err
+	}
+	return pred, nil
+}
+
+type tokenKind int
+
+const (
+	tokenIdentifier tokenKind = iota
+	tokenOperator
+	tokenString
+	tokenNumber
+	// only produced by tokenizeSQL
+	tokenPunctuation
+	tokenParameter
+	tokenEnd
+)
+
+type token struct {
+	kind tokenKind
+	text string
+}
+
+func tokenizePredicate(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			j := i
+			for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[i:j])})
+			i = j
+		case unicode.IsDigit(r) || ((r == '-' || r == '.') && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == 'e' || runes[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:j])})
+			i = j
+		case r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(runes) {
+					return nil, NewInvalidPredicate("unterminated string literal")
+				}
+				if runes[j] == '\'' {
+					// '' is an escaped quote
+					if j+1 < len(runes) && runes[j+1] == '\'' {
+						sb.WriteRune('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteRune(runes[j])
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: sb.String()})
+			i = j + 1
+		case strings.ContainsRune("=!<>", r):
+			j := i + 1
+			if j < len(runes) && strings.ContainsRune("=>", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: string(runes[i:j])})
+			i = j
+		default:
+			return nil, NewInvalidPredicate(fmt.Sprintf("unexpected character '%c'", r))
+		}
+	}
+	return tokens, nil
+}
@@ -0,0 +1,168 @@
+package store_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestParsePredicate(t *testing.T) {
+	valid := map[string]store.Predicate{
+		"name = 'test'":      store.Eq("name", "test"),
+		"name = 'it''s'":     store.Eq("name", "it's"),
+		"id >= 10":           store.NewPredicate("id", store.OpGreaterEqual, int64(10)),
+		"price < 1.5":        store.NewPredicate("price", store.OpLessThan, 1.5),
+		"name <> 'x'":        store.NewPredicate("name", store.OpNotEqual, "x"),
+		"name like 'te%'":    store.NewPredicate("name", store.OpLike, "te%"),
+		"name IS NULL":       store.NewPredicate("name", store.OpIsNull, nil),
+		"name is not null":   store.NewPredicate("name", store.OpIsNotNull, nil),
+		"  created_by = -1 ": store.Eq("created_by", int64(-1)),
+	}
+	for s, want := range valid {
+		got, err := store.ParsePredicate(s)
+		if err != nil {
+			t.Fatalf("%s: %v", s, err)
+		}
+		if diff := cmp.Diff(want, got); diff != "" {
+			t.Fatalf("%s: %s", s, diff)
+		}
+	}
+
+	invalid := []string{
+		"1=1) OR (1=1",
+		"name = 'a' OR 1=1",
+		"name = 'a'; drop table foo",
+		"name = other_column",
+		"name = 'unterminated",
+		"name",
+		"name = ",
+		"lower(name) = 'a'",
+	}
+	for _, s := range invalid {
+		_, err := store.ParsePredicate(s)
+		if !errors.Is(err, store.ErrInvalidPredicate) {
+			t.Fatalf("%s: expected invalid predicate, got %v", s, err)
+		}
+	}
+}
+
+func TestPredicateJSON(t *testing.T) {
+	var opts store.QueryOptions
+	err := json.Unmarshal([]byte(`{
+		"tableName": "foo",
+		"includeColumns": ["id"],
+		"where": [
+			"name = 'test'",
+			{"or": [{"field": "id", "op": "in", "value": [1, 2]}, {"not": {"field": "name", "op": "isnull"}}]}
+		]
+	}`), &opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.Validate(); err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff([]store.Predicate{
+		store.Eq("name", "test"),
+		store.Or(
+			store.NewPredicate("id", store.OpIn, []interface{}{1.0, 2.0}),
+			store.Not(store.NewPredicate("name", store.OpIsNull, nil)),
+		),
+	}, opts.Where); diff != "" {
+		t.Fatal(diff)
+	}
+
+	err = json.Unmarshal([]byte(`{"where": ["1=1) OR (1=1"]}`), &opts)
+	if !errors.Is(err, store.ErrInvalidPredicate) {
+		t.Fatalf("expected invalid predicate, got %v", err)
+	}
+}
+
+func TestPredicateValidate(t *testing.T) {
+	invalid := []store.Predicate{
+		{},
+		store.NewPredicate("name) OR (1", store.OpEqual, 1),
+		store.NewPredicate("name", "regexp", "a"),
+		store.NewPredicate("name", store.OpEqual, nil),
+		store.NewPredicate("name", store.OpEqual, []int{1}),
+		store.NewPredicate("name", store.OpIn, 1),
+		store.NewPredicate("name", store.OpIn, []int{}),
+		store.NewPredicate("name", store.OpIsNull, 1),
+		{Field: "name", Op: store.OpEqual, Value: 1, Or: []store.Predicate{store.Eq("id", 1)}},
+		store.And(store.Eq("id", 1), store.NewPredicate("id", "", 1)),
+	}
+	for _, pred := range invalid {
+		if err := pred.Validate(); !errors.Is(err, store.ErrInvalidPredicate) {
+			t.Fatalf("%+v: expected invalid predicate, got %v", pred, err)
+		}
+	}
+}
+
+func TestSQLite3StorePredicates(t *testing.T) {
+	s, err := store.NewSQLite3Store(":memory:", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = s.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "foo",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"name", "text"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []interface{}{"a", "b", "c", "it's", nil} {
+		_, err := s.Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "foo",
+			Values:    []store.FieldValue{{Name: "name", Value: name}},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	count := func(where ...store.Predicate) int {
+		t.Helper()
+		res, err := s.Query(context.TODO(), store.QueryOptions{
+			TableName:      "foo",
+			IncludeColumns: []string{"id", "name"},
+			Where:          where,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(res)
+	}
+
+	cases := []struct {
+		where []store.Predicate
+		want  int
+	}{
+		{nil, 5},
+		{[]store.Predicate{store.Eq("name", "a")}, 1},
+		{[]store.Predicate{store.Eq("name", "it's")}, 1},
+		{[]store.Predicate{store.Eq("name", "a' OR '1'='1")}, 0},
+		{[]store.Predicate{store.Or(store.Eq("name", "a"), store.Eq("name", "b"))}, 2},
+		{[]store.Predicate{store.Or(store.Eq("name", "a"), store.Eq("name", "b")), store.Eq("id", 1)}, 1},
+		{[]store.Predicate{store.NewPredicate("name", store.OpIn, []string{"a", "b", "c"})}, 3},
+		{[]store.Predicate{store.Not(store.NewPredicate("name", store.OpIn, []string{"a", "b"}))}, 2},
+		{[]store.Predicate{store.NewPredicate("name", store.OpIsNull, nil)}, 1},
+		{[]store.Predicate{store.And(store.NewPredicate("id", store.OpGreaterThan, 1), store.NewPredicate("id", store.OpLessEqual, 3))}, 2},
+		{[]store.Predicate{store.NewPredicate("name", store.OpLike, "it%")}, 1},
+	}
+	for i, c := range cases {
+		if got := count(c.where...); got != c.want {
+			t.Fatalf("case %d: expected %d rows, got %d", i, c.want, got)
+		}
+	}
+}
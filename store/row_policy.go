@@ -0,0 +1,362 @@
+package store
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// RowPolicy is a named filter attached to a table by an admin. The predicate
+// of every policy on a table is added to each query, update and delete on
+// that table, and the rows an insert or update writes have to satisfy it as
+// well. String values of the form "$user.<attribute>" are replaced by
+// the attributes of the user performing the request, "$user.id" and
+// "$user.name" are always available.
+type RowPolicy struct {
+	TableName string    `json:"tableName"`
+	Name      string    `json:"name"`
+	Predicate Predicate `json:"predicate"`
+}
+
+func (p RowPolicy) Validate() error {
+	if p.TableName == "" {
+		return fmt.Errorf("table name is empty")
+	}
+	if p.Name == "" {
+		return fmt.Errorf("policy name is empty")
+	}
+
+	// resolve every reference to a placeholder so that the shape of the
+	// predicate can be validated without a user
+	resolved, err := resolvePredicate(p.Predicate, func(string) ([]interface{}, error) {
+		return []interface{}{""}, nil
+	})
+	if err != nil {
+		return err
+	}
+
+	return resolved.Validate()
+}
+
+const userAttributePrefix = "$user."
+
+// userAttributeLookup returns the values of a user attribute.
+type userAttributeLookup func(name string) ([]interface{}, error)
+
+func newUserAttributeLookup(user *User, attrs map[string][]interface{}) userAttributeLookup {
+	return func(name string) ([]interface{}, error) {
+		switch name {
+		case "id":
+			return []interface{}{user.ID}, nil
+		case "name":
+			return []interface{}{user.UserName}, nil
+		}
+		values, ok := attrs[name]
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("attribute '%s' not set for user '%s'", name, user.UserName)
+		}
+		return values, nil
+	}
+}
+
+func attributeReference(v interface{}) (string, bool) {
+	s, ok := v.(string)
+	if !ok || !strings.HasPrefix(s, userAttributePrefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(s, userAttributePrefix), true
+}
+
+// resolvePredicate returns a copy of the predicate with every attribute
+// reference replaced by its values. A reference used with a scalar operator
+// must resolve to exactly one value, a reference used with 'in'/'nin' may
+// resolve to many, either directly or as an element of a list.
+func resolvePredicate(p Predicate, lookup userAttributeLookup) (Predicate, error) {
+	resolveAll := func(preds []Predicate) ([]Predicate, error) {
+		if preds == nil {
+			return nil, nil
+		}
+		ret := make([]Predicate, 0, len(preds))
+		for _, pred := range preds {
+			resolved, err := resolvePredicate(pred, lookup)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, resolved)
+		}
+		return ret, nil
+	}
+
+	var err error
+	ret := p
+	if ret.And, err = resolveAll(p.And); err != nil {
+		return Predicate{}, err
+	}
+	if ret.Or, err = resolveAll(p.Or); err != nil {
+		return Predicate{}, err
+	}
+	if p.Not != nil {
+		not, err := resolvePredicate(*p.Not, lookup)
+		if err != nil {
+			return Predicate{}, err
+		}
+		ret.Not = &not
+	}
+
+	switch p.Op {
+	case OpIn, OpNotIn:
+		if name, ok := attributeReference(p.Value); ok {
+			values, err := lookup(name)
+			if err != nil {
+				return Predicate{}, err
+			}
+			ret.Value = values
+			break
+		}
+		if list, ok := listValues(p.Value); ok {
+			var values []interface{}
+			for _, v := range list {
+				name, ok := attributeReference(v)
+				if !ok {
+					values = append(values, v)
+					continue
+				}
+				resolved, err := lookup(name)
+				if err != nil {
+					return Predicate{}, err
+				}
+				values = append(values, resolved...)
+			}
+			ret.Value = values
+		}
+	default:
+		if name, ok := attributeReference(p.Value); ok {
+			values, err := lookup(name)
+			if err != nil {
+				return Predicate{}, err
+			}
+			if len(values) != 1 {
+				return Predicate{}, fmt.Errorf("attribute '%s' has %d values, operator '%s' needs exactly one", name, len(values), p.Op)
+			}
+			ret.Value = values[0]
+		}
+	}
+
+	return ret, nil
+}
+
+// truth is the outcome of evaluating a predicate against the values written
+// to a row, sql's three valued logic plus open for a predicate which also
+// depends on columns that are not written.
+type truth int
+
+const (
+	truthFalse truth = iota
+	truthTrue
+	truthNull
+	truthOpen
+)
+
+// check evaluates the predicate against the values a write sets, as far as
+// they go. Comparisons of columns in values are decided, the rest of the
+// predicate is returned as is along with truthOpen. Combining a null with an
+// open part is reported as null, which fails the check, rather than tracked
+// through negations.
+func (p Predicate) check(values map[string]interface{}) (Predicate, truth) {
+	switch {
+	case len(p.And) > 0 || len(p.Or) > 0:
+		and := len(p.And) > 0
+		children, short, rest := p.And, truthFalse, truthTrue
+		if !and {
+			children, short, rest = p.Or, truthTrue, truthFalse
+		}
+		var open []Predicate
+		var null bool
+		for _, child := range children {
+			residual, t := child.check(values)
+			switch t {
+			case short:
+				return Predicate{}, short
+			case truthNull:
+				null = true
+			case truthOpen:
+				open = append(open, residual)
+			}
+		}
+		switch {
+		case null:
+			return Predicate{}, truthNull
+		case len(open) == 0:
+			return Predicate{}, rest
+		case len(open) == 1:
+			return open[0], truthOpen
+		case and:
+			return And(open...), truthOpen
+		}
+		return Or(open...), truthOpen
+	case p.Not != nil:
+		residual, t := p.Not.check(values)
+		switch t {
+		case truthTrue:
+			return Predicate{}, truthFalse
+		case truthFalse:
+			return Predicate{}, truthTrue
+		case truthOpen:
+			return Not(residual), truthOpen
+		}
+		return Predicate{}, truthNull
+	}
+
+	v, ok := values[p.Field]
+	if !ok {
+		return p, truthOpen
+	}
+	switch p.Op {
+	case OpIsNull:
+		return Predicate{}, boolTruth(v == nil)
+	case OpIsNotNull:
+		return Predicate{}, boolTruth(v != nil)
+	}
+	if v == nil {
+		return Predicate{}, truthNull
+	}
+
+	switch p.Op {
+	case OpIn, OpNotIn:
+		list, _ := listValues(p.Value)
+		found := truthFalse
+		for _, item := range list {
+			c, ok := compareValues(v, item)
+			if !ok {
+				return Predicate{}, truthNull
+			}
+			if c == 0 {
+				found = truthTrue
+			}
+		}
+		if p.Op == OpNotIn {
+			return Predicate{}, boolTruth(found == truthFalse)
+		}
+		return Predicate{}, found
+	case OpLike:
+		s, ok1 := v.(string)
+		pattern, ok2 := p.Value.(string)
+		if !ok1 || !ok2 {
+			return Predicate{}, truthNull
+		}
+		return Predicate{}, boolTruth(likeMatch([]rune(s), []rune(pattern)))
+	}
+
+	c, ok := compareValues(v, p.Value)
+	if !ok {
+		return Predicate{}, truthNull
+	}
+	switch p.Op {
+	case OpEqual:
+		return Predicate{}, boolTruth(c == 0)
+	case OpNotEqual:
+		return Predicate{}, boolTruth(c != 0)
+	case OpLessThan:
+		return Predicate{}, boolTruth(c < 0)
+	case OpLessEqual:
+		return Predicate{}, boolTruth(c <= 0)
+	case OpGreaterThan:
+		return Predicate{}, boolTruth(c > 0)
+	case OpGreaterEqual:
+		return Predicate{}, boolTruth(c >= 0)
+	}
+	return Predicate{}, truthNull
+}
+
+func boolTruth(b bool) truth {
+	if b {
+		return truthTrue
+	}
+	return truthFalse
+}
+
+// compareValues compares two non null values the way sqlite compares them
+// when neither side has a column affinity to convert to. Values of different
+// kinds, a number and a string say, are reported as not comparable rather
+// than ordered, so that a check fails where sqlite could convert one of them.
+func compareValues(a, b interface{}) (int, bool) {
+	if x, ok := numericValue(a); ok {
+		y, ok := numericValue(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		if y, ok := b.(string); ok {
+			return strings.Compare(x, y), true
+		}
+	case []byte:
+		if y, ok := b.([]byte); ok {
+			return bytes.Compare(x, y), true
+		}
+	case time.Time:
+		if y, ok := b.(time.Time); ok {
+			return x.Compare(y), true
+		}
+	}
+	return 0, false
+}
+
+func numericValue(v interface{}) (float64, bool) {
+	rv := reflect.ValueOf(v)
+	switch rv.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(rv.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(rv.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return rv.Float(), true
+	case reflect.Bool:
+		if rv.Bool() {
+			return 1, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+// likeMatch matches like sqlite's LIKE, '%' matches any run of characters,
+// '_' any single one and ascii letters match regardless of case.
+func likeMatch(s, pattern []rune) bool {
+	fold := func(r rune) rune {
+		if r >= 'A' && r <= 'Z' {
+			return r + 'a' - 'A'
+		}
+		return r
+	}
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '%':
+			for i := 0; i <= len(s); i++ {
+				if likeMatch(s[i:], pattern[1:]) {
+					return true
+				}
+			}
+			return false
+		case '_':
+			if len(s) == 0 {
+				return false
+			}
+		default:
+			if len(s) == 0 || fold(s[0]) != fold(pattern[0]) {
+				return false
+			}
+		}
+		s, pattern = s[1:], pattern[1:]
+	}
+	return len(s) == 0
+}
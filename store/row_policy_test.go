@@ -0,0 +1,291 @@
+package store_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestRowPolicies(t *testing.T) {
+	dataSource := ":memory:"
+	usf := store.NewUserStoreFactory()
+
+	as, err := store.NewAdminStore(context.TODO(), dataSource, dataSource, usf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = as.CreateTable(context.TODO(), store.CreateTableOptions{
+		TableName: "accounts",
+		Definitions: [][]string{
+			{"id", "integer", "not null", "primary key"},
+			{"region", "text"},
+			{"tenant_id", "integer"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ds := store.NewDelegatedStore(as, usf)
+
+	// loader writes all rows, there are no policies yet
+	loaderToken, err := as.AddUser(context.TODO(), "loader")
+	if err != nil {
+		t.Fatal(err)
+	}
+	loader, err := as.GetUser(context.TODO(), loaderToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = as.AddPermission(context.TODO(), store.PermissionOptions{
+		UserID:     loader.ID,
+		TableName:  "accounts",
+		Permission: store.WRITE_ALL_PERMISSION,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows := []struct {
+		region string
+		tenant int
+	}{
+		{"eu", 1}, {"eu", 2}, {"eu", 3}, {"us", 1}, {"us", 2},
+	}
+	for _, row := range rows {
+		_, err := ds.AsUser(context.TODO(), store.UserOptions{
+			Token: loaderToken,
+		}).Exec(context.TODO(), store.ExecOptions{
+			Type:      store.ExecTypeInsert,
+			TableName: "accounts",
+			Values: []store.FieldValue{
+				{Name: "region", Value: row.region},
+				{Name: "tenant_id", Value: row.tenant},
+			},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	analystToken, err := as.AddUser(context.TODO(), "analyst")
+	if err != nil {
+		t.Fatal(err)
+	}
+	analyst, err := as.GetUser(context.TODO(), analystToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, perm := range []string{store.READ_ALL_PERMISSION, store.WRITE_ALL_PERMISSION} {
+		err = as.AddPermission(context.TODO(), store.PermissionOptions{
+			UserID:     analyst.ID,
+			TableName:  "accounts",
+			Permission: perm,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, policy := range []store.RowPolicy{
+		{
+			TableName: "accounts",
+			Name:      "region",
+			Predicate: store.Eq("region", "$user.region"),
+		},
+		{
+			TableName: "accounts",
+			Name:      "tenants",
+			Predicate: store.NewPredicate("tenant_id", store.OpIn, "$user.tenants"),
+		},
+	} {
+		if err := as.AddRowPolicy(context.TODO(), policy); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	us := ds.AsUser(context.TODO(), store.UserOptions{
+		Token: analystToken,
+	})
+	query := func() (store.QueryResult, error) {
+		return us.Query(context.TODO(), store.QueryOptions{
+			TableName:      "accounts",
+			IncludeColumns: []string{"id", "region", "tenant_id"},
+		})
+	}
+
+	// missing attributes fail closed
+	if _, err := query(); err == nil {
+		t.Fatal("expected query without user attributes to fail")
+	}
+
+	if err := as.SetUserAttribute(context.TODO(), analyst.ID, "region", []interface{}{"eu"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.SetUserAttribute(context.TODO(), analyst.ID, "tenants", []interface{}{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 {
+		t.Fatalf("expected 2 rows, got %v", results)
+	}
+	for _, rec := range results {
+		if rec["region"] != "eu" || rec["tenant_id"] == int64(3) {
+			t.Fatalf("unexpected row %v", rec)
+		}
+	}
+
+	// updates are filtered by the policies as well
+	res, err := us.Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeUpdate,
+		TableName: "accounts",
+		Values:    []store.FieldValue{{Name: "tenant_id", Value: 2}},
+		Where:     []store.Predicate{store.NewPredicate("id", store.OpGreaterThan, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 2 {
+		t.Fatalf("expected 2 rows to be updated, got %d", res.RowsAffected)
+	}
+
+	// rows cannot be written outside of the policies, nor handed to
+	// another user
+	for _, tc := range []struct {
+		name string
+		opts store.ExecOptions
+	}{
+		{
+			name: "update out of tenants",
+			opts: store.ExecOptions{
+				Type:      store.ExecTypeUpdate,
+				TableName: "accounts",
+				Values:    []store.FieldValue{{Name: "tenant_id", Value: 9}},
+				Where:     []store.Predicate{store.NewPredicate("id", store.OpGreaterThan, 0)},
+			},
+		},
+		{
+			name: "update out of region",
+			opts: store.ExecOptions{
+				Type:      store.ExecTypeUpdate,
+				TableName: "accounts",
+				Values:    []store.FieldValue{{Name: "region", Value: "us"}},
+				Where:     []store.Predicate{store.NewPredicate("id", store.OpGreaterThan, 0)},
+			},
+		},
+		{
+			name: "update of owner",
+			opts: store.ExecOptions{
+				Type:      store.ExecTypeUpdate,
+				TableName: "accounts",
+				Values:    []store.FieldValue{{Name: "created_by", Value: loader.ID}},
+				Where:     []store.Predicate{store.NewPredicate("id", store.OpGreaterThan, 0)},
+			},
+		},
+		{
+			name: "insert out of region",
+			opts: store.ExecOptions{
+				Type:      store.ExecTypeInsert,
+				TableName: "accounts",
+				Values:    []store.FieldValue{{Name: "region", Value: "us"}, {Name: "tenant_id", Value: 1}},
+			},
+		},
+		{
+			name: "insert without region",
+			opts: store.ExecOptions{
+				Type:      store.ExecTypeInsert,
+				TableName: "accounts",
+				Values:    []store.FieldValue{{Name: "tenant_id", Value: 1}},
+			},
+		},
+		{
+			name: "insert with owner",
+			opts: store.ExecOptions{
+				Type:      store.ExecTypeInsert,
+				TableName: "accounts",
+				Values:    []store.FieldValue{{Name: "region", Value: "eu"}, {Name: "tenant_id", Value: 1}, {Name: "created_by", Value: loader.ID}},
+			},
+		},
+	} {
+		if _, err := us.Exec(context.TODO(), tc.opts); err == nil {
+			t.Fatalf("%s: expected write to be rejected", tc.name)
+		}
+	}
+	if _, err := us.Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeInsert,
+		TableName: "accounts",
+		Values:    []store.FieldValue{{Name: "region", Value: "eu"}, {Name: "tenant_id", Value: 1}},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	results, err = query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 3 {
+		t.Fatalf("expected 3 rows, got %v", results)
+	}
+
+	// a multi valued attribute cannot be used with a scalar operator
+	if err := as.SetUserAttribute(context.TODO(), analyst.ID, "region", []interface{}{"eu", "us"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := query(); err == nil {
+		t.Fatal("expected multi valued attribute with eq to fail")
+	}
+
+	if err := as.RemoveRowPolicy(context.TODO(), "accounts", "region"); err != nil {
+		t.Fatal(err)
+	}
+	if err := as.RemoveRowPolicy(context.TODO(), "accounts", "tenants"); err != nil {
+		t.Fatal(err)
+	}
+	results, err = query()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(rows)+1 {
+		t.Fatalf("expected all rows without policies, got %v", results)
+	}
+
+	// invalid policies are rejected
+	err = as.AddRowPolicy(context.TODO(), store.RowPolicy{
+		TableName: "accounts",
+		Name:      "broken",
+		Predicate: store.NewPredicate("region) OR (1", store.OpEqual, "$user.region"),
+	})
+	if err == nil {
+		t.Fatal("expected invalid policy to be rejected")
+	}
+
+	// an update only changes rows which still satisfy the policy after it,
+	// here those which are in the region of the user
+	if err := as.SetUserAttribute(context.TODO(), analyst.ID, "region", []interface{}{"eu"}); err != nil {
+		t.Fatal(err)
+	}
+	err = as.AddRowPolicy(context.TODO(), store.RowPolicy{
+		TableName: "accounts",
+		Name:      "region-or-tenant",
+		Predicate: store.Or(store.Eq("region", "$user.region"), store.Eq("tenant_id", 1)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err = us.Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeUpdate,
+		TableName: "accounts",
+		Values:    []store.FieldValue{{Name: "tenant_id", Value: 5}},
+		Where:     []store.Predicate{store.NewPredicate("id", store.OpGreaterThan, 0)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.RowsAffected != 4 {
+		t.Fatalf("expected the 4 rows of region eu to be updated, got %d", res.RowsAffected)
+	}
+}
@@ -0,0 +1,876 @@
+package store
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// SQLStatement is a statement of the sql dialect accepted by ParseSQL,
+// compiled into the options of a query or an exec so that it goes through
+// the same checks and filters as any other request.
+type SQLStatement struct {
+	// exactly one of them is set
+	Query *QueryOptions
+	Exec  *ExecOptions
+}
+
+// ParseSQL parses a single SELECT, INSERT, UPDATE or DELETE statement of a
+// restricted SQLite dialect, with args as the values of its '?'
+// placeholders. Only what can be expressed with QueryOptions and
+// ExecOptions is accepted: plain and qualified column names, literals and
+// placeholders compared with =, !=, <, <=, >, >=, LIKE, IN, BETWEEN and IS
+// NULL, AND, OR and NOT, inner and left joins on equal columns, the
+// aggregates COUNT, SUM, MIN, MAX and AVG, GROUP BY, HAVING, ORDER BY,
+// LIMIT and OFFSET, and inserts of a single row. Anything else, DDL, other
+// functions, subqueries, comments and multiple statements included, is
+// rejected.
+func ParseSQL(sql string, args ...interface{}) (*SQLStatement, error) {
+	tokens, err := tokenizeSQL(sql)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &sqlParser{tokens: tokens, args: args}
+	stmt, err := p.statement()
+	if err != nil {
+		return nil, err
+	}
+	if p.arg != len(args) {
+		return nil, NewInvalidSQL(fmt.Sprintf("%d arguments given for %d placeholders", len(args), p.arg))
+	}
+
+	if stmt.Query != nil {
+		err = stmt.Query.Validate()
+	} else {
+		err = stmt.Exec.Validate()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return stmt, nil
+}
+
+var sqlAggregates = map[string]AggregateFunc{
+	"COUNT": AggCount,
+	"SUM":   AggSum,
+	"MIN":   AggMin,
+	"MAX":   AggMax,
+	"AVG":   AggAvg,
+}
+
+// keywords which cannot be used as aliases
+var sqlKeywords = map[string]bool{
+	"SELECT": true, "DISTINCT": true, "FROM": true, "AS": true, "JOIN": true,
+	"INNER": true, "LEFT": true, "RIGHT": true, "FULL": true, "OUTER": true,
+	"CROSS": true, "NATURAL": true, "ON": true, "USING": true, "WHERE": true,
+	"GROUP": true, "BY": true, "HAVING": true, "ORDER": true, "ASC": true,
+	"DESC": true, "LIMIT": true, "OFFSET": true, "AND": true, "OR": true,
+	"NOT": true, "IN": true, "IS": true, "NULL": true, "LIKE": true,
+	"BETWEEN": true, "INSERT": true, "INTO": true, "VALUES": true,
+	"UPDATE": true, "SET": true, "DELETE": true, "UNION": true,
+	"EXCEPT": true, "INTERSECT": true, "RETURNING": true, "WITH": true,
+}
+
+var sqlOperators = map[string]Operator{
+	"=":  OpEqual,
+	"==": OpEqual,
+	"!=": OpNotEqual,
+	"<>": OpNotEqual,
+	"<":  OpLessThan,
+	"<=": OpLessEqual,
+	">":  OpGreaterThan,
+	">=": OpGreaterEqual,
+}
+
+type sqlParser struct {
+	tokens []token
+	pos    int
+	args   []interface{}
+	// placeholders consumed so far
+	arg int
+	// aggregates of the select list, referred to by HAVING and ORDER BY
+	aggregates []Aggregate
+}
+
+func (p *sqlParser) peek() token {
+	if p.pos >= len(p.tokens) {
+		return token{kind: tokenEnd}
+	}
+	return p.tokens[p.pos]
+}
+
+func (p *sqlParser) next() token {
+	t := p.peek()
+	if p.pos < len(p.tokens) {
+		p.pos++
+	}
+	return t
+}
+
+func (p *sqlParser) unexpected() error {
+	t := p.peek()
+	if t.kind == tokenEnd {
+		return NewInvalidSQL("unexpected end of statement")
+	}
+	return NewInvalidSQL(fmt.Sprintf("unexpected '%s'", t.text))
+}
+
+func (p *sqlParser) isKeyword(t token, word string) bool {
+	return t.kind == tokenIdentifier && strings.EqualFold(t.text, word)
+}
+
+// keyword consumes the keywords words if they are next.
+func (p *sqlParser) keyword(words ...string) bool {
+	for i, word := range words {
+		if p.pos+i >= len(p.tokens) || !p.isKeyword(p.tokens[p.pos+i], word) {
+			return false
+		}
+	}
+	p.pos += len(words)
+	return true
+}
+
+func (p *sqlParser) expectKeyword(words ...string) error {
+	if !p.keyword(words...) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+// punctuation consumes s if it is next.
+func (p *sqlParser) punctuation(s string) bool {
+	t := p.peek()
+	if t.kind == tokenPunctuation && t.text == s {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+// operator consumes op if it is next.
+func (p *sqlParser) operator(op string) bool {
+	t := p.peek()
+	if t.kind == tokenOperator && t.text == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *sqlParser) expectPunctuation(s string) error {
+	if !p.punctuation(s) {
+		return p.unexpected()
+	}
+	return nil
+}
+
+func (p *sqlParser) identifier() (string, error) {
+	t := p.peek()
+	if t.kind != tokenIdentifier || sqlKeywords[strings.ToUpper(t.text)] {
+		return "", p.unexpected()
+	}
+	p.pos++
+	return t.text, nil
+}
+
+// column parses a column name, qualified by the alias of its table or not.
+func (p *sqlParser) column() (string, error) {
+	name, err := p.identifier()
+	if err != nil {
+		return "", err
+	}
+	if !p.punctuation(".") {
+		return name, nil
+	}
+	column, err := p.identifier()
+	if err != nil {
+		return "", err
+	}
+	return name + "." + column, nil
+}
+
+// alias parses an optional alias, with or without AS.
+func (p *sqlParser) alias() (string, error) {
+	if p.keyword("AS") {
+		return p.identifier()
+	}
+	t := p.peek()
+	if t.kind == tokenIdentifier && !sqlKeywords[strings.ToUpper(t.text)] {
+		p.pos++
+		return t.text, nil
+	}
+	return "", nil
+}
+
+func (p *sqlParser) literal() (interface{}, error) {
+	t := p.peek()
+	switch {
+	case t.kind == tokenString:
+		p.pos++
+		return t.text, nil
+	case t.kind == tokenNumber:
+		p.pos++
+		if i, err := strconv.ParseInt(t.text, 10, 64); err == nil {
+			return i, nil
+		}
+		f, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			return nil, NewInvalidSQL(fmt.Sprintf("invalid number '%s'", t.text))
+		}
+		return f, nil
+	case t.kind == tokenParameter:
+		p.pos++
+		if p.arg >= len(p.args) {
+			return nil, NewInvalidSQL("not enough arguments for the placeholders")
+		}
+		p.arg++
+		return p.args[p.arg-1], nil
+	case p.isKeyword(t, "NULL"):
+		p.pos++
+		return nil, nil
+	}
+	return nil, p.unexpected()
+}
+
+// integer parses an integer literal, for LIMIT and OFFSET.
+func (p *sqlParser) integer() (int, error) {
+	v, err := p.literal()
+	if err != nil {
+		return 0, err
+	}
+	switch n := v.(type) {
+	case int64:
+		return int(n), nil
+	case int:
+		return n, nil
+	case float64:
+		// json numbers given as arguments
+		if n == float64(int(n)) {
+			return int(n), nil
+		}
+	}
+	return 0, NewInvalidSQL(fmt.Sprintf("expected an integer, got '%v'", v))
+}
+
+func (p *sqlParser) statement() (*SQLStatement, error) {
+	var stmt *SQLStatement
+	var err error
+	switch t := p.peek(); {
+	case p.isKeyword(t, "SELECT"):
+		var opts *QueryOptions
+		opts, err = p.selectStatement()
+		stmt = &SQLStatement{Query: opts}
+	case p.isKeyword(t, "INSERT"):
+		var opts *ExecOptions
+		opts, err = p.insertStatement()
+		stmt = &SQLStatement{Exec: opts}
+	case p.isKeyword(t, "UPDATE"):
+		var opts *ExecOptions
+		opts, err = p.updateStatement()
+		stmt = &SQLStatement{Exec: opts}
+	case p.isKeyword(t, "DELETE"):
+		var opts *ExecOptions
+		opts, err = p.deleteStatement()
+		stmt = &SQLStatement{Exec: opts}
+	default:
+		return nil, NewInvalidSQL("only SELECT, INSERT, UPDATE and DELETE statements are supported")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	p.punctuation(";")
+	if p.peek().kind != tokenEnd {
+		return nil, p.unexpected()
+	}
+	return stmt, nil
+}
+
+func (p *sqlParser) selectStatement() (*QueryOptions, error) {
+	if err := p.expectKeyword("SELECT"); err != nil {
+		return nil, err
+	}
+	opts := &QueryOptions{}
+	distinct := p.keyword("DISTINCT")
+
+	for {
+		if err := p.selectItem(opts); err != nil {
+			return nil, err
+		}
+		if !p.punctuation(",") {
+			break
+		}
+	}
+	opts.Aggregates = p.aggregates
+
+	if err := p.expectKeyword("FROM"); err != nil {
+		return nil, err
+	}
+	var err error
+	if opts.TableName, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if opts.As, err = p.alias(); err != nil {
+		return nil, err
+	}
+	for {
+		join, ok, err := p.join()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		opts.Joins = append(opts.Joins, join)
+	}
+
+	if p.keyword("WHERE") {
+		pred, err := p.expression(false)
+		if err != nil {
+			return nil, err
+		}
+		opts.Where = conjuncts(pred)
+	}
+	if p.keyword("GROUP", "BY") {
+		for {
+			col, err := p.column()
+			if err != nil {
+				return nil, err
+			}
+			opts.GroupBy = append(opts.GroupBy, col)
+			if !p.punctuation(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("HAVING") {
+		pred, err := p.expression(true)
+		if err != nil {
+			return nil, err
+		}
+		opts.Having = conjuncts(pred)
+	}
+	if p.keyword("ORDER", "BY") {
+		for {
+			col, err := p.operand(true)
+			if err != nil {
+				return nil, err
+			}
+			key := SortKey{Column: col}
+			if p.keyword("DESC") {
+				key.Desc = true
+			} else {
+				p.keyword("ASC")
+			}
+			opts.OrderBy = append(opts.OrderBy, key)
+			if !p.punctuation(",") {
+				break
+			}
+		}
+	}
+	if p.keyword("LIMIT") {
+		if opts.Limit, err = p.integer(); err != nil {
+			return nil, err
+		}
+		if p.keyword("OFFSET") {
+			if opts.Offset, err = p.integer(); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// distinct rows are the groups of all their columns
+	if distinct {
+		if len(opts.Aggregates) > 0 || len(opts.GroupBy) > 0 {
+			return nil, NewInvalidSQL("SELECT DISTINCT cannot be combined with aggregates or GROUP BY")
+		}
+		opts.GroupBy = opts.IncludeColumns
+	}
+
+	return opts, nil
+}
+
+func (p *sqlParser) selectItem(opts *QueryOptions) error {
+	if p.punctuation("*") {
+		opts.IncludeColumns = append(opts.IncludeColumns, "*")
+		return nil
+	}
+
+	if p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].kind == tokenPunctuation && p.tokens[p.pos+1].text == "(" {
+		agg, err := p.aggregateCall()
+		if err != nil {
+			return err
+		}
+		if agg.As, err = p.alias(); err != nil {
+			return err
+		}
+		p.aggregates = append(p.aggregates, agg)
+		return nil
+	}
+
+	col, err := p.column()
+	if err != nil {
+		return err
+	}
+	// results are keyed by the column name
+	alias, err := p.alias()
+	if err != nil {
+		return err
+	}
+	if alias != "" && alias != col {
+		return NewInvalidSQL(fmt.Sprintf("column '%s' cannot be renamed", col))
+	}
+	opts.IncludeColumns = append(opts.IncludeColumns, col)
+	return nil
+}
+
+func (p *sqlParser) aggregateCall() (Aggregate, error) {
+	name := p.next().text
+	fn, ok := sqlAggregates[strings.ToUpper(name)]
+	if !ok {
+		return Aggregate{}, NewInvalidSQL(fmt.Sprintf("function '%s' is not allowed", name))
+	}
+	if err := p.expectPunctuation("("); err != nil {
+		return Aggregate{}, err
+	}
+
+	agg := Aggregate{Func: fn}
+	switch {
+	case fn == AggCount && p.punctuation("*"):
+	case fn == AggCount && p.keyword("DISTINCT"):
+		agg.Func = AggCountDistinct
+		fallthrough
+	default:
+		col, err := p.column()
+		if err != nil {
+			return Aggregate{}, err
+		}
+		agg.Column = col
+	}
+
+	if err := p.expectPunctuation(")"); err != nil {
+		return Aggregate{}, err
+	}
+	return agg, nil
+}
+
+// selectedAggregate returns the name of the aggregate of the select list
+// equal to agg.
+func (p *sqlParser) selectedAggregate(agg Aggregate) (string, error) {
+	for _, selected := range p.aggregates {
+		if selected.Func == agg.Func && selected.Column == agg.Column {
+			return selected.Alias(), nil
+		}
+	}
+	return "", NewInvalidSQL(fmt.Sprintf("aggregate %s(%s) has to be selected to be referred to", agg.Func, agg.Column))
+}
+
+func (p *sqlParser) join() (Join, bool, error) {
+	var join Join
+	switch {
+	case p.keyword("JOIN"), p.keyword("INNER", "JOIN"):
+		join.Type = JoinInner
+	case p.keyword("LEFT", "JOIN"), p.keyword("LEFT", "OUTER", "JOIN"):
+		join.Type = JoinLeft
+	case p.punctuation(","):
+		return join, false, NewInvalidSQL("tables have to be joined with JOIN ... ON")
+	default:
+		t := p.peek()
+		for _, word := range []string{"RIGHT", "FULL", "CROSS", "NATURAL"} {
+			if p.isKeyword(t, word) {
+				return join, false, NewInvalidSQL(fmt.Sprintf("%s joins are not supported", word))
+			}
+		}
+		return join, false, nil
+	}
+
+	var err error
+	if join.TableName, err = p.identifier(); err != nil {
+		return join, false, err
+	}
+	if join.As, err = p.alias(); err != nil {
+		return join, false, err
+	}
+	if err := p.expectKeyword("ON"); err != nil {
+		return join, false, err
+	}
+
+	// equal columns join the tables, anything else filters the joined rows
+	for {
+		start := p.pos
+		left, err := p.column()
+		if err == nil && p.operator("=") {
+			if right, err := p.column(); err == nil {
+				join.On = append(join.On, JoinOn{Left: left, Right: right})
+				if !p.keyword("AND") {
+					break
+				}
+				continue
+			}
+		}
+		p.pos = start
+
+		pred, err := p.not(false)
+		if err != nil {
+			return join, false, err
+		}
+		join.Where = append(join.Where, pred)
+		if !p.keyword("AND") {
+			break
+		}
+	}
+	return join, true, nil
+}
+
+// expression parses predicates combined with OR, AND and NOT, aggregates
+// are only allowed in HAVING.
+func (p *sqlParser) expression(having bool) (Predicate, error) {
+	preds := []Predicate{}
+	for {
+		pred, err := p.and(having)
+		if err != nil {
+			return Predicate{}, err
+		}
+		preds = append(preds, pred)
+		if !p.keyword("OR") {
+			break
+		}
+	}
+	if len(preds) == 1 {
+		return preds[0], nil
+	}
+	return Or(preds...), nil
+}
+
+func (p *sqlParser) and(having bool) (Predicate, error) {
+	preds := []Predicate{}
+	for {
+		pred, err := p.not(having)
+		if err != nil {
+			return Predicate{}, err
+		}
+		preds = append(preds, pred)
+		if !p.keyword("AND") {
+			break
+		}
+	}
+	if len(preds) == 1 {
+		return preds[0], nil
+	}
+	return And(preds...), nil
+}
+
+func (p *sqlParser) not(having bool) (Predicate, error) {
+	if p.keyword("NOT") {
+		pred, err := p.not(having)
+		if err != nil {
+			return Predicate{}, err
+		}
+		return Not(pred), nil
+	}
+	if p.punctuation("(") {
+		if p.isKeyword(p.peek(), "SELECT") {
+			return Predicate{}, NewInvalidSQL("subqueries are not supported")
+		}
+		pred, err := p.expression(having)
+		if err != nil {
+			return Predicate{}, err
+		}
+		return pred, p.expectPunctuation(")")
+	}
+	return p.comparison(having)
+}
+
+// operand parses a column or, where allowed, an aggregate of the select
+// list, which is referred to by its name.
+func (p *sqlParser) operand(aggregates bool) (string, error) {
+	if p.pos+1 < len(p.tokens) && p.tokens[p.pos+1].kind == tokenPunctuation && p.tokens[p.pos+1].text == "(" {
+		if _, ok := sqlAggregates[strings.ToUpper(p.peek().text)]; ok && !aggregates {
+			return "", NewInvalidSQL("aggregates are only allowed in the select list, HAVING and ORDER BY")
+		}
+		agg, err := p.aggregateCall()
+		if err != nil {
+			return "", err
+		}
+		return p.selectedAggregate(agg)
+	}
+	return p.column()
+}
+
+func (p *sqlParser) comparison(having bool) (Predicate, error) {
+	field, err := p.operand(having)
+	if err != nil {
+		return Predicate{}, err
+	}
+
+	if p.keyword("IS") {
+		if p.keyword("NOT", "NULL") {
+			return NewPredicate(field, OpIsNotNull, nil), nil
+		}
+		if err := p.expectKeyword("NULL"); err != nil {
+			return Predicate{}, err
+		}
+		return NewPredicate(field, OpIsNull, nil), nil
+	}
+
+	negated := p.keyword("NOT")
+	var pred Predicate
+	switch {
+	case p.keyword("IN"):
+		if err := p.expectPunctuation("("); err != nil {
+			return Predicate{}, err
+		}
+		if p.isKeyword(p.peek(), "SELECT") {
+			return Predicate{}, NewInvalidSQL("subqueries are not supported")
+		}
+		var values []interface{}
+		for {
+			v, err := p.literal()
+			if err != nil {
+				return Predicate{}, err
+			}
+			values = append(values, v)
+			if !p.punctuation(",") {
+				break
+			}
+		}
+		if err := p.expectPunctuation(")"); err != nil {
+			return Predicate{}, err
+		}
+		if negated {
+			return NewPredicate(field, OpNotIn, values), nil
+		}
+		return NewPredicate(field, OpIn, values), nil
+	case p.keyword("LIKE"):
+		v, err := p.literal()
+		if err != nil {
+			return Predicate{}, err
+		}
+		pred = NewPredicate(field, OpLike, v)
+	case p.keyword("BETWEEN"):
+		low, err := p.literal()
+		if err != nil {
+			return Predicate{}, err
+		}
+		if err := p.expectKeyword("AND"); err != nil {
+			return Predicate{}, err
+		}
+		high, err := p.literal()
+		if err != nil {
+			return Predicate{}, err
+		}
+		pred = And(NewPredicate(field, OpGreaterEqual, low), NewPredicate(field, OpLessEqual, high))
+	case negated:
+		return Predicate{}, p.unexpected()
+	default:
+		t := p.peek()
+		op, ok := sqlOperators[t.text]
+		if t.kind != tokenOperator || !ok {
+			return Predicate{}, p.unexpected()
+		}
+		p.pos++
+		if t := p.peek(); t.kind == tokenIdentifier && !p.isKeyword(t, "NULL") {
+			return Predicate{}, NewInvalidSQL("columns can only be compared to each other in join conditions")
+		}
+		v, err := p.literal()
+		if err != nil {
+			return Predicate{}, err
+		}
+		return NewPredicate(field, op, v), nil
+	}
+
+	if negated {
+		return Not(pred), nil
+	}
+	return pred, nil
+}
+
+func (p *sqlParser) insertStatement() (*ExecOptions, error) {
+	if err := p.expectKeyword("INSERT", "INTO"); err != nil {
+		return nil, err
+	}
+	opts := &ExecOptions{Type: ExecTypeInsert}
+	var err error
+	if opts.TableName, err = p.identifier(); err != nil {
+		return nil, err
+	}
+
+	var columns []string
+	if err := p.expectPunctuation("("); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		columns = append(columns, col)
+		if !p.punctuation(",") {
+			break
+		}
+	}
+	if err := p.expectPunctuation(")"); err != nil {
+		return nil, err
+	}
+
+	if err := p.expectKeyword("VALUES"); err != nil {
+		return nil, err
+	}
+	if err := p.expectPunctuation("("); err != nil {
+		return nil, err
+	}
+	for i := range columns {
+		if i > 0 {
+			if err := p.expectPunctuation(","); err != nil {
+				return nil, err
+			}
+		}
+		v, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		opts.Values = append(opts.Values, FieldValue{Name: columns[i], Value: v})
+	}
+	if err := p.expectPunctuation(")"); err != nil {
+		return nil, err
+	}
+	if p.punctuation(",") {
+		return nil, NewInvalidSQL("rows have to be inserted one at a time")
+	}
+
+	return opts, nil
+}
+
+func (p *sqlParser) updateStatement() (*ExecOptions, error) {
+	if err := p.expectKeyword("UPDATE"); err != nil {
+		return nil, err
+	}
+	opts := &ExecOptions{Type: ExecTypeUpdate}
+	var err error
+	if opts.TableName, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if err := p.expectKeyword("SET"); err != nil {
+		return nil, err
+	}
+	for {
+		col, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		if !p.operator("=") {
+			return nil, p.unexpected()
+		}
+		v, err := p.literal()
+		if err != nil {
+			return nil, err
+		}
+		opts.Values = append(opts.Values, FieldValue{Name: col, Value: v})
+		if !p.punctuation(",") {
+			break
+		}
+	}
+
+	if p.keyword("WHERE") {
+		pred, err := p.expression(false)
+		if err != nil {
+			return nil, err
+		}
+		opts.Where = conjuncts(pred)
+	}
+	return opts, nil
+}
+
+func (p *sqlParser) deleteStatement() (*ExecOptions, error) {
+	if err := p.expectKeyword("DELETE", "FROM"); err != nil {
+		return nil, err
+	}
+	opts := &ExecOptions{Type: ExecTypeDelete}
+	var err error
+	if opts.TableName, err = p.identifier(); err != nil {
+		return nil, err
+	}
+	if p.keyword("WHERE") {
+		pred, err := p.expression(false)
+		if err != nil {
+			return nil, err
+		}
+		opts.Where = conjuncts(pred)
+	}
+	return opts, nil
+}
+
+// conjuncts splits a top level AND into its predicates.
+func conjuncts(pred Predicate) []Predicate {
+	if len(pred.And) > 0 {
+		return pred.And
+	}
+	return []Predicate{pred}
+}
+
+func tokenizeSQL(s string) ([]token, error) {
+	var tokens []token
+	runes := []rune(s)
+	for i := 0; i < len(runes); {
+		r := runes[i]
+		switch {
+		case unicode.IsSpace(r):
+			i++
+		case r == '_' || unicode.IsLetter(r):
+			j := i
+			for j < len(runes) && (runes[j] == '_' || unicode.IsLetter(runes[j]) || unicode.IsDigit(runes[j])) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenIdentifier, text: string(runes[i:j])})
+			i = j
+		case unicode.IsDigit(r) || (r == '-' && i+1 < len(runes) && unicode.IsDigit(runes[i+1])):
+			j := i + 1
+			for j < len(runes) && (unicode.IsDigit(runes[j]) || runes[j] == '.' || runes[j] == 'e' || runes[j] == 'E') {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenNumber, text: string(runes[i:j])})
+			i = j
+		case r == '\'':
+			var sb strings.Builder
+			j := i + 1
+			for {
+				if j >= len(runes) {
+					return nil, NewInvalidSQL("unterminated string literal")
+				}
+				if runes[j] == '\'' {
+					// '' is an escaped quote
+					if j+1 < len(runes) && runes[j+1] == '\'' {
+						sb.WriteRune('\'')
+						j += 2
+						continue
+					}
+					break
+				}
+				sb.WriteRune(runes[j])
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenString, text: sb.String()})
+			i = j + 1
+		case strings.ContainsRune("=!<>", r):
+			j := i + 1
+			if j < len(runes) && strings.ContainsRune("=>", runes[j]) {
+				j++
+			}
+			tokens = append(tokens, token{kind: tokenOperator, text: string(runes[i:j])})
+			i = j
+		case strings.ContainsRune("(),.*;", r):
+			tokens = append(tokens, token{kind: tokenPunctuation, text: string(r)})
+			i++
+		case r == '?':
+			tokens = append(tokens, token{kind: tokenParameter, text: "?"})
+			i++
+		case r == '"' || r == '`' || r == '[':
+			return nil, NewInvalidSQL("quoted identifiers are not supported")
+		default:
+			return nil, NewInvalidSQL(fmt.Sprintf("unexpected character '%c'", r))
+		}
+	}
+	return tokens, nil
+}
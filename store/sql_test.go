@@ -0,0 +1,150 @@
+package store_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/thekb/chroma-takehome/store"
+)
+
+func TestParseSQL(t *testing.T) {
+	for _, tc := range []struct {
+		sql  string
+		args []interface{}
+		want store.SQLStatement
+	}{
+		{
+			sql: "SELECT id, name FROM foo WHERE name = 'it''s' AND (id > 1 OR id IN (5, 6)) AND name IS NOT NULL;",
+			want: store.SQLStatement{Query: &store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"id", "name"},
+				Where: []store.Predicate{
+					store.Eq("name", "it's"),
+					store.Or(store.NewPredicate("id", store.OpGreaterThan, int64(1)), store.NewPredicate("id", store.OpIn, []interface{}{int64(5), int64(6)})),
+					store.NewPredicate("name", store.OpIsNotNull, nil),
+				},
+			}},
+		},
+		{
+			sql:  "select * from foo where not name like ? and id between ? and 10 order by name desc, id limit 5 offset ?",
+			args: []interface{}{"a%", 2, 10},
+			want: store.SQLStatement{Query: &store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"*"},
+				Where: []store.Predicate{
+					store.Not(store.NewPredicate("name", store.OpLike, "a%")),
+					store.And(store.NewPredicate("id", store.OpGreaterEqual, 2), store.NewPredicate("id", store.OpLessEqual, int64(10))),
+				},
+				OrderBy: []store.SortKey{{Column: "name", Desc: true}, {Column: "id"}},
+				Limit:   5,
+				Offset:  10,
+			}},
+		},
+		{
+			sql: "SELECT c.name, COUNT(*), SUM(o.amount) AS total FROM orders o LEFT JOIN customers AS c ON o.customer_id = c.id AND c.active = 1 " +
+				"WHERE o.amount > 0 GROUP BY c.name HAVING COUNT(*) > 1 ORDER BY SUM(o.amount) DESC",
+			want: store.SQLStatement{Query: &store.QueryOptions{
+				TableName:      "orders",
+				As:             "o",
+				IncludeColumns: []string{"c.name"},
+				Aggregates:     []store.Aggregate{{Func: store.AggCount}, {Func: store.AggSum, Column: "o.amount", As: "total"}},
+				Joins: []store.Join{{
+					Type:      store.JoinLeft,
+					TableName: "customers",
+					As:        "c",
+					On:        []store.JoinOn{{Left: "o.customer_id", Right: "c.id"}},
+					Where:     []store.Predicate{store.Eq("c.active", int64(1))},
+				}},
+				Where:   []store.Predicate{store.NewPredicate("o.amount", store.OpGreaterThan, int64(0))},
+				GroupBy: []string{"c.name"},
+				Having:  []store.Predicate{store.NewPredicate("count", store.OpGreaterThan, int64(1))},
+				OrderBy: []store.SortKey{{Column: "total", Desc: true}},
+			}},
+		},
+		{
+			sql: "SELECT DISTINCT name FROM foo",
+			want: store.SQLStatement{Query: &store.QueryOptions{
+				TableName:      "foo",
+				IncludeColumns: []string{"name"},
+				GroupBy:        []string{"name"},
+			}},
+		},
+		{
+			sql: "INSERT INTO foo (name, score) VALUES ('test', -1.5)",
+			want: store.SQLStatement{Exec: &store.ExecOptions{
+				Type:      store.ExecTypeInsert,
+				TableName: "foo",
+				Values:    []store.FieldValue{{Name: "name", Value: "test"}, {Name: "score", Value: -1.5}},
+			}},
+		},
+		{
+			sql:  "UPDATE foo SET name = ?, score = NULL WHERE id = ?",
+			args: []interface{}{"test", 1},
+			want: store.SQLStatement{Exec: &store.ExecOptions{
+				Type:      store.ExecTypeUpdate,
+				TableName: "foo",
+				Values:    []store.FieldValue{{Name: "name", Value: "test"}, {Name: "score", Value: nil}},
+				Where:     []store.Predicate{store.Eq("id", 1)},
+			}},
+		},
+		{
+			sql: "DELETE FROM foo WHERE id NOT IN (1, 2)",
+			want: store.SQLStatement{Exec: &store.ExecOptions{
+				Type:      store.ExecTypeDelete,
+				TableName: "foo",
+				Where:     []store.Predicate{store.NewPredicate("id", store.OpNotIn, []interface{}{int64(1), int64(2)})},
+			}},
+		},
+	} {
+		stmt, err := store.ParseSQL(tc.sql, tc.args...)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.sql, err)
+		}
+		if diff := cmp.Diff(*stmt, tc.want); diff != "" {
+			t.Fatalf("%s: %s", tc.sql, diff)
+		}
+	}
+
+	for _, tc := range []struct {
+		sql  string
+		args []interface{}
+	}{
+		{sql: "DROP TABLE foo"},
+		{sql: "CREATE TABLE bar (id integer)"},
+		{sql: "PRAGMA table_info(foo)"},
+		{sql: "ATTACH DATABASE '/tmp/x' AS x"},
+		{sql: "SELECT id FROM foo; DROP TABLE foo"},
+		{sql: "SELECT id FROM foo -- comment"},
+		{sql: "SELECT id FROM foo /* comment */"},
+		{sql: "SELECT load_extension('x') FROM foo"},
+		{sql: "SELECT id FROM foo WHERE name = upper('a')"},
+		{sql: "SELECT id FROM foo WHERE id IN (SELECT id FROM bar)"},
+		{sql: "SELECT id FROM foo UNION SELECT id FROM bar"},
+		{sql: "SELECT id FROM foo WHERE id = created_by"},
+		{sql: "SELECT id FROM foo WHERE 1 = 1"},
+		{sql: "SELECT id FROM foo WHERE COUNT(*) > 1"},
+		{sql: "SELECT id FROM foo, bar"},
+		{sql: "SELECT foo.id FROM foo CROSS JOIN bar"},
+		{sql: "SELECT id AS other FROM foo"},
+		{sql: "SELECT \"id\" FROM foo"},
+		{sql: "SELECT id FROM main.foo"},
+		{sql: "SELECT name, COUNT(*) FROM foo GROUP BY name HAVING SUM(id) > 1"},
+		{sql: "SELECT id FROM foo WHERE id = ?"},
+		{sql: "SELECT id FROM foo", args: []interface{}{1}},
+		{sql: "SELECT id FROM foo LIMIT 'x'"},
+		{sql: "INSERT INTO foo (name) VALUES ('a'), ('b')"},
+		{sql: "INSERT INTO foo (name) SELECT name FROM bar"},
+		{sql: "UPDATE foo SET name = 'x'"},
+		{sql: "DELETE FROM foo"},
+		{sql: "DELETE FROM foo WHERE id = 1 RETURNING *"},
+		{sql: "WITH x AS (SELECT id FROM foo) SELECT id FROM x"},
+	} {
+		if _, err := store.ParseSQL(tc.sql, tc.args...); err == nil {
+			t.Fatalf("%s: expected statement to be rejected", tc.sql)
+		} else if !errors.Is(err, store.ErrInvalidSQL) && !errors.Is(err, store.ErrInvalidQueryOptions) &&
+			!errors.Is(err, store.ErrInvalidExecOptions) && !errors.Is(err, store.ErrInvalidPredicate) {
+			t.Fatalf("%s: unexpected error %v", tc.sql, err)
+		}
+	}
+}
@@ -3,10 +3,11 @@ package store
 import (
 	"context"
 	"database/sql"
+	"errors"
+	"math"
 
 	"github.com/huandu/go-sqlbuilder"
-
-	_ "github.com/mattn/go-sqlite3"
+	"github.com/mattn/go-sqlite3"
 )
 
 type sqlite3Store struct {
@@ -28,58 +29,143 @@ func NewSQLite3Store(dataStource string, id int64) (*sqlite3Store, error) {
 	return &sqlite3Store{db: db, id: id}, nil
 }
 
+func (s *sqlite3Store) Close() error {
+	return s.db.Close()
+}
+
+// isUniqueViolation reports whether err is sqlite refusing a row because of
+// a primary key or unique constraint.
+func isUniqueViolation(err error) bool {
+	var serr sqlite3.Error
+	if !errors.As(err, &serr) {
+		return false
+	}
+	return serr.ExtendedCode == sqlite3.ErrConstraintPrimaryKey || serr.ExtendedCode == sqlite3.ErrConstraintUnique
+}
+
 func (s *sqlite3Store) ID() int64 {
 	// TODO: return from store
 	return s.id
 }
 
 func (s *sqlite3Store) Query(ctx context.Context, opts QueryOptions) (QueryResult, error) {
+	ret := make([]map[string]interface{}, 0)
+	err := s.scan(ctx, opts, func(rec map[string]interface{}) error {
+		ret = append(ret, rec)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
 
+// scan runs a query and hands its rows to row one at a time, as they are
+// read, stopping at the first error row returns.
+func (s *sqlite3Store) scan(ctx context.Context, opts QueryOptions, row func(map[string]interface{}) error) error {
 	if err := opts.Validate(); err != nil {
-		return nil, err
+		return err
+	}
+
+	where := opts.Where
+	if opts.Cursor != "" {
+		cursor, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return err
+		}
+		keyset, err := cursor.keyset(opts.OrderBy)
+		if err != nil {
+			return err
+		}
+		where = append(where[:len(where):len(where)], keyset)
 	}
 
 	builder := sqlbuilder.SQLite.NewSelectBuilder()
 
-	builder = builder.Select(opts.IncludeColumns...).From(opts.TableName).Where(opts.Where...)
-	if opts.Limit > 0 {
+	builder = builder.Select(opts.resultColumns()...)
+	if len(opts.Joins) == 0 {
+		builder = builder.From(opts.TableName)
+	} else {
+		builder = builder.From(opts.TableName + " AS " + opts.Alias())
+	}
+	for _, join := range opts.Joins {
+		option := sqlbuilder.InnerJoin
+		if join.Type == JoinLeft {
+			option = sqlbuilder.LeftJoin
+		}
+		var on []string
+		for _, cols := range join.On {
+			on = append(on, cols.Left+" = "+cols.Right)
+		}
+		on = append(on, buildPredicates(&builder.Cond, join.Where)...)
+		builder = builder.JoinWithOption(option, join.TableName+" AS "+join.Alias(), on...)
+	}
+	builder = builder.Where(buildPredicates(&builder.Cond, where)...)
+	if len(opts.GroupBy) > 0 {
+		builder = builder.GroupBy(opts.GroupBy...)
+	}
+	if len(opts.Having) > 0 {
+		having := make([]Predicate, len(opts.Having))
+		for i, pred := range opts.Having {
+			having[i] = opts.resolveAggregates(pred)
+		}
+		builder = builder.Having(buildPredicates(&builder.Cond, having)...)
+	}
+	for _, key := range opts.OrderBy {
+		column := key.Column
+		if agg, ok := opts.aggregate(column); ok {
+			column = agg.expr()
+		}
+		if key.Desc {
+			builder = builder.OrderBy(column + " DESC")
+		} else {
+			builder = builder.OrderBy(column + " ASC")
+		}
+	}
+	switch {
+	case opts.Limit > 0:
 		builder = builder.Limit(opts.Limit)
+	case opts.Offset > 0:
+		// sqlite only takes an offset along with a limit
+		builder = builder.Limit(math.MaxInt)
+	}
+	if opts.Offset > 0 {
+		builder = builder.Offset(opts.Offset)
 	}
 
-	query := builder.String()
-	rows, err := s.db.QueryContext(ctx, query)
+	query, args := builder.Build()
+	rows, err := s.db.QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	defer rows.Close()
 
 	// adapted from https://gist.github.com/proprietary/b401b0f7e9fb6c00ed06df553c6a3977
-	ret := make([]map[string]interface{}, 0)
+	// use the columns of the result set rather than the requested columns
+	// so that '*' is expanded correctly
+	colNames, err := rows.Columns()
+	if err != nil {
+		return err
+	}
 	for rows.Next() {
-		colVals := make([]interface{}, len(opts.IncludeColumns))
+		colVals := make([]interface{}, len(colNames))
 		for i := range colVals {
 			colVals[i] = new(interface{})
 		}
 		err = rows.Scan(colVals...)
 		if err != nil {
-			return nil, err
-		}
-		colNames, err := rows.Columns()
-		if err != nil {
-			return nil, err
+			return err
 		}
 		these := make(map[string]interface{})
 		for idx, name := range colNames {
 			these[name] = *colVals[idx].(*interface{})
 		}
-		ret = append(ret, these)
-	}
-	if err = rows.Err(); err != nil {
-		return nil, err
+		if err := row(these); err != nil {
+			return err
+		}
 	}
-
-	return ret, nil
+	return rows.Err()
 }
 
 func (s *sqlite3Store) Exec(ctx context.Context, opts ExecOptions) (*ExecResult, error) {
@@ -112,7 +198,12 @@ func (s *sqlite3Store) Exec(ctx context.Context, opts ExecOptions) (*ExecResult,
 		}
 		builder.Set(assigns...)
 
-		builder.Where(opts.Where...)
+		builder.Where(buildPredicates(&builder.Cond, opts.Where)...)
+		query, args = builder.Build()
+	case ExecTypeDelete:
+		builder := sqlbuilder.SQLite.NewDeleteBuilder()
+		builder = builder.DeleteFrom(opts.TableName)
+		builder.Where(buildPredicates(&builder.Cond, opts.Where)...)
 		query, args = builder.Build()
 	}
 
@@ -124,17 +215,15 @@ func (s *sqlite3Store) Exec(ctx context.Context, opts ExecOptions) (*ExecResult,
 	var lastInsertId int64
 	var rowsAffected int64
 
-	switch opts.Type {
-	case ExecTypeInsert:
+	if opts.Type == ExecTypeInsert {
 		lastInsertId, err = result.LastInsertId()
 		if err != nil {
 			return nil, err
 		}
-	case ExecTypeUpdate:
-		rowsAffected, err = result.RowsAffected()
-		if err != nil {
-			return nil, err
-		}
+	}
+	rowsAffected, err = result.RowsAffected()
+	if err != nil {
+		return nil, err
 	}
 
 	return &ExecResult{
@@ -167,3 +256,22 @@ func (s *sqlite3Store) CreateTable(ctx context.Context, opts CreateTableOptions)
 
 	return err
 }
+
+var _ UserTableDropperStore = (*sqlite3Store)(nil)
+
+func (s *sqlite3Store) DropTable(ctx context.Context, opts DropTableOptions) error {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+
+	// sqlbuilder has no drop table builder, table name is validated above
+	query := "DROP TABLE "
+	if opts.IfExists {
+		query += "IF EXISTS "
+	}
+	query += opts.TableName
+
+	_, err := s.db.ExecContext(ctx, query)
+
+	return err
+}
@@ -2,6 +2,7 @@ package store_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
 
@@ -52,4 +53,36 @@ func TestSQLite3Store(t *testing.T) {
 	}
 	t.Log(result)
 
+	// delete
+	deleted, err := s.Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeDelete,
+		TableName: "foo",
+		Where:     []store.Predicate{store.NewPredicate("name", store.OpIn, []string{"name1", "name2"})},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if deleted.RowsAffected != 2 {
+		t.Fatalf("expected 2 rows to be deleted, got %d", deleted.RowsAffected)
+	}
+
+	result, err = s.Query(context.TODO(), store.QueryOptions{
+		TableName:      "foo",
+		IncludeColumns: []string{"id", "name"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result) != 8 {
+		t.Fatalf("expected 8 rows after delete, got %d", len(result))
+	}
+
+	// delete without predicates is rejected
+	_, err = s.Exec(context.TODO(), store.ExecOptions{
+		Type:      store.ExecTypeDelete,
+		TableName: "foo",
+	})
+	if !errors.Is(err, store.ErrInvalidExecOptions) {
+		t.Fatalf("expected invalid exec options, got %v", err)
+	}
 }
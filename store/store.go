@@ -2,13 +2,52 @@ package store
 
 import (
 	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slices"
 )
 
+var identifierRegexp = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validIdentifier reports whether name can be safely used as a table or
+// column name in a generated statement.
+func validIdentifier(name string) bool {
+	return identifierRegexp.MatchString(name)
+}
+
+type SortKey struct {
+	Column string `json:"column"`
+	Desc   bool   `json:"desc"`
+}
+
 type QueryOptions struct {
 	TableName      string   `json:"tableName"`
 	IncludeColumns []string `json:"includeColumns"`
-	Where          []string `json:"where"`
-	Limit          int      `json:"limit"`
+	// predicates are combined with AND
+	Where []Predicate `json:"where"`
+	Limit int         `json:"limit"`
+	// rows are sorted by the keys in order, pages are only deterministic
+	// when the keys identify a row, e.g. when the last one is the id
+	OrderBy []SortKey `json:"orderBy"`
+	// rows skipped before the first one returned
+	Offset int `json:"offset"`
+	// opaque cursor of the previous page, continues right after its last
+	// row, the rest of the options have to stay the same
+	Cursor string `json:"cursor"`
+	// with aggregates or group by a row is returned per group, holding the
+	// group by columns and the aggregates, included columns have to be
+	// grouped by
+	Aggregates []Aggregate `json:"aggregates"`
+	GroupBy    []string    `json:"groupBy"`
+	// predicates on the groups combined with AND, their fields are group
+	// by columns or names of aggregates
+	Having []Predicate `json:"having"`
+	// tables of the same store joined to TableName, referred to as As
+	As    string `json:"as"`
+	Joins []Join `json:"joins"`
 	//TODO add more query options
 }
 
@@ -16,7 +55,46 @@ func (o QueryOptions) Validate() error {
 	if o.TableName == "" {
 		return NewInvalidQueryOptions("table name is empty")
 	}
-	return nil
+	if !validIdentifier(o.TableName) {
+		return NewInvalidQueryOptions(fmt.Sprintf("invalid table name '%s'", o.TableName))
+	}
+	if err := o.validateJoins(); err != nil {
+		return err
+	}
+	for _, col := range o.IncludeColumns {
+		if col != "*" && !o.validReference(col) {
+			return NewInvalidQueryOptions(fmt.Sprintf("invalid column name '%s'", col))
+		}
+	}
+	for _, key := range o.OrderBy {
+		if _, ok := o.aggregate(key.Column); !ok && !o.validReference(key.Column) {
+			return NewInvalidQueryOptions(fmt.Sprintf("invalid sort column '%s'", key.Column))
+		}
+	}
+	if o.Limit < 0 || o.Offset < 0 {
+		return NewInvalidQueryOptions("limit and offset cannot be negative")
+	}
+	if o.Cursor != "" {
+		if len(o.OrderBy) == 0 {
+			return NewInvalidQueryOptions("paging with a cursor needs sort keys")
+		}
+		if o.Offset > 0 {
+			return NewInvalidQueryOptions("offset and cursor cannot be combined")
+		}
+	}
+	if err := o.validateAggregates(); err != nil {
+		return err
+	}
+	return o.validatePredicates(o.Where)
+}
+
+// SortColumns returns the columns of the sort keys.
+func (o QueryOptions) SortColumns() []string {
+	var ret []string
+	for _, key := range o.OrderBy {
+		ret = append(ret, key.Column)
+	}
+	return ret
 }
 
 type ExecType string
@@ -24,6 +102,7 @@ type ExecType string
 const (
 	ExecTypeUpdate = "update"
 	ExecTypeInsert = "insert"
+	ExecTypeDelete = "delete"
 )
 
 type FieldValue struct {
@@ -35,25 +114,39 @@ type ExecOptions struct {
 	Type      ExecType     `json:"type"`
 	TableName string       `json:"tableName"`
 	Values    []FieldValue `json:"values"`
-	Where     []string     `json:"where"`
+	Where     []Predicate  `json:"where"`
 }
 
 func (o ExecOptions) Validate() error {
 	switch o.Type {
-	case ExecTypeInsert, ExecTypeUpdate:
+	case ExecTypeInsert, ExecTypeUpdate, ExecTypeDelete:
 	default:
 		return NewInvalidExecOptions("invalid exec type")
 	}
 	if o.TableName == "" {
 		return NewInvalidExecOptions("table name is empty")
 	}
-	if len(o.Values) == 0 {
+	if !validIdentifier(o.TableName) {
+		return NewInvalidExecOptions(fmt.Sprintf("invalid table name '%s'", o.TableName))
+	}
+	if o.Type == ExecTypeDelete && len(o.Values) > 0 {
+		return NewInvalidExecOptions("delete does not take values")
+	}
+	if o.Type != ExecTypeDelete && len(o.Values) == 0 {
 		return NewInvalidExecOptions("nothing to update")
 	}
+	for _, v := range o.Values {
+		if !validIdentifier(v.Name) {
+			return NewInvalidExecOptions(fmt.Sprintf("invalid column name '%s'", v.Name))
+		}
+	}
 	if o.Type == ExecTypeUpdate && len(o.Where) == 0 {
 		return NewInvalidExecOptions("update without predicates not allowed")
 	}
-	return nil
+	if o.Type == ExecTypeDelete && len(o.Where) == 0 {
+		return NewInvalidExecOptions("delete without predicates not allowed")
+	}
+	return validatePredicates(o.Where)
 }
 
 type QueryResult []map[string]interface{}
@@ -66,12 +159,36 @@ type CreateTableOptions struct {
 	TableName   string     `json:"tableName"`
 	Definitions [][]string `json:"definitions"`
 	IfNotExists bool       `json:"ifNotExists"`
+	// places the table in the store of an existing table so that the two
+	// can be joined, a new store is used when empty
+	ColocateWith string `json:"colocateWith"`
 }
 
 func (o CreateTableOptions) Validate() error {
 	if o.TableName == "" {
 		return NewInvalidTableCreationOptions("table name is empty")
 	}
+	if !validIdentifier(o.TableName) {
+		return NewInvalidTableCreationOptions(fmt.Sprintf("invalid table name '%s'", o.TableName))
+	}
+	if o.ColocateWith != "" && !validIdentifier(o.ColocateWith) {
+		return NewInvalidTableCreationOptions(fmt.Sprintf("invalid table name '%s'", o.ColocateWith))
+	}
+	return nil
+}
+
+type DropTableOptions struct {
+	TableName string `json:"tableName"`
+	IfExists  bool   `json:"ifExists"`
+}
+
+func (o DropTableOptions) Validate() error {
+	if o.TableName == "" {
+		return NewInvalidTableDropOptions("table name is empty")
+	}
+	if !validIdentifier(o.TableName) {
+		return NewInvalidTableDropOptions(fmt.Sprintf("invalid table name '%s'", o.TableName))
+	}
 	return nil
 }
 
@@ -85,6 +202,17 @@ type UserTableCreatorStore interface {
 	CreateTable(context.Context, CreateTableOptions) error
 }
 
+// PagedStore is implemented by user stores which hand out cursors to
+// continue a query after the last row of a page.
+type PagedStore interface {
+	// returns the cursor of the page after results, empty if there is none
+	NextCursor(ctx context.Context, opts QueryOptions, results QueryResult) (string, error)
+}
+
+type UserTableDropperStore interface {
+	DropTable(context.Context, DropTableOptions) error
+}
+
 type UserStoreOptions struct {
 	DataSource string
 	ID         int64
@@ -95,13 +223,305 @@ type UserStoreFactory interface {
 }
 
 type TablePermission struct {
+	// table name or a pattern such as 'analytics_*' which also covers
+	// tables created after the grant
 	TableName  string
 	Permission string
+	// columns covered by the grant, empty means all columns
+	Columns []string
+	// the grant is only in effect within [NotBefore, NotAfter), a zero
+	// time leaves that side unbounded
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// ActiveAt reports whether the grant is in effect at t.
+func (p TablePermission) ActiveAt(t time.Time) bool {
+	if !p.NotBefore.IsZero() && t.Before(p.NotBefore) {
+		return false
+	}
+	if !p.NotAfter.IsZero() && !t.Before(p.NotAfter) {
+		return false
+	}
+	return true
+}
+
+// UserPermission is a grant along with the user it was given to.
+type UserPermission struct {
+	UserID   int64
+	UserName string
+	TablePermission
+}
+
+type PermissionOptions struct {
+	UserID     int64    `json:"userId"`
+	TableName  string   `json:"tableName"`
+	Permission string   `json:"permission"`
+	Columns    []string `json:"columns"`
+	// optional window in which the grant is in effect
+	NotBefore time.Time `json:"notBefore"`
+	NotAfter  time.Time `json:"notAfter"`
+}
+
+func (o PermissionOptions) Validate() error {
+	if !o.NotBefore.IsZero() && !o.NotAfter.IsZero() && !o.NotAfter.After(o.NotBefore) {
+		return fmt.Errorf("notAfter has to be after notBefore")
+	}
+	return validateGrant(o.TableName, o.Permission, o.Columns)
+}
+
+type RolePermissionOptions struct {
+	RoleID     int64    `json:"roleId"`
+	TableName  string   `json:"tableName"`
+	Permission string   `json:"permission"`
+	Columns    []string `json:"columns"`
+}
+
+func (o RolePermissionOptions) Validate() error {
+	return validateGrant(o.TableName, o.Permission, o.Columns)
+}
+
+func validateGrant(tableName, permission string, columns []string) error {
+	if !slices.Contains(defaultPermissions, permission) {
+		return fmt.Errorf("invalid permission %s. should be one of '%s'", permission, strings.Join(defaultPermissions, ","))
+	}
+	if err := validateTablePattern(tableName); err != nil {
+		return err
+	}
+	for _, col := range columns {
+		if !validIdentifier(col) {
+			return fmt.Errorf("invalid column name '%s'", col)
+		}
+	}
+	return nil
 }
 
 type User struct {
 	ID       int64
 	UserName string
+	Disabled bool
+	// id of the api key the user authenticated with, empty when the user
+	// was not looked up by a token
+	KeyID string
+	// scope of that key, empty when the key has the full access of the user
+	Scope []KeyScope
+}
+
+// KeyScope limits what an api key can do to the grants of its owner on the
+// tables matching TablePattern for Permission, '*' for every permission.
+// A restricted permission in the scope narrows an ALL grant of the owner to
+// its restricted counterpart, e.g. WRITE_ALL to WRITE_RESTRICTED.
+type KeyScope struct {
+	TablePattern string `json:"tablePattern"`
+	Permission   string `json:"permission"`
+}
+
+func (s KeyScope) Validate() error {
+	if err := validateTablePattern(s.TablePattern); err != nil {
+		return err
+	}
+	return validatePermissionOrAll(s.Permission)
+}
+
+// Matches reports whether the scope allows permission on the table.
+func (s KeyScope) Matches(tableName, permission string) bool {
+	if s.Permission != AllPermissions && s.Permission != permission {
+		return false
+	}
+	return matchTable(s.TablePattern, tableName)
+}
+
+// APIKey is the stored part of a token, the secret itself is never stored.
+// Its ID is the non secret prefix of the token.
+type APIKey struct {
+	ID         string     `json:"id"`
+	UserID     int64      `json:"userId"`
+	Label      string     `json:"label"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	LastUsedAt time.Time  `json:"lastUsedAt"`
+	ExpiresAt  time.Time  `json:"expiresAt"`
+	RevokedAt  time.Time  `json:"revokedAt"`
+	Scope      []KeyScope `json:"scope"`
+	// signing keys sign requests and cannot be used as bearer tokens
+	Signing bool `json:"signing"`
+}
+
+type APIKeyOptions struct {
+	UserID int64  `json:"userId"`
+	Label  string `json:"label"`
+	// optional, the key stops working at this time
+	ExpiresAt time.Time `json:"expiresAt"`
+	// optional, limits the key to a subset of the grants of the user
+	Scope []KeyScope `json:"scope"`
+	// optional, mint a key for signing requests instead of a bearer token
+	Signing bool `json:"signing"`
+}
+
+func (o APIKeyOptions) Validate() error {
+	if o.UserID == 0 {
+		return fmt.Errorf("user id is empty")
+	}
+	if !o.ExpiresAt.IsZero() && !o.ExpiresAt.After(time.Now()) {
+		return fmt.Errorf("expiry has to be in the future")
+	}
+	for _, scope := range o.Scope {
+		if err := scope.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// admin roles, each role grants a fixed set of capabilities
+const (
+	ADMIN_ROLE_USER_MANAGER   = "user-manager"
+	ADMIN_ROLE_SCHEMA_MANAGER = "schema-manager"
+	ADMIN_ROLE_GRANT_MANAGER  = "grant-manager"
+	ADMIN_ROLE_AUDITOR        = "auditor"
+	ADMIN_ROLE_SUPERADMIN     = "superadmin"
+)
+
+// admin capabilities, checked by the admin routes
+const (
+	// users, their api keys and attributes
+	CapabilityManageUsers = "manage_users"
+	// tables and row policies
+	CapabilityManageSchema = "manage_schema"
+	// permissions, roles, groups and deny rules
+	CapabilityManageGrants = "manage_grants"
+	// other admins
+	CapabilityManageAdmins = "manage_admins"
+	// searching the audit log
+	CapabilityReadAudit = "read_audit"
+)
+
+var adminRoleCapabilities = map[string][]string{
+	ADMIN_ROLE_USER_MANAGER:   {CapabilityManageUsers},
+	ADMIN_ROLE_SCHEMA_MANAGER: {CapabilityManageSchema},
+	ADMIN_ROLE_GRANT_MANAGER:  {CapabilityManageGrants},
+	ADMIN_ROLE_AUDITOR:        {CapabilityReadAudit},
+	ADMIN_ROLE_SUPERADMIN:     {CapabilityManageUsers, CapabilityManageSchema, CapabilityManageGrants, CapabilityManageAdmins, CapabilityReadAudit},
+}
+
+// Admin is a principal allowed to use the admin routes, admins are kept
+// apart from users and never have access to the data in the user stores.
+type Admin struct {
+	ID    int64
+	Name  string
+	Roles []string
+	// id of the key the admin authenticated with, empty when the admin
+	// was not looked up by a token
+	KeyID string
+}
+
+// Can reports whether one of the roles of the admin grants capability.
+func (a Admin) Can(capability string) bool {
+	for _, role := range a.Roles {
+		if slices.Contains(adminRoleCapabilities[role], capability) {
+			return true
+		}
+	}
+	return false
+}
+
+type AdminOptions struct {
+	Name  string   `json:"name"`
+	Roles []string `json:"roles"`
+}
+
+func (o AdminOptions) Validate() error {
+	if o.Name == "" {
+		return fmt.Errorf("admin name is empty")
+	}
+	return validateAdminRoles(o.Roles)
+}
+
+func validateAdminRoles(roles []string) error {
+	if len(roles) == 0 {
+		return fmt.Errorf("admin needs at least one role")
+	}
+	for _, role := range roles {
+		if _, ok := adminRoleCapabilities[role]; !ok {
+			return fmt.Errorf("invalid admin role %s. should be one of '%s'", role, strings.Join(adminRoles, ","))
+		}
+	}
+	return nil
+}
+
+var adminRoles = []string{ADMIN_ROLE_USER_MANAGER, ADMIN_ROLE_SCHEMA_MANAGER, ADMIN_ROLE_GRANT_MANAGER, ADMIN_ROLE_AUDITOR, ADMIN_ROLE_SUPERADMIN}
+
+// Role bundles grants which can be bound to users and groups.
+type Role struct {
+	ID   int64
+	Name string
+}
+
+type Group struct {
+	ID   int64
+	Name string
+}
+
+// RoleBinding binds a role to either a user or a group.
+type RoleBinding struct {
+	RoleID  int64 `json:"roleId"`
+	UserID  int64 `json:"userId"`
+	GroupID int64 `json:"groupId"`
+}
+
+func (b RoleBinding) Validate() error {
+	if (b.UserID == 0) == (b.GroupID == 0) {
+		return fmt.Errorf("role binding needs exactly one of user or group")
+	}
+	return nil
+}
+
+// DenyRule removes grants from a user, the members of a group or the users
+// a role is bound to. TablePattern is matched against table names with
+// path.Match, e.g. 'payroll_*', and Permission is either one of the
+// permissions or '*' for all of them. Deny rules always win over grants.
+type DenyRule struct {
+	Name         string `json:"name"`
+	UserID       int64  `json:"userId"`
+	GroupID      int64  `json:"groupId"`
+	RoleID       int64  `json:"roleId"`
+	TablePattern string `json:"tablePattern"`
+	Permission   string `json:"permission"`
+}
+
+const AllPermissions = "*"
+
+func (r DenyRule) Validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("deny rule name is empty")
+	}
+	principals := 0
+	for _, id := range []int64{r.UserID, r.GroupID, r.RoleID} {
+		if id != 0 {
+			principals++
+		}
+	}
+	if principals != 1 {
+		return fmt.Errorf("deny rule needs exactly one of user, group or role")
+	}
+	if err := validateTablePattern(r.TablePattern); err != nil {
+		return err
+	}
+	return validatePermissionOrAll(r.Permission)
+}
+
+func validatePermissionOrAll(permission string) error {
+	if permission != AllPermissions && !slices.Contains(defaultPermissions, permission) {
+		return fmt.Errorf("invalid permission %s. should be '%s' or one of '%s'", permission, AllPermissions, strings.Join(defaultPermissions, ","))
+	}
+	return nil
+}
+
+// Matches reports whether the rule denies permission on the table.
+func (r DenyRule) Matches(tableName, permission string) bool {
+	if r.Permission != AllPermissions && r.Permission != permission {
+		return false
+	}
+	return matchTable(r.TablePattern, tableName)
 }
 
 type Table struct {
@@ -116,15 +536,121 @@ type AdminStore interface {
 	CreateTable(ctx context.Context, opts CreateTableOptions) error
 	// return a created table
 	GetTable(ctx context.Context, tableName string) (*Table, error)
-	// returns token after adding user successfully
-	// returns existing token if user is already present
+	// drop a table from its user store and forget about it,
+	// grants on the table are removed as well
+	DropTable(ctx context.Context, tableName string) error
+	// returns token after adding user successfully, the token is only
+	// returned once as just a hash of it is stored,
+	// fails if user is already present
 	AddUser(ctx context.Context, userName string) (string, error)
-	// returns user for token
+	// returns user for any active api key of the user,
+	// disabled users are not returned
 	GetUser(ctx context.Context, token string) (*User, error)
-	// no op if (token,table,permission) already exists
-	AddPermission(ctx context.Context, userID int64, tableName, permission string) error
-	// returns permissing for a token
+	// mint another key for a user, the token is only returned once
+	AddAPIKey(ctx context.Context, opts APIKeyOptions) (*APIKey, string, error)
+	// returns all keys of a user including revoked and expired ones
+	GetAPIKeys(ctx context.Context, userID int64) ([]APIKey, error)
+	// mint a key replacing keyID, the old key keeps working for the grace
+	// period and is revoked right away without one
+	RotateAPIKey(ctx context.Context, keyID string, gracePeriod time.Duration) (*APIKey, string, error)
+	// a revoked key cannot be used anymore
+	RevokeAPIKey(ctx context.Context, keyID string) error
+	// returns the owner of an active signing key along with the token
+	// requests are signed with, disabled users are not returned
+	GetUserForSigningKey(ctx context.Context, keyID string) (*User, string, error)
+	// records a nonce of a signed request until expiresAt,
+	// fails if the nonce was already used with the key
+	UseNonce(ctx context.Context, keyID, nonce string, expiresAt time.Time) error
+	// returns user for user name, including disabled users
+	GetUserByName(ctx context.Context, userName string) (*User, error)
+	// returns user for user name, the user is added without an api key if
+	// not present, used for users authenticated by other means than api keys
+	EnsureUser(ctx context.Context, userName string) (*User, error)
+	// change the name of a user, the token stays the same
+	RenameUser(ctx context.Context, userID int64, userName string) error
+	// disabled users keep their grants but cannot use their token
+	SetUserDisabled(ctx context.Context, userID int64, disabled bool) error
+	// delete a user along with all of their grants
+	DeleteUser(ctx context.Context, userID int64) error
+	// no op if (token,table,permission) already exists with the same columns,
+	// the columns are replaced otherwise
+	AddPermission(ctx context.Context, opts PermissionOptions) error
+	// no op if (token,table,permission) does not exist
+	RemovePermission(ctx context.Context, userID int64, tableName, permission string) error
+	// returns the grants which end between now and before
+	GetExpiringPermissions(ctx context.Context, before time.Time) ([]UserPermission, error)
+	// returns permissing for a token, the union of the grants of the user
+	// and of every role bound to the user or to one of their groups,
+	// grants outside of their window are included
 	GetPermissionsForToken(ctx context.Context, token string) ([]TablePermission, error)
+	// same as GetPermissionsForToken for a user that was already resolved
+	GetPermissionsForUser(ctx context.Context, userID int64) ([]TablePermission, error)
+	// no op if role already exists
+	AddRole(ctx context.Context, roleName string) error
+	// returns role for role name
+	GetRole(ctx context.Context, roleName string) (*Role, error)
+	// delete a role along with its grants and bindings
+	DeleteRole(ctx context.Context, roleID int64) error
+	// replaces the columns if (role,table,permission) already exists
+	AddRolePermission(ctx context.Context, opts RolePermissionOptions) error
+	// no op if (role,table,permission) does not exist
+	RemoveRolePermission(ctx context.Context, roleID int64, tableName, permission string) error
+	// no op if group already exists
+	AddGroup(ctx context.Context, groupName string) error
+	// returns group for group name
+	GetGroup(ctx context.Context, groupName string) (*Group, error)
+	// delete a group along with its members and bindings
+	DeleteGroup(ctx context.Context, groupID int64) error
+	// no op if user is already a member of the group
+	AddGroupMember(ctx context.Context, groupID, userID int64) error
+	// no op if user is not a member of the group
+	RemoveGroupMember(ctx context.Context, groupID, userID int64) error
+	// makes the memberships of the user from source exactly groupIDs,
+	// memberships from other sources or added by admins are left alone and
+	// nothing is written when nothing changed
+	SyncGroupMembers(ctx context.Context, userID int64, source string, groupIDs []int64) error
+	// returns the groups the user is a member of
+	GetUserGroups(ctx context.Context, userID int64) ([]Group, error)
+	// no op if binding already exists
+	BindRole(ctx context.Context, binding RoleBinding) error
+	// no op if binding does not exist
+	UnbindRole(ctx context.Context, binding RoleBinding) error
+	// replaces all values of a user attribute, an empty list removes it
+	SetUserAttribute(ctx context.Context, userID int64, name string, values []interface{}) error
+	// returns all attributes of a user
+	GetUserAttributes(ctx context.Context, userID int64) (map[string][]interface{}, error)
+	// replaces the policy if (table,name) already exists
+	AddRowPolicy(ctx context.Context, policy RowPolicy) error
+	// no op if (table,name) does not exist
+	RemoveRowPolicy(ctx context.Context, tableName, name string) error
+	// returns the row policies of a table
+	GetRowPolicies(ctx context.Context, tableName string) ([]RowPolicy, error)
+	// replaces the rule if a rule with the same name already exists
+	AddDenyRule(ctx context.Context, rule DenyRule) error
+	// no op if the rule does not exist
+	RemoveDenyRule(ctx context.Context, name string) error
+	// returns the deny rules of a token, the rules of the user and of every
+	// group and role that applies to the user
+	GetDenyRulesForToken(ctx context.Context, token string) ([]DenyRule, error)
+	// same as GetDenyRulesForToken for a user that was already resolved
+	GetDenyRulesForUser(ctx context.Context, userID int64) ([]DenyRule, error)
+	// creates the first superadmin and returns its token,
+	// fails once any admin exists
+	BootstrapAdmin(ctx context.Context, adminName string) (string, error)
+	// returns token after adding admin successfully, the token is only
+	// returned once, fails if admin is already present
+	AddAdmin(ctx context.Context, opts AdminOptions) (string, error)
+	// returns admin for token
+	GetAdmin(ctx context.Context, token string) (*Admin, error)
+	// returns admin for admin name
+	GetAdminByName(ctx context.Context, adminName string) (*Admin, error)
+	// replaces the roles of an admin, the last superadmin cannot lose the role
+	SetAdminRoles(ctx context.Context, adminID int64, roles []string) error
+	// mint a key replacing the key of the admin, the old key stops working
+	// right away and the token is only returned once
+	RotateAdminKey(ctx context.Context, adminID int64) (string, error)
+	// delete an admin, the last superadmin cannot be deleted
+	DeleteAdmin(ctx context.Context, adminID int64) error
 }
 
 type CompoundStore interface {
@@ -133,6 +659,8 @@ type CompoundStore interface {
 }
 
 type UserOptions struct {
+	// token of the user, when empty the user is taken from the context,
+	// see ContextWithUser
 	Token string
 }
 
@@ -0,0 +1,97 @@
+package store
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/rs/xid"
+)
+
+// Tokens are handed out as '<prefix>.<secret>'. The prefix is not secret and
+// is stored as is to look the token up, only a salted hash of the secret is
+// stored so a leaked admin database does not leak usable tokens.
+const tokenSeparator = "."
+
+type hashedToken struct {
+	prefix string
+	salt   string
+	hash   string
+}
+
+// newToken returns a new token along with what has to be stored to verify
+// it later.
+func newToken() (string, hashedToken, error) {
+	secret, err := randomHex(32)
+	if err != nil {
+		return "", hashedToken{}, err
+	}
+	return newTokenWithSecret(xid.New().String(), secret)
+}
+
+// newSigningToken returns a new token whose secret is derived from key, so
+// that the server can recompute it to verify signatures without storing it.
+func newSigningToken(key []byte) (string, hashedToken, error) {
+	prefix := xid.New().String()
+	return newTokenWithSecret(prefix, signingSecret(key, prefix))
+}
+
+func newTokenWithSecret(prefix, secret string) (string, hashedToken, error) {
+	salt, err := randomHex(16)
+	if err != nil {
+		return "", hashedToken{}, err
+	}
+
+	ht := hashedToken{
+		prefix: prefix,
+		salt:   salt,
+		hash:   hashSecret(salt, secret),
+	}
+	return ht.prefix + tokenSeparator + secret, ht, nil
+}
+
+// signingSecret derives the secret of the signing token prefix from key.
+func signingSecret(key []byte, prefix string) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(prefix))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// splitToken returns the prefix and the secret of a token. Only the shape
+// newToken hands out is accepted, an xid and a hex secret, so that tokens of
+// other issuers such as JWTs are not taken for malformed tokens of ours.
+func splitToken(token string) (string, string, error) {
+	prefix, secret, ok := strings.Cut(token, tokenSeparator)
+	if !ok || strings.Contains(secret, tokenSeparator) {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	if _, err := xid.FromString(prefix); err != nil {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	if _, err := hex.DecodeString(secret); err != nil || secret == "" {
+		return "", "", fmt.Errorf("malformed token")
+	}
+	return prefix, secret, nil
+}
+
+// verify reports whether secret matches the stored hash.
+func (t hashedToken) verify(secret string) bool {
+	return subtle.ConstantTimeCompare([]byte(hashSecret(t.salt, secret)), []byte(t.hash)) == 1
+}
+
+func hashSecret(salt, secret string) string {
+	sum := sha256.Sum256([]byte(salt + secret))
+	return hex.EncodeToString(sum[:])
+}
+
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}